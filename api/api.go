@@ -2,18 +2,67 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"regexp"
-
+	"strings"
+
+	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/coalesce"
+	"github.com/Frontman-Labs/frontman/compression"
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/Frontman-Labs/frontman/cors"
+	"github.com/Frontman-Labs/frontman/files"
+	"github.com/Frontman-Labs/frontman/grpcproxy"
+	"github.com/Frontman-Labs/frontman/ipfilter"
+	"github.com/Frontman-Labs/frontman/limits"
 	"github.com/Frontman-Labs/frontman/loadbalancer"
+	"github.com/Frontman-Labs/frontman/mirror"
+	"github.com/Frontman-Labs/frontman/openapi"
+	"github.com/Frontman-Labs/frontman/response"
 
 	"github.com/Frontman-Labs/frontman/service"
+	"github.com/Frontman-Labs/frontman/transform"
 	"github.com/julienschmidt/httprouter"
 )
 
-func NewServicesRouter(backendServices service.ServiceRegistry) *httprouter.Router {
+type routerOptions struct {
+	issuer   *auth.TokenIssuer
+	ipFilter *ipfilter.Filter
+	cache    *cache.Cache
+}
+
+type RouterOption func(*routerOptions)
+
+// WithTokenIssuer publishes the public keys of the gateway token issuer
+func WithTokenIssuer(issuer *auth.TokenIssuer) RouterOption {
+	return func(o *routerOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithIPFilter exposes the global IP rules of the gateway for editing
+func WithIPFilter(filter *ipfilter.Filter) RouterOption {
+	return func(o *routerOptions) {
+		o.ipFilter = filter
+	}
+}
+
+// WithCache allows purging the response cache of the gateway
+func WithCache(c *cache.Cache) RouterOption {
+	return func(o *routerOptions) {
+		o.cache = c
+	}
+}
+
+func NewServicesRouter(backendServices service.ServiceRegistry, opts ...RouterOption) *httprouter.Router {
+	options := &routerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	router := httprouter.New()
 
 	router.GET("/api/services", getServicesHandler(backendServices))
@@ -21,6 +70,29 @@ func NewServicesRouter(backendServices service.ServiceRegistry) *httprouter.Rout
 	router.DELETE("/api/services/:name", removeServiceHandler(backendServices))
 	router.PUT("/api/services/:name", updateServiceHandler(backendServices))
 	router.GET("/api/health", getHealthHandler(backendServices))
+	router.GET("/api/mirrors", getMirrorsHandler(backendServices))
+	router.GET("/api/routes", getRoutesHandler(backendServices))
+	router.POST("/api/routes", addRouteHandler(backendServices))
+	router.POST("/api/routes/explain", explainRouteHandler(backendServices))
+	router.PUT("/api/routes/:name", updateRouteHandler(backendServices))
+	router.PUT("/api/routes/:name/split", updateRouteSplitHandler(backendServices))
+	router.DELETE("/api/routes/:name", removeRouteHandler(backendServices))
+	router.GET("/api/secrets", getSecretsHandler(backendServices))
+	router.PUT("/api/secrets/:keyId", setSecretHandler(backendServices))
+	router.DELETE("/api/secrets/:keyId", removeSecretHandler(backendServices))
+
+	if options.ipFilter != nil {
+		router.GET("/api/ipfilter", getIPFilterHandler(options.ipFilter))
+		router.PUT("/api/ipfilter", updateIPFilterHandler(options.ipFilter))
+	}
+
+	if options.cache != nil {
+		router.DELETE("/api/cache/:service", purgeCacheHandler(options.cache))
+	}
+
+	if options.issuer != nil {
+		router.GET("/.well-known/jwks.json", getJWKSHandler(options.issuer))
+	}
 
 	return router
 }
@@ -52,6 +124,152 @@ func getHealthHandler(bs service.ServiceRegistry) httprouter.Handle {
 	}
 }
 
+func getMirrorsHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		stats := make(map[string]mirror.Stats)
+		for _, s := range bs.GetServices() {
+			if m := s.GetMirror(); m != nil {
+				stats[s.Name] = m.Stats()
+			}
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+func getSecretsHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		// Only key IDs are listed, secrets are never returned
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(bs.GetSecrets().GetKeyIDs())
+	}
+}
+
+func setSecretHandler(bs service.ServiceRegistry) httprouter.Handle {
+	type Request struct {
+		Secret string `json:"secret"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		keyID := params.ByName("keyId")
+		var body Request
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.Secret == "" {
+			http.Error(w, "secret is a required field", http.StatusBadRequest)
+			return
+		}
+
+		err = bs.GetSecrets().SetSecret(keyID, body.Secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"keyId": keyID})
+	}
+}
+
+func removeSecretHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		keyID := params.ByName("keyId")
+		err := bs.GetSecrets().RemoveSecret(keyID)
+		if errors.Is(err, auth.ErrUnknownKeyID) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Removed secret " + keyID})
+	}
+}
+
+func getJWKSHandler(issuer *auth.TokenIssuer) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		jsonData, err := json.Marshal(issuer.PublicKeys())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		prepareHeaders(w, http.StatusOK)
+		w.Write(jsonData)
+	}
+}
+
+func getIPFilterHandler(filter *ipfilter.Filter) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(filter.Config())
+	}
+}
+
+// purgeCacheHandler removes the cached responses of a service, all of them or only those of a
+// key or upstream path prefix
+func purgeCacheHandler(c *cache.Cache) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name := ps.ByName("service")
+		key := r.URL.Query().Get("key")
+		prefix := r.URL.Query().Get("prefix")
+
+		var purged int
+		var err error
+		switch {
+		case key != "" && prefix != "":
+			http.Error(w, "key and prefix cannot be combined", http.StatusBadRequest)
+			return
+		case key != "":
+			if !strings.HasPrefix(key, name+":") {
+				http.Error(w, fmt.Sprintf("key does not belong to service %s", name), http.StatusBadRequest)
+				return
+			}
+			purged, err = c.PurgeKey(r.Context(), key)
+		case prefix != "":
+			purged, err = c.PurgePrefix(r.Context(), name, prefix)
+		default:
+			purged, err = c.PurgeService(r.Context(), name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+	}
+}
+
+func updateIPFilterHandler(filter *ipfilter.Filter) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var conf config.IPFilterConfig
+		err := json.NewDecoder(r.Body).Decode(&conf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The new rules apply to the next request handled by the gateway
+		err = filter.Update(conf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(conf)
+	}
+}
+
 func addServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		// Parse the request body as a BackendService object
@@ -72,7 +290,7 @@ func addServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 		// Add the service to the list of backend services
 		err = bs.AddService(&service)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), registryErrorStatus(err))
 			return
 		}
 
@@ -82,6 +300,23 @@ func addServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 	}
 }
 
+// registryErrorStatus maps errors returned when storing a service to a status code
+func registryErrorStatus(err error) int {
+	var (
+		conflict    service.ErrRouteConflict
+		routeExists service.ErrRouteExists
+		inUse       service.ErrServiceInUse
+		notFound    service.ErrRouteNotFound
+	)
+	switch {
+	case errors.As(err, &conflict), errors.As(err, &routeExists), errors.As(err, &inUse):
+		return http.StatusConflict
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
 func updateServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 		name := params.ByName("name")
@@ -104,7 +339,7 @@ func updateServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 		// Update the service in the list of backend services
 		err = bs.UpdateService(&service)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), registryErrorStatus(err))
 			return
 		}
 
@@ -124,7 +359,7 @@ func removeServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 		name := params.ByName("name")
 		err := bs.RemoveService(name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), registryErrorStatus(err))
 			return
 		}
 
@@ -135,24 +370,180 @@ func removeServiceHandler(bs service.ServiceRegistry) httprouter.Handle {
 	}
 }
 
-func validateService(service *service.BackendService) error {
-	// Validate that the required fields are present
-	if service.Path == "" {
-		return fmt.Errorf("path is a required field")
+func getRoutesHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(bs.GetRoutes())
 	}
+}
 
-	// Validate that at least one upstream target is specified and that each target is a valid URL
-	if len(service.UpstreamTargets) < 1 {
-		return fmt.Errorf("at least one upstream target is required")
+func addRouteHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var route service.Route
+		err := json.NewDecoder(r.Body).Decode(&route)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = route.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		route.Init()
+
+		err = bs.AddRoute(&route)
+		if err != nil {
+			http.Error(w, err.Error(), routeErrorStatus(err))
+			return
+		}
+
+		prepareHeaders(w, http.StatusCreated)
+		json.NewEncoder(w).Encode(route)
 	}
-	for _, target := range service.UpstreamTargets {
-		u, err := url.Parse(target)
+}
+
+func updateRouteHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		var route service.Route
+		err := json.NewDecoder(r.Body).Decode(&route)
 		if err != nil {
-			return fmt.Errorf("Invalid upstream target: " + target)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		if u.Scheme == "" {
-			return fmt.Errorf("Upstream target " + target + " must include a scheme (e.g., 'http' or 'https')")
+
+		route.Name = params.ByName("name")
+
+		err = route.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		route.Init()
+
+		err = bs.UpdateRoute(&route)
+		if err != nil {
+			http.Error(w, err.Error(), routeErrorStatus(err))
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(route)
+	}
+}
+
+// updateRouteSplitHandler adjusts the backends and weights of a traffic split, keeping the other
+// settings of the route, so a canary can be ramped up step by step
+func updateRouteSplitHandler(bs service.ServiceRegistry) httprouter.Handle {
+	type Request struct {
+		Backends []service.WeightedBackend `json:"backends"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		var body Request
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		route, err := bs.UpdateRouteSplit(name, body.Backends)
+		if err != nil {
+			http.Error(w, err.Error(), routeErrorStatus(err))
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(route)
+	}
+}
+
+func removeRouteHandler(bs service.ServiceRegistry) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		name := params.ByName("name")
+		err := bs.RemoveRoute(name)
+		if err != nil {
+			http.Error(w, err.Error(), registryErrorStatus(err))
+			return
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Removed route " + name})
+	}
+}
+
+func explainRouteHandler(bs service.ServiceRegistry) httprouter.Handle {
+	type Request struct {
+		Method  string            `json:"method"`
+		Host    string            `json:"host"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers"`
+	}
+	type Response struct {
+		Route      string                   `json:"route,omitempty"`
+		Service    string                   `json:"service,omitempty"`
+		Params     service.Params           `json:"params,omitempty"`
+		Candidates []service.RouteCandidate `json:"candidates"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var body Request
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		if body.Method == "" {
+			body.Method = http.MethodGet
+		}
+		if !strings.HasPrefix(body.Path, "/") {
+			body.Path = "/" + body.Path
+		}
+
+		// Routing only looks at the request, so an equivalent request is evaluated without
+		// sending any traffic
+		req, err := http.NewRequest(body.Method, "http://"+body.Host+body.Path, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for k, v := range body.Headers {
+			req.Header.Set(k, v)
+		}
+
+		resp := Response{Candidates: bs.GetTrie().Explain(req)}
+		for _, c := range resp.Candidates {
+			if c.Selected {
+				resp.Route = c.Route
+				resp.Service = c.Service
+				resp.Params = c.Params
+			}
+		}
+
+		prepareHeaders(w, http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// routeErrorStatus maps errors returned when storing a route to a status code.
+// A route referencing an unknown service or failing validation is a client error.
+func routeErrorStatus(err error) int {
+	var (
+		notFound service.ErrServiceNotFound
+		invalid  service.ErrInvalidRoute
+		notSplit service.ErrRouteNotSplit
+	)
+	if errors.As(err, &notFound) || errors.As(err, &invalid) || errors.As(err, &notSplit) {
+		return http.StatusBadRequest
+	}
+	return registryErrorStatus(err)
+}
+
+func validateService(service *service.BackendService) error {
+	if err := validateServiceType(service); err != nil {
+		return err
 	}
 
 	// If the scheme is not specified, default to "http"
@@ -181,6 +572,94 @@ func validateService(service *service.BackendService) error {
 		return err
 	}
 
+	err = validateDomain(service)
+	if err != nil {
+		return err
+	}
+
+	if service.CORS != nil {
+		if _, err := cors.NewPolicy(service.CORS); err != nil {
+			return err
+		}
+	}
+
+	if service.Match != nil {
+		if err := service.Match.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if service.Headers != nil {
+		if err := service.Headers.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if service.BodyTransform != nil {
+		if _, err := transform.NewTransformer(service.BodyTransform); err != nil {
+			return err
+		}
+	}
+
+	if service.Cache != nil {
+		if _, err := cache.NewPolicy(service.Cache, service.AuthConfig != nil); err != nil {
+			return err
+		}
+	}
+
+	if service.Coalesce != nil {
+		if _, err := coalesce.NewCoalescer(service.Coalesce); err != nil {
+			return err
+		}
+	}
+
+	if service.Compression != nil {
+		if _, err := compression.NewPolicy(service.Compression); err != nil {
+			return err
+		}
+	}
+
+	if service.Limits != nil {
+		if _, err := limits.NewLimits(service.Limits); err != nil {
+			return err
+		}
+	}
+
+	if service.OpenAPI != nil {
+		if _, err := openapi.NewValidator(service.OpenAPI); err != nil {
+			return err
+		}
+	}
+
+	if service.GRPC != nil {
+		if _, err := grpcproxy.NewTranscoder(service.GRPC); err != nil {
+			return err
+		}
+	}
+
+	if service.Mirror != nil {
+		if _, err := mirror.NewMirror(service.Mirror); err != nil {
+			return err
+		}
+	}
+
+	if service.IPFilter != nil {
+		if _, err := ipfilter.NewRules(service.IPFilter); err != nil {
+			return err
+		}
+	}
+
+	// The internal token asserts the identity of the client, which only an auth config checks
+	if service.InternalToken != nil && service.AuthConfig == nil {
+		return fmt.Errorf("internal token requires an auth config")
+	}
+
+	if service.UpstreamAuth != nil {
+		if _, err := auth.GetUpstreamCredentials(*service.UpstreamAuth); err != nil {
+			return fmt.Errorf("invalid upstream auth: %w", err)
+		}
+	}
+
 	service.Init()
 
 	return nil
@@ -208,16 +687,77 @@ func validateLoadBalancerPolicy(bs *service.BackendService) error {
 	return nil
 }
 
-func validateMatchPath(bs *service.BackendService) error {
-	if bs.RewriteMatch == "" || bs.RewriteReplace == "" {
-		return nil
-	}
-	_, err := regexp.Compile(bs.RewriteMatch)
-	if err != nil {
+// validateServiceType checks that the service has what its type needs to answer requests
+func validateServiceType(bs *service.BackendService) error {
+	switch bs.Type {
+	case "", service.ServiceTypeProxy:
+		return validateUpstreamTargets(bs)
+	case service.ServiceTypeRedirect:
+		if bs.Redirect == nil {
+			return fmt.Errorf("redirect services require a redirect")
+		}
+		_, err := response.NewRedirect(bs.Redirect)
+		return err
+	case service.ServiceTypeResponse:
+		if bs.Response == nil {
+			return fmt.Errorf("response services require a response")
+		}
+		_, err := response.NewStatic(bs.Response)
 		return err
+	case service.ServiceTypeStatic:
+		if bs.Static == nil {
+			return fmt.Errorf("static services require a static directory")
+		}
+		_, err := files.NewServer(bs.Static)
+		return err
+	default:
+		return fmt.Errorf("invalid service type %s, expected proxy, redirect, response or static", bs.Type)
 	}
+}
 
-	return nil
+func validateUpstreamTargets(bs *service.BackendService) error {
+	// Validate that at least one upstream target is specified and that each target is a valid URL
+	if len(bs.UpstreamTargets) < 1 {
+		return fmt.Errorf("at least one upstream target is required")
+	}
+	for _, target := range bs.UpstreamTargets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf("Invalid upstream target: " + target)
+		}
+		if u.Scheme == "" {
+			return fmt.Errorf("Upstream target " + target + " must include a scheme (e.g., 'http' or 'https')")
+		}
+		// HTTP/2 is negotiated over TLS, h2c is spoken in cleartext
+		switch {
+		case bs.Protocol == service.ProtocolHTTP2 && u.Scheme != "https":
+			return fmt.Errorf("upstream target %s must use https with the http2 protocol", target)
+		case bs.Protocol == service.ProtocolH2C && u.Scheme != "http":
+			return fmt.Errorf("upstream target %s must use http with the h2c protocol", target)
+		case bs.Scheme == service.SchemeGRPC && u.Scheme != "http" && u.Scheme != "https":
+			return fmt.Errorf("upstream target %s of a grpc service must use http or https", target)
+		}
+	}
+	if bs.Scheme == service.SchemeGRPC && bs.Protocol == service.ProtocolHTTP1 {
+		return fmt.Errorf("grpc services require HTTP/2, use the http2 or h2c protocol")
+	}
+	if bs.GRPC != nil && bs.Scheme != service.SchemeGRPC {
+		return fmt.Errorf("grpc transcoding requires the grpc scheme")
+	}
+	switch bs.Protocol {
+	case "", service.ProtocolHTTP1, service.ProtocolHTTP2, service.ProtocolH2C:
+		return nil
+	default:
+		return fmt.Errorf("invalid protocol %s, expected http1, http2 or h2c", bs.Protocol)
+	}
+}
+
+func validateMatchPath(bs *service.BackendService) error {
+	return bs.ValidateRewrite()
+}
+
+func validateDomain(bs *service.BackendService) error {
+	return service.ValidateDomain(bs.Domain)
 }
 
 func prepareHeaders(w http.ResponseWriter, code int) {
@@ -7,8 +7,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/config"
 	"github.com/Frontman-Labs/frontman/loadbalancer"
 	"github.com/Frontman-Labs/frontman/service"
 	"github.com/julienschmidt/httprouter"
@@ -106,6 +112,151 @@ func TestAddServiceHandler(t *testing.T) {
 	}
 }
 
+// TestAddDirectResponseServiceHandler tests adding services answering without an upstream
+func TestAddDirectResponseServiceHandler(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+
+	testCases := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"Redirect", `{"name":"moved","type":"redirect","path":"/old","redirect":{"statusCode":301,"path":"/new"}}`, http.StatusCreated},
+		{"Static response", `{"name":"maintenance","type":"response","path":"/shop","response":{"statusCode":503,"body":"Back soon"}}`, http.StatusCreated},
+		{"Missing redirect", `{"name":"moved-2","type":"redirect","path":"/old-2"}`, http.StatusBadRequest},
+		{"Invalid redirect status", `{"name":"moved-3","type":"redirect","path":"/old-3","redirect":{"statusCode":200}}`, http.StatusBadRequest},
+		{"Invalid response status", `{"name":"maintenance-2","type":"response","path":"/shop-2","response":{"statusCode":999}}`, http.StatusBadRequest},
+		{"Missing static root", `{"name":"frontend","type":"static","path":"/app","static":{"root":"/nonexistent/frontend"}}`, http.StatusBadRequest},
+		{"Unknown type", `{"name":"other","type":"tunnel","path":"/other"}`, http.StatusBadRequest},
+		{"Proxy without upstream", `{"name":"proxy","type":"proxy","path":"/proxy"}`, http.StatusBadRequest},
+	}
+
+	handler := addServiceHandler(reg)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/services", bytes.NewBufferString(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, nil)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+
+	if len(reg.GetServices()) != 2 {
+		t.Errorf("Expected 2 services to be added to the backend service registry, but got %v", len(reg.GetServices()))
+	}
+}
+
+// TestAddServiceRewriteValidation tests that invalid rewrites are rejected
+func TestAddServiceRewriteValidation(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+
+	testCases := []struct {
+		name       string
+		rewrite    string
+		statusCode int
+	}{
+		{"Template rewrite", `"rewrite":{"path":"/v2/{id}","host":"{header.X-Region}.internal","query":{"remove":["debug"]}}`, http.StatusCreated},
+		{"Legacy rewrite", `"rewriteMatch":"^/a/(.*)$","rewriteReplace":"/b/$1"`, http.StatusCreated},
+		{"Only rewrite match", `"rewriteMatch":"^/a/(.*)$"`, http.StatusBadRequest},
+		{"Only rewrite replace", `"rewriteReplace":"/b"`, http.StatusBadRequest},
+		{"Invalid regular expression", `"rewrite":{"match":"(","path":"/b"}`, http.StatusBadRequest},
+		{"Match without path", `"rewrite":{"match":"^/a"}`, http.StatusBadRequest},
+		{"Unclosed placeholder", `"rewrite":{"path":"/v2/{id"}`, http.StatusBadRequest},
+		{"Unknown placeholder", `"rewrite":{"host":"{cookie.region}"}`, http.StatusBadRequest},
+		{"Rename without target", `"rewrite":{"query":{"rename":{"q":""}}}`, http.StatusBadRequest},
+		{"Both rewrites", `"rewriteMatch":"^/a","rewriteReplace":"/b","rewrite":{"path":"/c"}`, http.StatusBadRequest},
+	}
+
+	handler := addServiceHandler(reg)
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"name":"service-%d","path":"/service-%d","upstreamTargets":["http://localhost:8080"],%s}`, i, i, tc.rewrite)
+			req, err := http.NewRequest("POST", "/api/services", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, nil)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestAddServiceHeaderRulesValidation tests that invalid header rules are rejected
+func TestAddServiceHeaderRulesValidation(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+
+	testCases := []struct {
+		name       string
+		headers    string
+		statusCode int
+	}{
+		{"Valid rules", `{"request":[{"action":"set","name":"X-User","value":"{claim.sub}"}],"response":[{"action":"remove","name":"Server"}]}`, http.StatusCreated},
+		{"Unknown action", `{"request":[{"action":"replace","name":"X-User"}]}`, http.StatusBadRequest},
+		{"Missing name", `{"request":[{"action":"set","value":"1"}]}`, http.StatusBadRequest},
+		{"Rename without new name", `{"response":[{"action":"rename","name":"X-Old"}]}`, http.StatusBadRequest},
+		{"Remove with value", `{"request":[{"action":"remove","name":"X-Old","value":"1"}]}`, http.StatusBadRequest},
+		{"Invalid template", `{"request":[{"action":"set","name":"X-Env","value":"{env.}"}]}`, http.StatusBadRequest},
+	}
+
+	handler := addServiceHandler(reg)
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"name":"service-%d","path":"/service-%d","upstreamTargets":["http://localhost:8080"],"headers":%s}`, i, i, tc.headers)
+			req, err := http.NewRequest("POST", "/api/services", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, nil)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestAddServiceInternalTokenValidation tests that internal tokens require an auth config
+func TestAddServiceInternalTokenValidation(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+
+	testCases := []struct {
+		name       string
+		auth       string
+		statusCode int
+	}{
+		{"With auth", `,"auth":{"type":"basic","basic":{"username":"test","password":"test"}}`, http.StatusCreated},
+		{"Without auth", ``, http.StatusBadRequest},
+	}
+
+	handler := addServiceHandler(reg)
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"name":"service-%d","path":"/service-%d","upstreamTargets":["http://localhost:8080"],"internalToken":{"audience":"orders"}%s}`, i, i, tc.auth)
+			req, err := http.NewRequest("POST", "/api/services", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, nil)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+}
+
 // TestRemoveServiceHandler tests the removeServiceHandler function
 func TestRemoveServiceHandler(t *testing.T) {
 	// Create a new request
@@ -122,7 +273,7 @@ func TestRemoveServiceHandler(t *testing.T) {
 
 	// Call the handler function
 	handler := removeServiceHandler(reg)
-	handler(rr, req, httprouter.Params{{"name", "test"}})
+	handler(rr, req, httprouter.Params{{Key: "name", Value: "test"}})
 
 	// Check the status code
 	if status := rr.Code; status != http.StatusInternalServerError {
@@ -135,3 +286,199 @@ func TestRemoveServiceHandler(t *testing.T) {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 }
+
+// TestGetJWKSHandler tests the getJWKSHandler function
+func TestGetJWKSHandler(t *testing.T) {
+	issuer, err := auth.NewTokenIssuer(&config.TokenIssuerConfig{KeyID: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	router := NewServicesRouter(nil, WithTokenIssuer(issuer))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0]["kid"] != "gateway" {
+		t.Errorf("Handler returned unexpected key set: %v", rr.Body.String())
+	}
+	if _, ok := jwks.Keys[0]["d"]; ok {
+		t.Errorf("Handler leaked the private key: %v", rr.Body.String())
+	}
+}
+
+// TestPurgeCacheHandler tests the purgeCacheHandler function
+func TestPurgeCacheHandler(t *testing.T) {
+	store := cache.NewMemoryStore(0)
+	for _, key := range []string{"catalog:/items GET", "catalog:/items GET#Accept-Language=en", "catalog:/users GET", "other:/items GET"} {
+		store.Set(context.Background(), key, &cache.Entry{Status: http.StatusOK, Header: http.Header{}}, time.Minute)
+	}
+	router := NewServicesRouter(nil, WithCache(cache.New(store)))
+
+	testCases := []struct {
+		name       string
+		url        string
+		statusCode int
+		expected   string
+	}{
+		{"Key and its variants", "/api/cache/catalog?key=" + url.QueryEscape("catalog:/items GET"), http.StatusOK, `{"purged":2}`},
+		{"Key of another service", "/api/cache/catalog?key=" + url.QueryEscape("other:/items GET"), http.StatusBadRequest, ""},
+		{"Key and prefix", "/api/cache/catalog?key=a&prefix=b", http.StatusBadRequest, ""},
+		{"Prefix", "/api/cache/catalog?prefix=/us", http.StatusOK, `{"purged":1}`},
+		{"Service", "/api/cache/other", http.StatusOK, `{"purged":1}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("DELETE", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+			if tc.expected != "" && strings.TrimSpace(rr.Body.String()) != tc.expected {
+				t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), tc.expected)
+			}
+		})
+	}
+}
+
+// TestAddRouteHandler tests the addRouteHandler function
+func TestAddRouteHandler(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+	reg.AddService(&service.BackendService{Name: "orders", UpstreamTargets: []string{"http://localhost:8080"}})
+
+	testCases := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"OK", `{"name":"orders-v1","service":"orders","paths":["/v1/orders"]}`, http.StatusCreated},
+		{"Duplicate name", `{"name":"orders-v1","service":"orders","paths":["/orders"]}`, http.StatusConflict},
+		{"Unknown service", `{"name":"invoices","service":"invoices","paths":["/invoices"]}`, http.StatusBadRequest},
+		{"Missing paths", `{"name":"orders-v2","service":"orders"}`, http.StatusBadRequest},
+		{"Parameter path", `{"name":"orders-v3","service":"orders","paths":["/v1/:version"]}`, http.StatusCreated},
+		{"Ambiguous parameter", `{"name":"orders-v4","service":"orders","paths":["/v1/:id/items"]}`, http.StatusConflict},
+	}
+
+	handler := addRouteHandler(reg)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/routes", bytes.NewBufferString(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, nil)
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+
+	if len(reg.GetRoutes()) != 2 {
+		t.Errorf("Expected 2 routes to be added to the registry, but got %v", len(reg.GetRoutes()))
+	}
+}
+
+// TestExplainRouteHandler tests the explainRouteHandler function
+func TestExplainRouteHandler(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+	reg.AddService(&service.BackendService{Name: "users", Path: "/users/:id", UpstreamTargets: []string{"http://localhost:8080"}})
+	reg.AddService(&service.BackendService{Name: "admin", Path: "/users", UpstreamTargets: []string{"http://localhost:8080"}, Match: &service.MatchConditions{
+		Headers: []service.ValueMatcher{{Name: "X-Admin", Exact: "1"}},
+	}})
+
+	body := `{"method":"GET","host":"localhost","path":"/users/42","headers":{"X-Admin":"1"}}`
+	req, err := http.NewRequest("POST", "/api/routes/explain", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	explainRouteHandler(reg)(rr, req, nil)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var resp struct {
+		Route      string                   `json:"route"`
+		Service    string                   `json:"service"`
+		Params     map[string]string        `json:"params"`
+		Candidates []service.RouteCandidate `json:"candidates"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Service != "users" || resp.Params["id"] != "42" {
+		t.Errorf("Handler returned unexpected match: %v", rr.Body.String())
+	}
+	if len(resp.Candidates) != 2 || resp.Candidates[1].Route != "admin" || resp.Candidates[1].Selected {
+		t.Errorf("Handler returned unexpected candidates: %v", rr.Body.String())
+	}
+}
+
+// TestUpdateRouteSplitHandler tests the updateRouteSplitHandler function
+func TestUpdateRouteSplitHandler(t *testing.T) {
+	reg, _ := service.NewServiceRegistry(context.Background(), "memory", nil)
+	reg.AddService(&service.BackendService{Name: "v1", UpstreamTargets: []string{"http://localhost:8080"}})
+	reg.AddService(&service.BackendService{Name: "v2", UpstreamTargets: []string{"http://localhost:8081"}})
+	reg.AddRoute(&service.Route{Name: "orders", Paths: []string{"/orders"}, Split: &service.TrafficSplit{
+		Backends: []service.WeightedBackend{{Service: "v1", Weight: 95}, {Service: "v2", Weight: 5}},
+		HashOn:   service.HashOnClientIP,
+	}})
+	reg.AddRoute(&service.Route{Name: "single", Service: "v1", Paths: []string{"/single"}})
+
+	testCases := []struct {
+		name       string
+		route      string
+		body       string
+		statusCode int
+	}{
+		{"OK", "orders", `{"backends":[{"service":"v1","weight":50},{"service":"v2","weight":50}]}`, http.StatusOK},
+		{"Unknown service", "orders", `{"backends":[{"service":"v3","weight":50}]}`, http.StatusBadRequest},
+		{"Zero total weight", "orders", `{"backends":[{"service":"v1","weight":0}]}`, http.StatusBadRequest},
+		{"Route without split", "single", `{"backends":[{"service":"v1","weight":1}]}`, http.StatusBadRequest},
+		{"Unknown route", "missing", `{"backends":[{"service":"v1","weight":1}]}`, http.StatusNotFound},
+	}
+
+	handler := updateRouteSplitHandler(reg)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "/api/routes/"+tc.route+"/split", bytes.NewBufferString(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req, httprouter.Params{{Key: "name", Value: tc.route}})
+
+			if status := rr.Code; status != tc.statusCode {
+				t.Errorf("Handler returned wrong status code: got %v want %v: %s", status, tc.statusCode, rr.Body.String())
+			}
+		})
+	}
+
+	route := reg.GetRoutes()[0]
+	if route.Split.Backends[1].Weight != 50 || route.Split.HashOn != service.HashOnClientIP {
+		t.Errorf("Expected the weights to be updated and the other split settings kept, got %+v", route.Split)
+	}
+}
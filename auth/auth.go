@@ -10,12 +10,14 @@ type TokenValidator interface {
 	ValidateToken(request *http.Request) (map[string]interface{}, error)
 }
 
-func GetTokenValidator(conf config.AuthConfig) (TokenValidator, error) {
+func GetTokenValidator(conf config.AuthConfig, secrets SecretStore, nonces *NonceCache) (TokenValidator, error) {
 	switch conf.AuthType {
 	case "jwt":
 		return NewJWTValidator(conf.JWT)
 	case "basic":
 		return NewBasicAuthValidator(conf.BasicAuthConfig)
+	case "hmac":
+		return NewHMACValidator(conf.HMAC, secrets, nonces)
 	default:
 		return nil, errors.New("Unrecognized auth type specified")
 	}
@@ -0,0 +1,495 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const (
+	HMACSHA256 string = "hmac-sha256"
+	HMACSHA512 string = "hmac-sha512"
+
+	DefaultHMACClockSkew   time.Duration = 300
+	DefaultHMACMaxBodySize int64         = 10 << 20
+
+	headerSignature      = "Signature"
+	headerSignatureInput = "Signature-Input"
+	headerContentDigest  = "Content-Digest"
+)
+
+var (
+	ErrMissingSignature     = errors.New("missing signature")
+	ErrUnknownKeyID         = errors.New("unknown key id")
+	ErrBadFormatSignature   = errors.New("invalid format for signature")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrMissingSignedHeader  = errors.New("required component is not signed")
+	ErrRequestExpired       = errors.New("signature created outside of allowed clock skew")
+	ErrReplayedRequest      = errors.New("request has already been received")
+	ErrDigestMismatch       = errors.New("content digest does not match")
+	ErrRequestBodyTooLarge  = errors.New("request body too large to verify")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+)
+
+// SecretStore looks up shared secrets by key ID
+type SecretStore interface {
+	GetSecret(keyID string) (string, error)
+}
+
+// HMACValidator validates requests signed with a shared secret following HTTP Message Signatures
+// (RFC 9421):
+//
+//	Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:
+//	Signature-Input: sig1=("@method" "@authority" "@path" "@query" "content-digest");created=1654634495;keyid="partner";alg="hmac-sha256"
+//	Signature: sig1=:<base64>:
+//
+// The body is covered through its Content-Digest (RFC 9530).
+type HMACValidator struct {
+	secrets     SecretStore
+	algorithm   string
+	label       string
+	components  []string
+	clockSkew   time.Duration
+	maxBodySize int64
+	nonces      *NonceCache
+}
+
+// NewHMACValidator creates a validator checking signatures against the secrets of the store.
+// Validators sharing the store should share its nonce cache so that a signature is only accepted
+// once across services and rebuilt validators; a nil cache gives the validator its own.
+func NewHMACValidator(cfg *config.HMACConfig, secrets SecretStore, nonces *NonceCache) (*HMACValidator, error) {
+	if cfg == nil {
+		cfg = &config.HMACConfig{}
+	}
+	if nonces == nil {
+		nonces = NewNonceCache()
+	}
+
+	algorithm := strings.ToLower(cfg.Algorithm)
+	if algorithm == "" {
+		algorithm = HMACSHA256
+	}
+	if hashFor(algorithm) == nil {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	clockSkew := cfg.ClockSkew
+	if clockSkew == 0 {
+		clockSkew = DefaultHMACClockSkew
+	}
+	maxBodySize := cfg.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultHMACMaxBodySize
+	}
+
+	components := []string{"@method", "@authority", "@path", "@query"}
+	for _, h := range cfg.SignedHeaders {
+		components = append(components, strings.ToLower(h))
+	}
+
+	return &HMACValidator{
+		secrets:     secrets,
+		algorithm:   algorithm,
+		label:       cfg.Label,
+		components:  components,
+		clockSkew:   clockSkew * time.Second,
+		maxBodySize: maxBodySize,
+		nonces:      nonces,
+	}, nil
+}
+
+func hashFor(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case HMACSHA256:
+		return sha256.New
+	case HMACSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// signatureInput is a member of the Signature-Input field: the covered components and the
+// signature parameters
+type signatureInput struct {
+	label      string
+	components []string
+	params     []sfParam
+}
+
+func (in *signatureInput) param(name string) (interface{}, bool) {
+	for _, p := range in.params {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+func (in *signatureInput) stringParam(name string) string {
+	value, _ := in.param(name)
+	s, _ := value.(string)
+	return s
+}
+
+// serialize returns the value of the @signature-params component
+func (in *signatureInput) serialize() string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, c := range in.components {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(sfQuote(c))
+	}
+	b.WriteByte(')')
+	for _, p := range in.params {
+		b.WriteString(serializeParam(p))
+	}
+	return b.String()
+}
+
+// parseSignatureInput returns the member of the Signature-Input field with the label, or the
+// first member if label is empty
+func parseSignatureInput(field string, label string) (*signatureInput, error) {
+	members, err := parseDictionary(field)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if label != "" && m.name != label {
+			continue
+		}
+		if m.list == nil {
+			return nil, ErrBadFormatSignature
+		}
+		in := &signatureInput{label: m.name, params: m.params}
+		for _, item := range m.list {
+			name, ok := item.value.(string)
+			// Component parameters such as ;sf or ;key are not supported
+			if !ok || len(item.params) > 0 {
+				return nil, ErrBadFormatSignature
+			}
+			in.components = append(in.components, name)
+		}
+		return in, nil
+	}
+	return nil, ErrMissingSignature
+}
+
+// componentValue returns the value of a covered component of the request
+func componentValue(request *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return request.Method, nil
+	case "@target-uri":
+		return requestScheme(request) + "://" + strings.ToLower(request.Host) + request.URL.RequestURI(), nil
+	case "@authority":
+		return strings.ToLower(request.Host), nil
+	case "@scheme":
+		return requestScheme(request), nil
+	case "@request-target":
+		return request.URL.RequestURI(), nil
+	case "@path":
+		if path := request.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + request.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", fmt.Errorf("%w: unsupported component %s", ErrBadFormatSignature, component)
+	}
+
+	values := request.Header.Values(component)
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: signed header %s is missing", ErrBadFormatSignature, component)
+	}
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return strings.Join(values, ", "), nil
+}
+
+func requestScheme(request *http.Request) string {
+	if request.TLS != nil {
+		return "https"
+	}
+	if request.URL.Scheme != "" {
+		return strings.ToLower(request.URL.Scheme)
+	}
+	return "http"
+}
+
+// SignatureBase builds the string signed for the covered components of the request
+func SignatureBase(request *http.Request, components []string, signatureParams string) (string, error) {
+	var b strings.Builder
+	for _, c := range components {
+		value, err := componentValue(request, c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(sfQuote(c) + ": " + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + signatureParams)
+	return b.String(), nil
+}
+
+// SignRequest signs the request for the covered components with a shared secret, setting its
+// Signature-Input and Signature fields under the label sig1. Requests with a body should cover
+// the content-digest component, see ContentDigest.
+func SignRequest(request *http.Request, keyID string, secret string, algorithm string, components []string) error {
+	newHash := hashFor(algorithm)
+	if newHash == nil {
+		return ErrUnsupportedAlgorithm
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	in := &signatureInput{label: "sig1", components: components, params: []sfParam{
+		{name: "created", value: time.Now().Unix()},
+		{name: "keyid", value: keyID},
+		{name: "alg", value: algorithm},
+		{name: "nonce", value: hex.EncodeToString(nonce)},
+	}}
+	base, err := SignatureBase(request, components, in.serialize())
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(base))
+
+	request.Header.Set(headerSignatureInput, in.label+"="+in.serialize())
+	request.Header.Set(headerSignature, in.label+"=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":")
+	return nil
+}
+
+// ContentDigest returns the sha-256 Content-Digest field value of a body
+func ContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func (v *HMACValidator) ValidateToken(request *http.Request) (map[string]interface{}, error) {
+	field := strings.Join(request.Header.Values(headerSignatureInput), ", ")
+	if field == "" || request.Header.Get(headerSignature) == "" {
+		return nil, ErrMissingSignature
+	}
+	in, err := parseSignatureInput(field, v.label)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := parseSignatureValue(strings.Join(request.Header.Values(headerSignature), ", "), in.label)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := in.stringParam("keyid")
+	if keyID == "" {
+		return nil, ErrBadFormatSignature
+	}
+	if alg := in.stringParam("alg"); alg != "" && alg != v.algorithm {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	covered := make(map[string]bool, len(in.components))
+	for _, c := range in.components {
+		covered[c] = true
+	}
+	// The target URI covers the authority, path and query, the request target the path and query
+	if covered["@target-uri"] {
+		covered["@authority"], covered["@path"], covered["@query"] = true, true, true
+	}
+	if covered["@request-target"] {
+		covered["@path"], covered["@query"] = true, true
+	}
+	for _, c := range v.components {
+		if !covered[c] {
+			return nil, fmt.Errorf("%w: %s", ErrMissingSignedHeader, c)
+		}
+	}
+
+	createdValue, _ := in.param("created")
+	created, ok := createdValue.(int64)
+	if !ok {
+		return nil, fmt.Errorf("%w: created is required", ErrBadFormatSignature)
+	}
+	createdAt := time.Unix(created, 0)
+	if skew := time.Since(createdAt); skew > v.clockSkew || skew < -v.clockSkew {
+		return nil, ErrRequestExpired
+	}
+	if expiresValue, ok := in.param("expires"); ok {
+		expires, ok := expiresValue.(int64)
+		if !ok || time.Now().After(time.Unix(expires, 0)) {
+			return nil, ErrRequestExpired
+		}
+	}
+
+	if v.secrets == nil {
+		return nil, ErrUnknownKeyID
+	}
+	secret, err := v.secrets.GetSecret(keyID)
+	if err != nil {
+		return nil, err
+	}
+	base, err := SignatureBase(request, in.components, in.serialize())
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(hashFor(v.algorithm), []byte(secret))
+	mac.Write([]byte(base))
+	if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	// The body is covered by the signature through its digest
+	if err := v.verifyDigest(request, covered["content-digest"]); err != nil {
+		return nil, err
+	}
+
+	nonce := in.stringParam("nonce")
+	if nonce == "" {
+		nonce = base64.StdEncoding.EncodeToString(signature)
+	}
+	if !v.nonces.add(keyID+":"+nonce, createdAt.Add(v.clockSkew)) {
+		return nil, ErrReplayedRequest
+	}
+
+	return map[string]interface{}{"keyid": keyID}, nil
+}
+
+// parseSignatureValue returns the signature with the label from the Signature field
+func parseSignatureValue(field string, label string) ([]byte, error) {
+	members, err := parseDictionary(field)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.name != label {
+			continue
+		}
+		signature, ok := m.item.([]byte)
+		if !ok || len(signature) == 0 {
+			return nil, ErrBadFormatSignature
+		}
+		return signature, nil
+	}
+	return nil, ErrMissingSignature
+}
+
+func (v *HMACValidator) verifyDigest(request *http.Request, digestSigned bool) error {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, v.maxBodySize+1))
+	request.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > v.maxBodySize {
+		return ErrRequestBodyTooLarge
+	}
+	request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(body) == 0 {
+		return nil
+	}
+	if !digestSigned {
+		return fmt.Errorf("%w: content-digest", ErrMissingSignedHeader)
+	}
+
+	digests, err := parseDictionary(strings.Join(request.Header.Values(headerContentDigest), ", "))
+	if err != nil {
+		return ErrDigestMismatch
+	}
+	verified := false
+	for _, d := range digests {
+		expected, ok := d.item.([]byte)
+		if !ok {
+			return ErrDigestMismatch
+		}
+		var sum []byte
+		switch d.name {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			// Digests of other algorithms are ignored
+			continue
+		}
+		if subtle.ConstantTimeCompare(sum, expected) != 1 {
+			return ErrDigestMismatch
+		}
+		verified = true
+	}
+	if !verified {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// keyIDOf returns the key ID of the signature of a request, without verifying it
+func keyIDOf(request *http.Request) string {
+	field := strings.Join(request.Header.Values(headerSignatureInput), ", ")
+	if field == "" {
+		return ""
+	}
+	in, err := parseSignatureInput(field, "")
+	if err != nil {
+		return ""
+	}
+	return in.stringParam("keyid")
+}
+
+// NonceCache remembers signatures until their date falls outside of the clock skew window
+type NonceCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	swept   time.Time
+}
+
+func NewNonceCache() *NonceCache {
+	return &NonceCache{entries: make(map[string]time.Time)}
+}
+
+// add records the nonce and reports false if it was already present
+func (c *NonceCache) add(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.swept) > time.Minute {
+		for k, exp := range c.entries {
+			if now.After(exp) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+
+	if exp, ok := c.entries[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.entries[nonce] = expires
+	return true
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+type testSecretStore map[string]string
+
+func (s testSecretStore) GetSecret(keyID string) (string, error) {
+	secret, ok := s[keyID]
+	if !ok {
+		return "", ErrUnknownKeyID
+	}
+	return secret, nil
+}
+
+func newSignedRequest(method string, body string) *http.Request {
+	if body == "" {
+		return httptest.NewRequest(method, "http://partner.example.com/api/orders?page=2", nil)
+	}
+	req := httptest.NewRequest(method, "http://partner.example.com/api/orders?page=2", strings.NewReader(body))
+	req.Header.Set("Content-Digest", ContentDigest([]byte(body)))
+	return req
+}
+
+func signRequest(t *testing.T, req *http.Request, keyID string, secret string, components []string) {
+	require.NoError(t, SignRequest(req, keyID, secret, HMACSHA256, components))
+}
+
+// signCreated signs the request with a created time of its own
+func signCreated(t *testing.T, req *http.Request, components []string, created time.Time) {
+	in := &signatureInput{label: "sig1", components: components, params: []sfParam{
+		{name: "created", value: created.Unix()},
+		{name: "keyid", value: "partner"},
+	}}
+	base, err := SignatureBase(req, components, in.serialize())
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(base))
+	req.Header.Set("Signature-Input", "sig1="+in.serialize())
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":")
+}
+
+func TestValidateHMACSignature(t *testing.T) {
+	secrets := testSecretStore{"partner": "s3cr3t"}
+	components := []string{"@method", "@authority", "@path", "@query", "x-partner", "content-digest"}
+	withoutBody := []string{"@method", "@authority", "@path", "@query", "x-partner"}
+
+	testCases := []struct {
+		description  string
+		buildRequest func(t *testing.T) *http.Request
+		expectedErr  error
+	}{
+		{
+			description: "OK",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodPost, `{"item":1}`)
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", components)
+				return req
+			},
+		},
+		{
+			description: "OK without body",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", withoutBody)
+				return req
+			},
+		},
+		{
+			description: "OK with target URI",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", []string{"@method", "@target-uri", "x-partner"})
+				return req
+			},
+		},
+		{
+			description: "missing signature",
+			buildRequest: func(t *testing.T) *http.Request {
+				return newSignedRequest(http.MethodGet, "")
+			},
+			expectedErr: ErrMissingSignature,
+		},
+		{
+			description: "unknown key id",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "unknown", "s3cr3t", withoutBody)
+				return req
+			},
+			expectedErr: ErrUnknownKeyID,
+		},
+		{
+			description: "wrong secret",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "guessed", withoutBody)
+				return req
+			},
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			description: "tampered query",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", withoutBody)
+				req.URL.RawQuery = "page=3"
+				return req
+			},
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			description: "tampered body",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodPost, `{"item":1}`)
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", components)
+				req.Body = io.NopCloser(strings.NewReader(`{"item":2}`))
+				return req
+			},
+			expectedErr: ErrDigestMismatch,
+		},
+		{
+			description: "body without signed digest",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodPost, `{"item":1}`)
+				req.Header.Set("X-Partner", "acme")
+				signRequest(t, req, "partner", "s3cr3t", withoutBody)
+				return req
+			},
+			expectedErr: ErrMissingSignedHeader,
+		},
+		{
+			description: "required header not signed",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				signRequest(t, req, "partner", "s3cr3t", []string{"@method", "@authority", "@path", "@query"})
+				return req
+			},
+			expectedErr: ErrMissingSignedHeader,
+		},
+		{
+			description: "expired signature",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("X-Partner", "acme")
+				signCreated(t, req, withoutBody, time.Now().Add(-10*time.Minute))
+				return req
+			},
+			expectedErr: ErrRequestExpired,
+		},
+		{
+			description: "bad format",
+			buildRequest: func(t *testing.T) *http.Request {
+				req := newSignedRequest(http.MethodGet, "")
+				req.Header.Set("Signature-Input", "sig1=garbage")
+				req.Header.Set("Signature", "sig1=:AAAA:")
+				return req
+			},
+			expectedErr: ErrBadFormatSignature,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			validator, err := NewHMACValidator(&config.HMACConfig{SignedHeaders: []string{"X-Partner"}}, secrets, nil)
+			require.NoError(t, err)
+
+			req := tc.buildRequest(t)
+			claims, err := validator.ValidateToken(req)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "partner", claims["keyid"])
+
+			// The body remains readable for the upstream request
+			if req.Body != nil {
+				_, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHMACReplayProtection(t *testing.T) {
+	validator, err := NewHMACValidator(nil, testSecretStore{"partner": "s3cr3t"}, nil)
+	require.NoError(t, err)
+
+	req := newSignedRequest(http.MethodGet, "")
+	signRequest(t, req, "partner", "s3cr3t", []string{"@method", "@authority", "@path", "@query"})
+
+	_, err = validator.ValidateToken(req)
+	require.NoError(t, err)
+
+	replayed := req.Clone(req.Context())
+	_, err = validator.ValidateToken(replayed)
+	require.ErrorIs(t, err, ErrReplayedRequest)
+}
+
+// TestSignatureBaseRFC9421 checks the HMAC example of RFC 9421, appendix B.2.5
+func TestSignatureBaseRFC9421(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Signature-Input", `sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`)
+
+	in, err := parseSignatureInput(req.Header.Get("Signature-Input"), "sig-b25")
+	require.NoError(t, err)
+	base, err := SignatureBase(req, in.components, in.serialize())
+	require.NoError(t, err)
+	require.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`, base)
+
+	secret, err := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(base))
+	signature, err := parseSignatureValue("sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:", "sig-b25")
+	require.NoError(t, err)
+	require.Equal(t, signature, mac.Sum(nil))
+}
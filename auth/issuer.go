@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	DefaultInternalTokenHeader string        = "X-Frontman-Token"
+	DefaultInternalTokenTTL    time.Duration = 60
+)
+
+var ErrUnsupportedSigningKey = errors.New("unsupported signing key type")
+
+// TokenIssuer mints short-lived JWTs carrying the identity validated by the gateway
+type TokenIssuer struct {
+	issuer    string
+	algorithm jwa.SignatureAlgorithm
+	key       jwk.Key
+	publicSet jwk.Set
+}
+
+// NewTokenIssuer creates a TokenIssuer from the configured PEM key file. When no key file is
+// configured an ephemeral RSA key is generated, which is only suitable for a single instance.
+func NewTokenIssuer(cfg *config.TokenIssuerConfig) (*TokenIssuer, error) {
+	var (
+		key jwk.Key
+		err error
+	)
+
+	if cfg.KeyFile != "" {
+		data, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			log.Printf("Failed to read token issuer key file: %s", err)
+			return nil, err
+		}
+		key, err = jwk.ParseKey(data, jwk.WithPEM(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token issuer key: %w", err)
+		}
+	} else {
+		log.Printf("No token issuer key file configured, generating an ephemeral signing key")
+		raw, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key, err = jwk.FromRaw(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	alg, err := signingAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := cfg.KeyID
+	if kid == "" {
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		kid = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	key.Set(jwk.KeyIDKey, kid)
+	key.Set(jwk.AlgorithmKey, alg)
+	key.Set(jwk.KeyUsageKey, jwk.ForSignature)
+
+	public, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, err
+	}
+	publicSet := jwk.NewSet()
+	publicSet.AddKey(public)
+
+	return &TokenIssuer{
+		issuer:    cfg.Issuer,
+		algorithm: alg,
+		key:       key,
+		publicSet: publicSet,
+	}, nil
+}
+
+func signingAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return "", err
+	}
+
+	switch k := raw.(type) {
+	case *rsa.PrivateKey:
+		return jwa.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwa.ES256, nil
+		case 384:
+			return jwa.ES384, nil
+		case 521:
+			return jwa.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return jwa.EdDSA, nil
+	}
+
+	return "", ErrUnsupportedSigningKey
+}
+
+// Issue signs a token for the given identity. Claims configured for the backend service
+// take precedence over the identity claims.
+func (i *TokenIssuer) Issue(identity map[string]interface{}, conf *config.InternalTokenConfig) (string, error) {
+	ttl := conf.TTL
+	if ttl == 0 {
+		ttl = DefaultInternalTokenTTL
+	}
+	now := time.Now()
+
+	token := jwt.New()
+	for k, v := range identity {
+		if err := token.Set(k, v); err != nil {
+			return "", err
+		}
+	}
+	for k, v := range conf.Claims {
+		if err := token.Set(k, v); err != nil {
+			return "", err
+		}
+	}
+
+	if i.issuer != "" {
+		token.Set(jwt.IssuerKey, i.issuer)
+	}
+	if conf.Audience != "" {
+		token.Set(jwt.AudienceKey, conf.Audience)
+	}
+	token.Set(jwt.IssuedAtKey, now)
+	token.Set(jwt.NotBeforeKey, now)
+	token.Set(jwt.ExpirationKey, now.Add(ttl*time.Second))
+
+	signed, err := jwt.Sign(token, jwt.WithKey(i.algorithm, i.key))
+	if err != nil {
+		return "", err
+	}
+
+	return string(signed), nil
+}
+
+// registeredClaims describe the token itself, the issuer never copies them from the client token
+var registeredClaims = []string{
+	jwt.IssuerKey, jwt.AudienceKey, jwt.JwtIDKey, jwt.IssuedAtKey, jwt.NotBeforeKey, jwt.ExpirationKey,
+}
+
+// Identity returns the identity an internal token is minted for: the claims validated for the
+// request, with the subject its credentials name unless the claims carry one. It must only be
+// called once the token validator of the service accepted the request.
+func Identity(claims map[string]interface{}, request *http.Request) map[string]interface{} {
+	identity := make(map[string]interface{}, len(claims)+1)
+	for k, v := range claims {
+		identity[k] = v
+	}
+	for _, k := range registeredClaims {
+		delete(identity, k)
+	}
+	if _, ok := identity[jwt.SubjectKey]; !ok {
+		if sub := requestSubject(request); sub != "" {
+			identity[jwt.SubjectKey] = sub
+		}
+	}
+	return identity
+}
+
+// requestSubject returns the user of basic credentials, the subject of a bearer token or the key
+// ID of a signed request. The token validator checked the credentials, they are not verified again.
+func requestSubject(request *http.Request) string {
+	if username, _, ok := request.BasicAuth(); ok {
+		return username
+	}
+	if keyID := keyIDOf(request); keyID != "" {
+		return keyID
+	}
+	fields := strings.Fields(request.Header.Get("Authorization"))
+	if len(fields) == 2 && strings.ToLower(fields[0]) == AuthTypeBearer {
+		token, err := jwt.Parse([]byte(fields[1]), jwt.WithVerify(false), jwt.WithValidate(false))
+		if err == nil {
+			return token.Subject()
+		}
+	}
+	return ""
+}
+
+// PublicKeys returns the key set used to verify tokens minted by the issuer
+func (i *TokenIssuer) PublicKeys() jwk.Set {
+	return i.publicSet
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenIssuerEphemeralKey(t *testing.T) {
+	issuer, err := NewTokenIssuer(&config.TokenIssuerConfig{Issuer: "frontman", KeyID: "gateway"})
+	require.NoError(t, err)
+
+	signed, err := issuer.Issue(map[string]interface{}{"sub": "ashketchum", "email": "ashketchum@gmail.com"}, &config.InternalTokenConfig{
+		Audience: "orders",
+		Claims:   map[string]string{"tenant": "kanto"},
+	})
+	require.NoError(t, err)
+
+	token, err := jwt.Parse([]byte(signed), jwt.WithKeySet(issuer.PublicKeys()), jwt.WithAudience("orders"), jwt.WithIssuer("frontman"))
+	require.NoError(t, err)
+	require.Equal(t, "ashketchum", token.Subject())
+	email, _ := token.Get("email")
+	require.Equal(t, "ashketchum@gmail.com", email)
+	tenant, _ := token.Get("tenant")
+	require.Equal(t, "kanto", tenant)
+	require.True(t, token.Expiration().After(token.IssuedAt()))
+
+	key, ok := issuer.PublicKeys().Key(0)
+	require.True(t, ok)
+	require.Equal(t, "gateway", key.KeyID())
+	isPrivate, err := jwk.IsPrivateKey(key)
+	require.NoError(t, err)
+	require.False(t, isPrivate)
+}
+
+func TestTokenIssuerKeyFile(t *testing.T) {
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pem, err := jwk.EncodePEM(raw)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "issuer.pem")
+	require.NoError(t, os.WriteFile(keyFile, pem, 0600))
+
+	issuer, err := NewTokenIssuer(&config.TokenIssuerConfig{KeyFile: keyFile})
+	require.NoError(t, err)
+	require.Equal(t, jwa.ES256, issuer.algorithm)
+
+	signed, err := issuer.Issue(nil, &config.InternalTokenConfig{})
+	require.NoError(t, err)
+	_, err = jwt.Parse([]byte(signed), jwt.WithKeySet(issuer.PublicKeys()))
+	require.NoError(t, err)
+}
+
+func TestTokenIssuerMissingKeyFile(t *testing.T) {
+	_, err := NewTokenIssuer(&config.TokenIssuerConfig{KeyFile: "/does/not/exist.pem"})
+	require.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	basic := httptest.NewRequest(http.MethodGet, "/", nil)
+	basic.SetBasicAuth("ashketchum", "pikachu")
+	require.Equal(t, map[string]interface{}{"sub": "ashketchum"}, Identity(nil, basic))
+
+	token := jwt.New()
+	require.NoError(t, token.Set(jwt.SubjectKey, "misty"))
+	signed, err := jwt.Sign(token, jwt.WithInsecureNoSignature())
+	require.NoError(t, err)
+	bearer := httptest.NewRequest(http.MethodGet, "/", nil)
+	bearer.Header.Set("Authorization", "Bearer "+string(signed))
+	claims := map[string]interface{}{"email": "misty@gmail.com"}
+	require.Equal(t, map[string]interface{}{"sub": "misty", "email": "misty@gmail.com"}, Identity(claims, bearer))
+	// The validated claims are left as they are
+	require.Equal(t, map[string]interface{}{"email": "misty@gmail.com"}, claims)
+
+	require.Equal(t, map[string]interface{}{"sub": "brock"}, Identity(map[string]interface{}{"sub": "brock"}, basic))
+	require.Empty(t, Identity(nil, httptest.NewRequest(http.MethodGet, "/", nil)))
+
+	// The client token does not choose the issuer, audience or ID of the internal token
+	registered := map[string]interface{}{"sub": "brock", "iss": "client", "aud": []string{"billing"}, "jti": "1", "email": "brock@gmail.com"}
+	require.Equal(t, map[string]interface{}{"sub": "brock", "email": "brock@gmail.com"}, Identity(registered, basic))
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// The structured field values (RFC 8941) used by HTTP Message Signatures: dictionaries whose
+// members are items or inner lists, with parameters. Decimals are not supported.
+
+type sfParam struct {
+	name  string
+	value interface{}
+}
+
+type sfItem struct {
+	value  interface{}
+	params []sfParam
+}
+
+type sfMember struct {
+	name   string
+	item   interface{}
+	list   []sfItem
+	params []sfParam
+}
+
+type sfParser struct {
+	input string
+	pos   int
+}
+
+// parseDictionary parses a dictionary field value
+func parseDictionary(input string) ([]sfMember, error) {
+	p := &sfParser{input: input}
+	p.skipSpaces()
+	var members []sfMember
+	for !p.done() {
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		m := sfMember{name: name, item: true}
+		if p.peek() == '=' {
+			p.pos++
+			if p.peek() == '(' {
+				if m.list, err = p.parseInnerList(); err != nil {
+					return nil, err
+				}
+				m.item = nil
+			} else if m.item, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		if m.params, err = p.parseParams(); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+
+		p.skipOWS()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, ErrBadFormatSignature
+		}
+		p.pos++
+		p.skipOWS()
+		if p.done() {
+			return nil, ErrBadFormatSignature
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *sfParser) skipSpaces() {
+	for p.peek() == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", ErrBadFormatSignature
+	}
+	for c := p.peek(); (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("_-.*", c) >= 0; c = p.peek() {
+		if c == 0 {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *sfParser) parseInnerList() ([]sfItem, error) {
+	p.pos++
+	var items []sfItem
+	for {
+		p.skipSpaces()
+		if p.done() {
+			return nil, ErrBadFormatSignature
+		}
+		if p.peek() == ')' {
+			p.pos++
+			return items, nil
+		}
+		value, err := p.parseBareItem()
+		if err != nil {
+			return nil, err
+		}
+		params, err := p.parseParams()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, sfItem{value: value, params: params})
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, ErrBadFormatSignature
+		}
+	}
+}
+
+func (p *sfParser) parseParams() ([]sfParam, error) {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSpaces()
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{name: name, value: value})
+	}
+	return params, nil
+}
+
+// parseBareItem parses a string, byte sequence, integer, token or boolean
+func (p *sfParser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == ':':
+		end := strings.IndexByte(p.input[p.pos+1:], ':')
+		if end < 0 {
+			return nil, ErrBadFormatSignature
+		}
+		data, err := base64.StdEncoding.DecodeString(p.input[p.pos+1 : p.pos+1+end])
+		if err != nil {
+			return nil, ErrBadFormatSignature
+		}
+		p.pos += end + 2
+		return data, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(p.input[start:p.pos], 10, 64)
+		if err != nil || p.pos-start > 16 {
+			return nil, ErrBadFormatSignature
+		}
+		return n, nil
+	case c == '?':
+		if p.pos+1 >= len(p.input) || (p.input[p.pos+1] != '0' && p.input[p.pos+1] != '1') {
+			return nil, ErrBadFormatSignature
+		}
+		p.pos += 2
+		return p.input[p.pos-1] == '1', nil
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.pos
+		for c := p.peek(); c > ' ' && c < 0x7f && strings.IndexByte(`"(),;<=>?@[\]{}`, c) < 0; c = p.peek() {
+			p.pos++
+		}
+		return sfToken(p.input[start:p.pos]), nil
+	}
+	return nil, ErrBadFormatSignature
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.done() || (p.input[p.pos] != '"' && p.input[p.pos] != '\\') {
+				return "", ErrBadFormatSignature
+			}
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < ' ' || c > '~':
+			return "", ErrBadFormatSignature
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", ErrBadFormatSignature
+}
+
+// sfToken is a token item, serialized without quotes
+type sfToken string
+
+// sfQuote serializes a string item
+func sfQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func serializeParam(p sfParam) string {
+	switch value := p.value.(type) {
+	case bool:
+		if value {
+			return ";" + p.name
+		}
+		return ";" + p.name + "=?0"
+	case int64:
+		return ";" + p.name + "=" + strconv.FormatInt(value, 10)
+	case string:
+		return ";" + p.name + "=" + sfQuote(value)
+	case sfToken:
+		return ";" + p.name + "=" + string(value)
+	case []byte:
+		return ";" + p.name + "=:" + base64.StdEncoding.EncodeToString(value) + ":"
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	UpstreamAuthBearer            string = "bearer"
+	UpstreamAuthBasic             string = "basic"
+	UpstreamAuthClientCredentials string = "client_credentials"
+)
+
+// DefaultTokenTimeout bounds the requests for access tokens, in seconds, so a hung token endpoint
+// can't hold up the requests waiting for a token
+const DefaultTokenTimeout time.Duration = 10
+
+var ErrMissingUpstreamCredentials = errors.New("missing upstream credentials")
+
+// UpstreamCredentials adds the credentials of the gateway to requests sent to a backend service
+type UpstreamCredentials interface {
+	SetCredentials(header http.Header) error
+}
+
+func GetUpstreamCredentials(conf config.UpstreamAuthConfig) (UpstreamCredentials, error) {
+	switch conf.AuthType {
+	case UpstreamAuthBearer:
+		return NewBearerCredentials(&conf)
+	case UpstreamAuthBasic:
+		return NewBasicCredentials(conf.BasicAuthConfig)
+	case UpstreamAuthClientCredentials:
+		return NewClientCredentials(conf.ClientCredentials)
+	default:
+		return nil, errors.New("Unrecognized upstream auth type specified")
+	}
+}
+
+// BearerCredentials sends a static bearer token
+type BearerCredentials struct {
+	token string
+}
+
+func NewBearerCredentials(conf *config.UpstreamAuthConfig) (*BearerCredentials, error) {
+	token := conf.Token
+	if token == "" {
+		token = os.Getenv(conf.TokenEnv)
+	}
+	if token == "" {
+		return nil, ErrMissingUpstreamCredentials
+	}
+	return &BearerCredentials{token: token}, nil
+}
+
+func (c *BearerCredentials) SetCredentials(header http.Header) error {
+	header.Set("Authorization", "Bearer "+c.token)
+	return nil
+}
+
+// BasicCredentials sends static basic auth credentials
+type BasicCredentials struct {
+	username string
+	password string
+}
+
+func NewBasicCredentials(conf *config.BasicAuthConfig) (*BasicCredentials, error) {
+	if conf == nil {
+		return nil, ErrMissingUpstreamCredentials
+	}
+	username, password := getCredentialsFromConfig(conf)
+	if username == "" {
+		return nil, ErrMissingUpstreamCredentials
+	}
+	return &BasicCredentials{username: username, password: password}, nil
+}
+
+func (c *BasicCredentials) SetCredentials(header http.Header) error {
+	req := http.Request{Header: header}
+	req.SetBasicAuth(c.username, c.password)
+	return nil
+}
+
+// ClientCredentials obtains access tokens with the OAuth2 client-credentials grant.
+// Tokens are cached and refreshed shortly before they expire.
+type ClientCredentials struct {
+	tokenSource oauth2.TokenSource
+}
+
+func NewClientCredentials(conf *config.ClientCredentialsConfig) (*ClientCredentials, error) {
+	if conf == nil || conf.ClientID == "" || conf.TokenURL == "" {
+		return nil, ErrMissingUpstreamCredentials
+	}
+
+	secret := conf.ClientSecret
+	if secret == "" {
+		secret = os.Getenv(conf.ClientSecretEnv)
+	}
+
+	params := make(url.Values)
+	for k, v := range conf.EndpointParams {
+		params.Set(k, v)
+	}
+
+	var style oauth2.AuthStyle
+	switch conf.AuthStyle {
+	case "", "auto":
+		style = oauth2.AuthStyleAutoDetect
+	case "header":
+		style = oauth2.AuthStyleInHeader
+	case "params":
+		style = oauth2.AuthStyleInParams
+	default:
+		return nil, fmt.Errorf("unknown client credentials auth style: %s", conf.AuthStyle)
+	}
+
+	cc := &clientcredentials.Config{
+		ClientID:       conf.ClientID,
+		ClientSecret:   secret,
+		TokenURL:       conf.TokenURL,
+		Scopes:         conf.Scopes,
+		EndpointParams: params,
+		AuthStyle:      style,
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = DefaultTokenTimeout
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: timeout * time.Second})
+
+	return &ClientCredentials{tokenSource: cc.TokenSource(ctx)}, nil
+}
+
+func (c *ClientCredentials) SetCredentials(header http.Header) error {
+	token, err := c.tokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("failed to obtain upstream access token: %w", err)
+	}
+	token.SetAuthHeader(&http.Request{Header: header})
+	return nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBearerCredentials(t *testing.T) {
+	os.Setenv("FRONTMAN_TEST_UPSTREAM_TOKEN", "token_from_env")
+
+	credentials, err := GetUpstreamCredentials(config.UpstreamAuthConfig{
+		AuthType: UpstreamAuthBearer,
+		TokenEnv: "FRONTMAN_TEST_UPSTREAM_TOKEN",
+	})
+	require.NoError(t, err)
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer client")
+	require.NoError(t, credentials.SetCredentials(header))
+	require.Equal(t, "Bearer token_from_env", header.Get("Authorization"))
+
+	_, err = GetUpstreamCredentials(config.UpstreamAuthConfig{AuthType: UpstreamAuthBearer})
+	require.ErrorIs(t, err, ErrMissingUpstreamCredentials)
+}
+
+func TestBasicCredentials(t *testing.T) {
+	credentials, err := GetUpstreamCredentials(config.UpstreamAuthConfig{
+		AuthType:        UpstreamAuthBasic,
+		BasicAuthConfig: &config.BasicAuthConfig{Username: "gateway", Password: "secret"},
+	})
+	require.NoError(t, err)
+
+	req := &http.Request{Header: make(http.Header)}
+	require.NoError(t, credentials.SetCredentials(req.Header))
+	username, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "gateway", username)
+	require.Equal(t, "secret", password)
+}
+
+func TestClientCredentials(t *testing.T) {
+	var issued int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+		require.Equal(t, "orders", r.Form.Get("audience"))
+		clientID, clientSecret, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "frontman", clientID)
+		require.Equal(t, "secret", clientSecret)
+
+		atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"upstream-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	credentials, err := GetUpstreamCredentials(config.UpstreamAuthConfig{
+		AuthType: UpstreamAuthClientCredentials,
+		ClientCredentials: &config.ClientCredentialsConfig{
+			ClientID:       "frontman",
+			ClientSecret:   "secret",
+			TokenURL:       srv.URL,
+			EndpointParams: map[string]string{"audience": "orders"},
+			AuthStyle:      "header",
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		header := make(http.Header)
+		require.NoError(t, credentials.SetCredentials(header))
+		require.Equal(t, "Bearer upstream-token", header.Get("Authorization"))
+	}
+
+	// The token is cached until it expires
+	require.Equal(t, int32(1), atomic.LoadInt32(&issued))
+}
+
+func TestClientCredentialsTokenError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	credentials, err := NewClientCredentials(&config.ClientCredentialsConfig{ClientID: "frontman", TokenURL: srv.URL})
+	require.NoError(t, err)
+	require.Error(t, credentials.SetCredentials(make(http.Header)))
+}
+
+func TestClientCredentialsTokenTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	credentials, err := NewClientCredentials(&config.ClientCredentialsConfig{ClientID: "frontman", TokenURL: srv.URL, Timeout: 1})
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.Error(t, credentials.SetCredentials(make(http.Header)))
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,507 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	DefaultMaxEntrySize int64 = 1 << 20
+
+	// StatusHeader tells clients whether a response was served from the cache
+	StatusHeader = "X-Cache"
+
+	// staleRetention is how long entries with validators are kept after they stop being fresh,
+	// so that they can be revalidated instead of fetched again
+	staleRetention = time.Hour
+
+	variantSeparator = "#"
+)
+
+var (
+	defaultMethods     = []string{http.MethodGet, http.MethodHead}
+	defaultStatusCodes = []int{
+		http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone,
+	}
+)
+
+// Policy is a compiled set of caching rules of a backend service
+type Policy struct {
+	methods      map[string]bool
+	statusCodes  map[int]bool
+	ttl          time.Duration
+	keyHeaders   []string
+	ignoreQuery  bool
+	maxEntrySize int64
+	// authenticated services answer each client for itself unless the response says otherwise
+	authenticated bool
+}
+
+// NewPolicy compiles the caching rules of a backend service. Services that authenticate their
+// clients only have responses stored that are explicitly marked public, as the cache key doesn't
+// tell the clients apart.
+func NewPolicy(conf *config.CacheConfig, authenticated bool) (*Policy, error) {
+	if conf.TTL < 0 {
+		return nil, fmt.Errorf("cache ttl cannot be negative")
+	}
+
+	p := &Policy{
+		methods:       make(map[string]bool),
+		statusCodes:   make(map[int]bool),
+		ttl:           conf.TTL * time.Second,
+		ignoreQuery:   conf.IgnoreQuery,
+		maxEntrySize:  conf.MaxEntrySize,
+		authenticated: authenticated,
+	}
+	if p.maxEntrySize <= 0 {
+		p.maxEntrySize = DefaultMaxEntrySize
+	}
+
+	methods := conf.Methods
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	for _, method := range methods {
+		if method == "" {
+			return nil, fmt.Errorf("cache method cannot be empty")
+		}
+		p.methods[strings.ToUpper(method)] = true
+	}
+
+	statusCodes := conf.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultStatusCodes
+	}
+	for _, code := range statusCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid cache status code %d", code)
+		}
+		p.statusCodes[code] = true
+	}
+
+	for _, header := range conf.KeyHeaders {
+		if header == "" {
+			return nil, fmt.Errorf("cache key header cannot be empty")
+		}
+		p.keyHeaders = append(p.keyHeaders, http.CanonicalHeaderKey(header))
+	}
+
+	return p, nil
+}
+
+// key returns the cache key of the request: the service, path, query, method and key headers.
+// HEAD requests share the entries of GET requests, and requests with a body are keyed by its digest.
+func (p *Policy) key(service string, req *http.Request, body []byte) string {
+	var b strings.Builder
+	b.WriteString(service)
+	b.WriteByte(':')
+	b.WriteString(req.URL.EscapedPath())
+	if !p.ignoreQuery && req.URL.RawQuery != "" {
+		b.WriteByte('?')
+		b.WriteString(req.URL.Query().Encode())
+	}
+
+	method := req.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	b.WriteByte(' ')
+	b.WriteString(method)
+
+	for _, header := range p.keyHeaders {
+		b.WriteString(" " + header + "=" + strings.Join(req.Header.Values(header), ","))
+	}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		b.WriteString(" body=" + hex.EncodeToString(sum[:16]))
+	}
+	return b.String()
+}
+
+// lifetime returns how long a response stays fresh, TTL overriding the response headers
+func (p *Policy) lifetime(header http.Header, cc cacheControl, now time.Time) time.Duration {
+	if p.ttl > 0 && !cc.has("no-cache") {
+		return p.ttl
+	}
+	return freshnessLifetime(header, cc, now)
+}
+
+// storable reports whether a shared cache may store the response, and for how long it is fresh
+func (p *Policy) storable(req *http.Request, resp *http.Response, now time.Time) (time.Duration, bool) {
+	if !p.statusCodes[resp.StatusCode] || resp.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return 0, false
+	}
+	for _, vary := range varyHeaders(resp.Header) {
+		if vary == "*" {
+			return 0, false
+		}
+	}
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return 0, false
+	}
+	if p.authenticated && !cc.has("public") {
+		return 0, false
+	}
+	if resp.ContentLength > p.maxEntrySize {
+		return 0, false
+	}
+
+	lifetime := p.lifetime(resp.Header, cc, now)
+	if lifetime <= 0 && !hasValidators(resp.Header) {
+		return 0, false
+	}
+	return lifetime, true
+}
+
+// Cache answers requests with stored responses while they are fresh and revalidates them
+// with the upstream once they are stale
+type Cache struct {
+	store Store
+}
+
+func New(store Store) *Cache {
+	return &Cache{store: store}
+}
+
+// NewStore creates the store of the response cache. redisURI is used when the configuration
+// doesn't name one.
+func NewStore(ctx context.Context, conf *config.CacheStoreConfig, redisURI string) (Store, error) {
+	switch conf.Type {
+	case "", "memory":
+		return NewMemoryStore(conf.MaxBytes), nil
+	case "redis":
+		if conf.RedisURI != "" {
+			redisURI = conf.RedisURI
+		}
+		opt, err := redis.ParseURL(redisURI)
+		if err != nil {
+			return nil, err
+		}
+		client := redis.NewClient(opt)
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, err
+		}
+		return NewRedisStore(client, conf.RedisPrefix), nil
+	default:
+		return nil, fmt.Errorf("invalid cache store type %s, expected memory or redis", conf.Type)
+	}
+}
+
+// Do answers the request from the cache or sends it with the client, storing the response if
+// the policy allows it. Stored bodies are captured as the response is read, so responses are
+// never buffered before they are returned.
+func (c *Cache) Do(p *Policy, service string, client *http.Client, req *http.Request) (*http.Response, error) {
+	reqCC := parseCacheControl(req.Header)
+	if !p.methods[req.Method] || reqCC.has("no-store") {
+		return client.Do(req)
+	}
+
+	var body []byte
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		var ok bool
+		if body, ok = bufferBody(req, p.maxEntrySize); !ok {
+			return client.Do(req)
+		}
+	}
+
+	ctx := req.Context()
+	now := time.Now()
+	base := p.key(service, req, body)
+	key, entry := c.lookup(ctx, base, req)
+
+	maxAge, hasMaxAge := reqCC.seconds("max-age")
+	if entry != nil && now.Before(entry.Expires) && !reqCC.has("no-cache") && (!hasMaxAge || now.Sub(entry.Stored) <= maxAge) {
+		return entry.response(req, now, "HIT"), nil
+	}
+
+	// Revalidate stale entries unless the client sent its own conditions
+	revalidate := entry != nil && hasValidators(entry.Header) &&
+		req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == ""
+	if revalidate {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if revalidate {
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revalidate && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		entry.update(p, resp.Header, now)
+		c.set(key, entry)
+		return entry.response(req, now, "REVALIDATED"), nil
+	}
+
+	resp.Header.Set(StatusHeader, "MISS")
+	if req.Method != http.MethodHead {
+		c.storeOnRead(p, base, req, resp, now)
+	}
+	return resp, nil
+}
+
+// lookup returns the entry of the request and its key, following the variants of the response
+func (c *Cache) lookup(ctx context.Context, base string, req *http.Request) (string, *Entry) {
+	entry, err := c.store.Get(ctx, base)
+	if err != nil {
+		log.Printf("Error reading response cache: %s", err.Error())
+		return base, nil
+	}
+	if entry == nil || len(entry.Vary) == 0 {
+		return base, entry
+	}
+
+	key := variantKey(base, entry.Vary, req)
+	if entry, err = c.store.Get(ctx, key); err != nil {
+		log.Printf("Error reading response cache: %s", err.Error())
+		return key, nil
+	}
+	return key, entry
+}
+
+// storeOnRead stores the response once its body has been read completely
+func (c *Cache) storeOnRead(p *Policy, base string, req *http.Request, resp *http.Response, now time.Time) {
+	lifetime, ok := p.storable(req, resp, now)
+	if !ok {
+		return
+	}
+
+	header := resp.Header.Clone()
+	header.Del(StatusHeader)
+	stored := now.Add(-currentAge(resp.Header))
+	entry := &Entry{
+		Status:  resp.StatusCode,
+		Header:  header,
+		Stored:  stored,
+		Expires: stored.Add(lifetime),
+	}
+	vary := varyHeaders(resp.Header)
+	key := base
+	if len(vary) > 0 {
+		key = variantKey(base, vary, req)
+	}
+
+	resp.Body = &captureBody{
+		ReadCloser: resp.Body,
+		max:        p.maxEntrySize,
+		done: func(body []byte) {
+			entry.Body = body
+			if len(vary) > 0 {
+				c.set(base, &Entry{Stored: entry.Stored, Expires: entry.Expires, Vary: vary, Header: validatorsOf(header)})
+			}
+			c.set(key, entry)
+		},
+	}
+}
+
+func (c *Cache) set(key string, entry *Entry) {
+	ttl := time.Until(entry.Expires)
+	if hasValidators(entry.Header) {
+		ttl += staleRetention
+	}
+	if ttl <= 0 {
+		return
+	}
+	// The client request may already be done once the body has been read
+	if err := c.store.Set(context.Background(), key, entry, ttl); err != nil {
+		log.Printf("Error writing response cache: %s", err.Error())
+	}
+}
+
+// PurgeService removes the entries of a backend service
+func (c *Cache) PurgeService(ctx context.Context, service string) (int, error) {
+	return c.store.DeletePrefix(ctx, service+":")
+}
+
+// PurgePrefix removes the entries of a backend service whose upstream path starts with the prefix
+func (c *Cache) PurgePrefix(ctx context.Context, service, prefix string) (int, error) {
+	return c.store.DeletePrefix(ctx, service+":"+prefix)
+}
+
+// PurgeKey removes the entry of a key along with its variants
+func (c *Cache) PurgeKey(ctx context.Context, key string) (int, error) {
+	deleted, err := c.store.Delete(ctx, key)
+	if err != nil {
+		return deleted, err
+	}
+	variants, err := c.store.DeletePrefix(ctx, key+variantSeparator)
+	return deleted + variants, err
+}
+
+// update refreshes an entry with the headers of a 304 Not Modified response
+func (e *Entry) update(p *Policy, header http.Header, now time.Time) {
+	for k, v := range header {
+		if k == "Content-Length" {
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.Header.Del(StatusHeader)
+	e.Stored = now.Add(-currentAge(header))
+	e.Expires = e.Stored.Add(p.lifetime(e.Header, parseCacheControl(e.Header), now))
+}
+
+// response builds the response to the request from the entry, answering conditional requests
+// of the client with 304 Not Modified
+func (e *Entry) response(req *http.Request, now time.Time, status string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(now.Sub(e.Stored).Seconds())))
+	header.Set(StatusHeader, status)
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Request:       req,
+		ContentLength: int64(len(e.Body)),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+	}
+
+	if e.Status == http.StatusOK && notModified(req, e.Header) {
+		resp.Status = fmt.Sprintf("%d %s", http.StatusNotModified, http.StatusText(http.StatusNotModified))
+		resp.StatusCode = http.StatusNotModified
+		resp.ContentLength = 0
+		resp.Body = http.NoBody
+		header.Del("Content-Length")
+	} else if req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+	}
+	return resp
+}
+
+// notModified evaluates the conditions of the request against a stored response
+func notModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(header.Get("Last-Modified"))
+		return err == nil && !modified.After(since)
+	}
+	return false
+}
+
+// varyHeaders returns the request headers listed by the Vary header of a response
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+func variantKey(base string, vary []string, req *http.Request) string {
+	values := make([]string, 0, len(vary))
+	for _, name := range vary {
+		values = append(values, name+"="+strings.Join(req.Header.Values(name), ","))
+	}
+	return base + variantSeparator + strings.Join(values, "&")
+}
+
+// validatorsOf keeps the validators of a variant on the entry pointing to the variants,
+// so that the pointer is retained as long as the variants are
+func validatorsOf(header http.Header) http.Header {
+	marker := make(http.Header)
+	for _, name := range []string{"ETag", "Last-Modified"} {
+		if v := header.Get(name); v != "" {
+			marker.Set(name, v)
+		}
+	}
+	return marker
+}
+
+// bufferBody reads the body of the request so it can be keyed, returning false if it is too large
+func bufferBody(req *http.Request, max int64) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return []byte{}, true
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil || int64(len(data)) > max {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	return data, true
+}
+
+// captureBody copies the body of a response as it is read, calling done once it has been read
+// completely without exceeding the size limit
+type captureBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+	done     func([]byte)
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.max {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(100)
+
+	entry := func(body string) *Entry {
+		return &Entry{Status: http.StatusOK, Header: http.Header{}, Body: []byte(body)}
+	}
+	require.NoError(t, store.Set(ctx, "a", entry(strings.Repeat("a", 40)), time.Minute))
+	require.NoError(t, store.Set(ctx, "b", entry(strings.Repeat("b", 40)), time.Minute))
+
+	// Reading a makes b the least recently used entry, which is evicted to make room for c
+	got, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.NoError(t, store.Set(ctx, "c", entry(strings.Repeat("c", 40)), time.Minute))
+	got, _ = store.Get(ctx, "b")
+	require.Nil(t, got)
+	require.LessOrEqual(t, store.Size(), int64(100))
+
+	// Entries larger than the budget are not stored
+	require.NoError(t, store.Set(ctx, "d", entry(strings.Repeat("d", 200)), time.Minute))
+	got, _ = store.Get(ctx, "d")
+	require.Nil(t, got)
+
+	// Expired entries are removed
+	require.NoError(t, store.Set(ctx, "e", entry("e"), -time.Second))
+	got, _ = store.Get(ctx, "e")
+	require.Nil(t, got)
+
+	deleted, err := store.DeletePrefix(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	require.Equal(t, int64(0), store.Size())
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, 0},
+		{"Expires", http.Header{
+			"Date":    {now.UTC().Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)},
+		}, time.Hour},
+		{"Invalid Expires", http.Header{"Expires": {"0"}}, 0},
+		{"No headers", http.Header{}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, freshnessLifetime(tc.header, parseCacheControl(tc.header), now))
+		})
+	}
+}
+
+// do sends a request through the cache and reads the response completely
+func do(t *testing.T, c *Cache, p *Policy, client *http.Client, req *http.Request) (*http.Response, string) {
+	resp, err := c.Do(p, "svc", client, req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func TestCacheDo(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("fresh " + r.URL.RawQuery))
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			w.Write([]byte("private"))
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language")))
+		case "/tagged":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v2"`)
+			w.Write([]byte("tagged"))
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("tagged"))
+		}
+	}))
+	defer upstream.Close()
+
+	c := New(NewMemoryStore(0))
+	p, err := NewPolicy(&config.CacheConfig{}, false)
+	require.NoError(t, err)
+	client := upstream.Client()
+	get := func(path string, header http.Header) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return do(t, c, p, client, req)
+	}
+
+	t.Run("Fresh responses are served from the cache", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		resp, body := get("/fresh?b=2&a=1", nil)
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+		// Query parameters are normalized
+		resp, cached := get("/fresh?a=1&b=2", nil)
+		require.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+		require.Equal(t, body, cached)
+		require.NotEmpty(t, resp.Header.Get("Age"))
+		require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+		// Clients may ask for a fresh copy
+		resp, _ = get("/fresh?a=1&b=2", http.Header{"Cache-Control": {"no-cache"}})
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	})
+
+	t.Run("Private responses are not stored", func(t *testing.T) {
+		get("/private", nil)
+		resp, _ := get("/private", nil)
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	})
+
+	t.Run("Variants are stored separately", func(t *testing.T) {
+		_, body := get("/vary", http.Header{"Accept-Language": {"en"}})
+		require.Equal(t, "en", body)
+		resp, body := get("/vary", http.Header{"Accept-Language": {"fr"}})
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+		require.Equal(t, "fr", body)
+		resp, body = get("/vary", http.Header{"Accept-Language": {"en"}})
+		require.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+		require.Equal(t, "en", body)
+	})
+
+	t.Run("Stale responses are revalidated", func(t *testing.T) {
+		get("/etag", nil)
+		resp, body := get("/etag", nil)
+		require.Equal(t, "REVALIDATED", resp.Header.Get(StatusHeader))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "tagged", body)
+	})
+
+	t.Run("Conditional requests are answered from the cache", func(t *testing.T) {
+		resp, body := get("/fresh", http.Header{"If-None-Match": {"*"}})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "fresh ", body)
+		get("/tagged", nil)
+		resp, body = get("/tagged", http.Header{"If-None-Match": {`"v1", W/"v2"`}})
+		require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		require.Empty(t, body)
+	})
+
+	t.Run("HEAD requests share GET entries", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodHead, upstream.URL+"/fresh?a=1&b=2", nil)
+		resp, body := do(t, c, p, client, req)
+		require.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+		require.Empty(t, body)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		purged, err := c.PurgePrefix(context.Background(), "svc", "/vary")
+		require.NoError(t, err)
+		require.Equal(t, 3, purged)
+		resp, _ := get("/vary", http.Header{"Accept-Language": {"en"}})
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+
+		purged, err = c.PurgeService(context.Background(), "svc")
+		require.NoError(t, err)
+		require.NotZero(t, purged)
+		resp, _ = get("/fresh?a=1&b=2", nil)
+		require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	})
+}
+
+func TestCacheDoWithBody(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	c := New(NewMemoryStore(0))
+	p, err := NewPolicy(&config.CacheConfig{Methods: []string{"post"}, TTL: 60}, false)
+	require.NoError(t, err)
+
+	post := func(body string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/search", strings.NewReader(body))
+		return do(t, c, p, upstream.Client(), req)
+	}
+
+	_, body := post(`{"q":"a"}`)
+	require.Equal(t, `{"q":"a"}`, body)
+	resp, body := post(`{"q":"a"}`)
+	require.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	require.Equal(t, `{"q":"a"}`, body)
+	resp, body = post(`{"q":"b"}`)
+	require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	require.Equal(t, `{"q":"b"}`, body)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestCacheDoAuthenticated(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Write([]byte(r.Header.Get("Signature-Input")))
+	}))
+	defer upstream.Close()
+
+	// Signed requests carry no Authorization header, the service tells the policy it authenticates
+	c := New(NewMemoryStore(0))
+	p, err := NewPolicy(&config.CacheConfig{TTL: 60}, true)
+	require.NoError(t, err)
+
+	get := func(path string, keyID string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		req.Header.Set("Signature-Input", `sig1=();keyid="`+keyID+`"`)
+		return do(t, c, p, upstream.Client(), req)
+	}
+
+	get("/orders", "alice")
+	resp, body := get("/orders", "mallory")
+	require.Equal(t, "MISS", resp.Header.Get(StatusHeader))
+	require.Contains(t, body, "mallory")
+
+	get("/public", "alice")
+	resp, _ = get("/public", "mallory")
+	require.Equal(t, "HIT", resp.Header.Get(StatusHeader))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestNewPolicyValidation(t *testing.T) {
+	invalid := []config.CacheConfig{
+		{TTL: -1},
+		{Methods: []string{""}},
+		{StatusCodes: []int{99}},
+		{KeyHeaders: []string{""}},
+	}
+	for _, conf := range invalid {
+		conf := conf
+		_, err := NewPolicy(&conf, false)
+		require.Error(t, err)
+	}
+}
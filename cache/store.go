@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	DefaultMaxBytes    int64 = 64 << 20
+	DefaultRedisPrefix       = "frontman:cache:"
+)
+
+// Entry is a cached response
+type Entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// Stored is when the response was received and Expires when it stops being fresh
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+	// Vary lists the request headers selecting a variant. Entries with Vary set only point to
+	// their variants, which are stored under their own keys.
+	Vary []string `json:"vary,omitempty"`
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for k, values := range e.Header {
+		for _, v := range values {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size
+}
+
+// Store keeps cached responses until they expire or are evicted
+type Store interface {
+	// Get returns the entry of the key, or nil if there is none
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	Delete(ctx context.Context, key string) (int, error)
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// MemoryStore is a least recently used store bounded by the size of its entries
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type memoryItem struct {
+	key     string
+	entry   *Entry
+	size    int64
+	expires time.Time
+}
+
+// NewMemoryStore creates a memory store holding at most maxBytes of responses
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if time.Now().After(item.expires) {
+		s.remove(elem)
+		return nil, nil
+	}
+	s.order.MoveToFront(elem)
+
+	// Callers may update the entry, the body is never modified
+	entry := *item.entry
+	entry.Header = item.entry.Header.Clone()
+	return &entry, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	item := &memoryItem{key: key, entry: entry, size: entry.size() + int64(len(key)), expires: time.Now().Add(ttl)}
+	if item.size > s.maxBytes {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	s.items[key] = s.order.PushFront(item)
+	s.size += item.size
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryStore) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for key, elem := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			s.remove(elem)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Size returns the number of bytes held by the store
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	item := s.order.Remove(elem).(*memoryItem)
+	delete(s.items, item.key)
+	s.size -= item.size
+}
+
+// RedisStore shares cached responses between gateway instances
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a store keeping entries under the prefix
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, data, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) (int, error) {
+	deleted, err := s.client.Del(ctx, s.prefix+key).Result()
+	return int(deleted), err
+}
+
+func (s *RedisStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	deleted := 0
+	iter := s.client.Scan(ctx, 0, escapeGlob(s.prefix+prefix)+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 100 {
+			n, err := s.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += int(n)
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	if len(keys) > 0 {
+		n, err := s.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+	return deleted, nil
+}
+
+// escapeGlob escapes the characters redis patterns give a meaning to
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package coalesce
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var errReaderClosed = errors.New("coalesce: read on closed body")
+
+// broadcast copies a response body to several readers as it is received. Data is kept until
+// every reader has read it, and reading from the upstream pauses while the slowest reader is more
+// than max bytes behind, so memory stays bounded for streaming bodies.
+type broadcast struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	src     io.ReadCloser
+	buf     []byte
+	base    int64 // offset of buf[0] in the body
+	err     error
+	max     int
+	readers []*reader
+	pending []*reader
+	active  int
+	done    func()
+}
+
+type reader struct {
+	bc     *broadcast
+	off    int64
+	closed bool
+}
+
+// newBroadcast starts copying src to n readers, calling done once src is no longer read. done
+// must be safe to call more than once.
+func newBroadcast(src io.ReadCloser, n, max int, done func()) *broadcast {
+	bc := &broadcast{src: src, max: max, active: n, done: done}
+	bc.cond = sync.NewCond(&bc.mu)
+	for i := 0; i < n; i++ {
+		r := &reader{bc: bc}
+		bc.readers = append(bc.readers, r)
+		bc.pending = append(bc.pending, r)
+	}
+	go bc.pump()
+	return bc
+}
+
+func (bc *broadcast) pump() {
+	defer bc.done()
+	defer bc.src.Close()
+
+	chunk := make([]byte, 32<<10)
+	for {
+		bc.mu.Lock()
+		for len(bc.buf) >= bc.max && bc.active > 0 {
+			bc.cond.Wait()
+		}
+		if bc.active == 0 {
+			bc.mu.Unlock()
+			return
+		}
+		bc.mu.Unlock()
+
+		n, err := bc.src.Read(chunk)
+
+		bc.mu.Lock()
+		bc.buf = append(bc.buf, chunk[:n]...)
+		if err != nil {
+			bc.err = err
+		}
+		bc.cond.Broadcast()
+		bc.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// take hands out one of the readers
+func (bc *broadcast) take() *reader {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	r := bc.pending[len(bc.pending)-1]
+	bc.pending = bc.pending[:len(bc.pending)-1]
+	return r
+}
+
+// release gives up a reader that was never handed out
+func (bc *broadcast) release() {
+	bc.take().Close()
+}
+
+// trim drops the data every open reader has read
+func (bc *broadcast) trim() {
+	end := bc.base + int64(len(bc.buf))
+	min := end
+	for _, r := range bc.readers {
+		if !r.closed && r.off < min {
+			min = r.off
+		}
+	}
+	if min > bc.base {
+		bc.buf = bc.buf[min-bc.base:]
+		bc.base = min
+		if len(bc.buf) == 0 {
+			bc.buf = nil
+		}
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	bc := r.bc
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for !r.closed && r.off == bc.base+int64(len(bc.buf)) && bc.err == nil {
+		bc.cond.Wait()
+	}
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	if r.off < bc.base+int64(len(bc.buf)) {
+		n := copy(p, bc.buf[r.off-bc.base:])
+		r.off += int64(n)
+		bc.trim()
+		bc.cond.Broadcast()
+		return n, nil
+	}
+	return 0, bc.err
+}
+
+func (r *reader) Close() error {
+	bc := r.bc
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		bc.active--
+		bc.trim()
+		bc.cond.Broadcast()
+		if bc.active == 0 {
+			// Abort a read of the upstream that may be blocked
+			bc.done()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package coalesce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const DefaultMaxBufferSize int64 = 1 << 20
+
+// defaultKeyHeaders keep the requests of different users apart when no key headers are configured
+var defaultKeyHeaders = []string{"Authorization", "Cookie"}
+
+// FetchFunc sends a request upstream
+type FetchFunc func(*http.Request) (*http.Response, error)
+
+// Coalescer collapses concurrent identical GET and HEAD requests into one upstream call and
+// fans the response out to every waiting request
+type Coalescer struct {
+	mu         sync.Mutex
+	flights    map[string]*flight
+	keyHeaders []string
+	// identityHeaders are set by the gateway and read from the upstream request
+	identityHeaders []string
+	ignoreQuery     bool
+	maxWait         time.Duration
+	maxBuffer       int
+}
+
+// flight is an upstream call shared by the requests waiting for it
+type flight struct {
+	key    string
+	done   chan struct{}
+	refs   int
+	cancel context.CancelFunc
+	resp   *http.Response
+	err    error
+	body   *broadcast
+}
+
+// NewCoalescer compiles the request coalescing rules of a backend service. identityHeaders are
+// the headers the gateway identifies users in upstream, such as the user data and internal token
+// headers, and are added to the default key headers.
+func NewCoalescer(conf *config.CoalesceConfig, identityHeaders ...string) (*Coalescer, error) {
+	if conf.MaxWait < 0 {
+		return nil, fmt.Errorf("coalesce max wait cannot be negative")
+	}
+	if conf.MaxBufferSize < 0 {
+		return nil, fmt.Errorf("coalesce max buffer size cannot be negative")
+	}
+
+	c := &Coalescer{
+		flights:     make(map[string]*flight),
+		ignoreQuery: conf.IgnoreQuery,
+		maxWait:     conf.MaxWait * time.Second,
+		maxBuffer:   int(conf.MaxBufferSize),
+	}
+	if c.maxBuffer == 0 {
+		c.maxBuffer = int(DefaultMaxBufferSize)
+	}
+
+	keyHeaders := conf.KeyHeaders
+	if len(keyHeaders) == 0 {
+		keyHeaders = defaultKeyHeaders
+		for _, header := range identityHeaders {
+			c.identityHeaders = append(c.identityHeaders, http.CanonicalHeaderKey(header))
+		}
+	}
+	for _, header := range keyHeaders {
+		if header == "" {
+			return nil, fmt.Errorf("coalesce key header cannot be empty")
+		}
+		c.keyHeaders = append(c.keyHeaders, http.CanonicalHeaderKey(header))
+	}
+
+	return c, nil
+}
+
+// key returns the coalescing key of the request. The upstream target is left out so that requests
+// sent to different targets of a service are still collapsed. The key headers are read from
+// header, the headers of the client request, and the identity headers from the upstream request.
+func (c *Coalescer) key(req *http.Request, header http.Header) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.Host)
+	b.WriteString(req.URL.EscapedPath())
+	if !c.ignoreQuery && req.URL.RawQuery != "" {
+		b.WriteByte('?')
+		b.WriteString(req.URL.Query().Encode())
+	}
+	for _, name := range c.keyHeaders {
+		b.WriteString(" " + name + "=" + strings.Join(header.Values(name), ","))
+	}
+	for _, name := range c.identityHeaders {
+		b.WriteString(" " + name + "=" + strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// Do sends the request with fetch, unless an identical request is already in flight in which
+// case it waits for its response. Waiters that exceed the max wait send their own request.
+//
+// The key headers are taken from client, the headers the client sent, as the gateway replaces
+// the credentials of the upstream request with its own, which are the same for every user.
+//
+// The shared upstream call outlives the request that started it as long as other requests
+// wait for it, and is cancelled once every waiter has given up.
+func (c *Coalescer) Do(req *http.Request, client http.Header, fetch FetchFunc) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return fetch(req)
+	}
+
+	key := c.key(req, client)
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		f.refs++
+		c.mu.Unlock()
+		return c.wait(f, req, fetch, false)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &flight{key: key, done: make(chan struct{}), refs: 1, cancel: cancel}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	go c.run(f, req.WithContext(ctx), fetch)
+	return c.wait(f, req, fetch, true)
+}
+
+func (c *Coalescer) run(f *flight, req *http.Request, fetch FetchFunc) {
+	resp, err := fetch(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Requests arriving from now on start a new flight
+	if c.flights[f.key] == f {
+		delete(c.flights, f.key)
+	}
+	f.resp, f.err = resp, err
+	if err == nil {
+		f.body = newBroadcast(resp.Body, f.refs, c.maxBuffer, f.cancel)
+	} else {
+		f.cancel()
+	}
+	close(f.done)
+}
+
+func (c *Coalescer) wait(f *flight, req *http.Request, fetch FetchFunc, leader bool) (*http.Response, error) {
+	var timeout <-chan time.Time
+	if !leader && c.maxWait > 0 {
+		timer := time.NewTimer(c.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.response(req), nil
+	case <-req.Context().Done():
+		c.leave(f)
+		return nil, req.Context().Err()
+	case <-timeout:
+		c.leave(f)
+		return fetch(req)
+	}
+}
+
+// leave gives up waiting for a flight, cancelling it if nobody else waits
+func (c *Coalescer) leave(f *flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		// The response arrived meanwhile and a body reader was set aside for this request
+		if f.body != nil {
+			f.body.release()
+		}
+		return
+	default:
+	}
+
+	f.refs--
+	if f.refs == 0 {
+		f.cancel()
+		if c.flights[f.key] == f {
+			delete(c.flights, f.key)
+		}
+	}
+}
+
+// response returns a copy of the shared response with a body reader of its own
+func (f *flight) response(req *http.Request) *http.Response {
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Request = req
+	resp.Body = f.body.take()
+	return &resp
+}
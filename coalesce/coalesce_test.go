@@ -0,0 +1,262 @@
+package coalesce
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+// waiters returns the number of requests waiting for the flight of the key
+func waiters(c *Coalescer, key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f.refs
+	}
+	return 0
+}
+
+func newUpstream(t *testing.T, release chan struct{}) (*httptest.Server, *int32) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte("shared " + r.URL.RawQuery))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &requests
+}
+
+func TestCoalescerDo(t *testing.T) {
+	release := make(chan struct{})
+	upstream, requests := newUpstream(t, release)
+
+	c, err := NewCoalescer(&config.CoalesceConfig{})
+	require.NoError(t, err)
+	fetch := upstream.Client().Do
+
+	const n = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/items?b=2&a=1", nil)
+			resp, err := c.Do(req, req.Header, fetch)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies[i] = string(body)
+		}(i)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/items?a=1&b=2", nil)
+	key := c.key(req, req.Header)
+	require.Eventually(t, func() bool { return waiters(c, key) == n }, time.Second, time.Millisecond)
+
+	// Requests with other credentials are not collapsed with the others
+	other, _ := http.NewRequest(http.MethodGet, upstream.URL+"/items?a=1&b=2", nil)
+	other.Header.Set("Authorization", "Bearer other")
+	require.NotEqual(t, key, c.key(other, other.Header))
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(requests))
+	for _, body := range bodies {
+		require.Equal(t, "shared b=2&a=1", body)
+	}
+
+	// Requests after the response start a new flight
+	resp, err := c.Do(req, req.Header, fetch)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
+
+func TestCoalescerMaxWait(t *testing.T) {
+	release := make(chan struct{})
+	upstream, requests := newUpstream(t, release)
+
+	c, err := NewCoalescer(&config.CoalesceConfig{MaxWait: 1})
+	require.NoError(t, err)
+	fetch := upstream.Client().Do
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/slow", nil)
+		resp, err := c.Do(req, req.Header, fetch)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(requests) == 1 }, time.Second, time.Millisecond)
+
+	// The follower gives up after a second and sends its own request
+	followerDone := make(chan struct{})
+	go func() {
+		defer close(followerDone)
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/slow", nil)
+		resp, err := c.Do(req, req.Header, fetch)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(requests) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+	close(release)
+	<-leaderDone
+	<-followerDone
+}
+
+func TestCoalescerLeaderCancelled(t *testing.T) {
+	release := make(chan struct{})
+	upstream, requests := newUpstream(t, release)
+
+	c, err := NewCoalescer(&config.CoalesceConfig{})
+	require.NoError(t, err)
+	fetch := upstream.Client().Do
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/items", nil)
+	leaderErr := make(chan error)
+	go func() {
+		_, err := c.Do(leader, leader.Header, fetch)
+		leaderErr <- err
+	}()
+	key := c.key(leader, leader.Header)
+	require.Eventually(t, func() bool { return waiters(c, key) == 1 }, time.Second, time.Millisecond)
+
+	followerBody := make(chan string)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/items", nil)
+		resp, err := c.Do(req, req.Header, fetch)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		followerBody <- string(body)
+	}()
+	require.Eventually(t, func() bool { return waiters(c, key) == 2 }, time.Second, time.Millisecond)
+
+	// The upstream call carries on for the follower when the leader goes away
+	cancel()
+	require.ErrorIs(t, <-leaderErr, context.Canceled)
+	close(release)
+	require.Equal(t, "shared ", <-followerBody)
+	require.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestCoalescerKeyHeaders(t *testing.T) {
+	testCases := []struct {
+		description     string
+		conf            config.CoalesceConfig
+		identityHeaders []string
+		client          http.Header
+		upstream        http.Header
+		shared          bool
+	}{
+		{
+			description: "same client credentials",
+			client:      http.Header{"Authorization": {"Bearer alice"}},
+			shared:      true,
+		},
+		{
+			description: "client credentials differ behind the same upstream credentials",
+			client:      http.Header{"Authorization": {"Bearer bob"}},
+			upstream:    http.Header{"Authorization": {"Bearer upstream"}},
+		},
+		{
+			description: "cookies differ",
+			client:      http.Header{"Authorization": {"Bearer alice"}, "Cookie": {"session=2"}},
+		},
+		{
+			description:     "identities set by the gateway differ",
+			identityHeaders: []string{"x-identity"},
+			client:          http.Header{"Authorization": {"Bearer alice"}},
+			upstream:        http.Header{"X-Identity": {"token-of-mallory"}},
+		},
+		{
+			description:     "identity headers are ignored with configured key headers",
+			conf:            config.CoalesceConfig{KeyHeaders: []string{"Accept-Language"}},
+			identityHeaders: []string{"x-identity"},
+			client:          http.Header{"Authorization": {"Bearer bob"}},
+			upstream:        http.Header{"X-Identity": {"token-of-mallory"}},
+			shared:          true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			c, err := NewCoalescer(&tc.conf, tc.identityHeaders...)
+			require.NoError(t, err)
+
+			first, _ := http.NewRequest(http.MethodGet, "http://upstream/items", nil)
+			first.Header.Set("Authorization", "Bearer upstream")
+			first.Header.Set("X-Identity", "token-of-alice")
+			firstClient := http.Header{"Authorization": {"Bearer alice"}}
+
+			second, _ := http.NewRequest(http.MethodGet, "http://upstream/items", nil)
+			second.Header.Set("Authorization", "Bearer upstream")
+			second.Header.Set("X-Identity", "token-of-alice")
+			for name, values := range tc.upstream {
+				second.Header[name] = values
+			}
+
+			shared := c.key(first, firstClient) == c.key(second, tc.client)
+			require.Equal(t, tc.shared, shared)
+		})
+	}
+}
+
+func TestBroadcastStreaming(t *testing.T) {
+	src, w := io.Pipe()
+	done := make(chan struct{})
+	var once sync.Once
+	bc := newBroadcast(src, 2, 4, func() { once.Do(func() { close(done) }) })
+	a, b := bc.take(), bc.take()
+
+	// Readers receive chunks as they arrive, before the body is complete
+	go w.Write([]byte("one"))
+	buf := make([]byte, 8)
+	n, err := a.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "one", string(buf[:n]))
+	n, err = b.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "one", string(buf[:n]))
+
+	go func() {
+		w.Write([]byte("two"))
+		w.Close()
+	}()
+	rest, err := io.ReadAll(a)
+	require.NoError(t, err)
+	require.Equal(t, "two", string(rest))
+
+	// A reader closing early doesn't affect the others
+	require.NoError(t, b.Close())
+	_, err = b.Read(buf)
+	require.Error(t, err)
+	a.Close()
+	<-done
+}
+
+func TestNewCoalescerValidation(t *testing.T) {
+	invalid := []config.CoalesceConfig{
+		{MaxWait: -1},
+		{MaxBufferSize: -1},
+		{KeyHeaders: []string{""}},
+	}
+	for _, conf := range invalid {
+		conf := conf
+		_, err := NewCoalescer(&conf)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,152 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Brotli  = "br"
+	Zstd    = "zstd"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// brotliLevel trades ratio for speed, responses are compressed on the fly
+const brotliLevel = 4
+
+// encoder is implemented by the writers of every supported encoding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// encoders pools the writers of the encodings the gateway compresses with
+var encoders = map[string]*sync.Pool{
+	Brotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}},
+	Zstd: {New: func() interface{} {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return enc
+	}},
+	Gzip: {New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}},
+}
+
+// decoders create readers of the encodings the gateway can decompress
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	Brotli: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(r)), nil
+	},
+	Zstd: func(r io.Reader) (io.ReadCloser, error) {
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	},
+	Gzip: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	// The deflate content coding is zlib wrapped
+	Deflate: func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	},
+}
+
+// encode returns a reader of the body compressed with the encoding. The body is flushed after
+// every read of the source so that streamed responses reach clients as they are produced.
+// Closing the reader closes upstream, the body src reads from, to stop the compression.
+func encode(encoding string, src io.ReadCloser, upstream io.Closer) io.ReadCloser {
+	pool := encoders[encoding]
+	pr, pw := io.Pipe()
+
+	go func() {
+		enc := pool.Get().(encoder)
+		enc.Reset(pw)
+
+		err := copyFlushing(enc, src)
+		if err == nil {
+			err = enc.Close()
+		}
+		enc.Reset(io.Discard)
+		pool.Put(enc)
+		src.Close()
+		pw.CloseWithError(err)
+	}()
+
+	return readCloser{pr, closerFunc(func() error {
+		pr.Close()
+		return upstream.Close()
+	})}
+}
+
+func copyFlushing(enc encoder, src io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := enc.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decode returns a reader of the body decompressed from the encoding. Decoders are created on
+// the first read, as most of them read the header of the stream right away.
+func decode(encoding string, src io.ReadCloser) io.ReadCloser {
+	return &decodeBody{src: src, newDecoder: decoders[encoding]}
+}
+
+type decodeBody struct {
+	src        io.ReadCloser
+	newDecoder func(io.Reader) (io.ReadCloser, error)
+	dec        io.ReadCloser
+	err        error
+}
+
+func (b *decodeBody) Read(p []byte) (int, error) {
+	if b.dec == nil && b.err == nil {
+		b.dec, b.err = b.newDecoder(b.src)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.dec.Read(p)
+}
+
+func (b *decodeBody) Close() error {
+	if b.dec != nil {
+		b.dec.Close()
+	}
+	return b.src.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,205 @@
+package compression
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const DefaultMinSize int64 = 1024
+
+var (
+	defaultEncodings    = []string{Brotli, Zstd, Gzip}
+	defaultContentTypes = []string{
+		"text/*", "application/json", "application/*+json", "application/javascript",
+		"application/xml", "application/*+xml", "image/svg+xml",
+	}
+)
+
+// Policy negotiates the content encoding of responses with clients
+type Policy struct {
+	disabled     bool
+	encodings    []string
+	contentTypes []string
+	minSize      int64
+	decompress   bool
+}
+
+// NewPolicy compiles the compression rules of the gateway or a backend service
+func NewPolicy(conf *config.CompressionConfig) (*Policy, error) {
+	p := &Policy{
+		disabled:   conf.Disabled,
+		minSize:    conf.MinSize,
+		decompress: conf.Decompress,
+	}
+	if p.minSize < 0 {
+		return nil, fmt.Errorf("compression min size cannot be negative")
+	}
+	if p.minSize == 0 {
+		p.minSize = DefaultMinSize
+	}
+
+	encodings := conf.Encodings
+	if len(encodings) == 0 {
+		encodings = defaultEncodings
+	}
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(encoding)
+		if _, ok := encoders[encoding]; !ok {
+			return nil, fmt.Errorf("invalid compression encoding %s, expected br, zstd or gzip", encoding)
+		}
+		p.encodings = append(p.encodings, encoding)
+	}
+
+	contentTypes := conf.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultContentTypes
+	}
+	for _, pattern := range contentTypes {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid compression content type %q", pattern)
+		}
+		p.contentTypes = append(p.contentTypes, pattern)
+	}
+
+	return p, nil
+}
+
+// Apply negotiates the encoding of the response with the request and replaces the body of the
+// response with its encoded version. Responses the client can't decode are decompressed when
+// the policy allows it.
+func (p *Policy) Apply(resp *http.Response, req *http.Request) {
+	if p.disabled || req.Method == http.MethodHead || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	switch {
+	case resp.StatusCode < http.StatusOK, resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusNotModified:
+		return
+	}
+	if resp.Header.Get("Content-Range") != "" || !p.compressible(resp.Header.Get("Content-Type")) ||
+		strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-transform") {
+		return
+	}
+
+	// The representation returned depends on the encodings accepted by the client
+	addVary(resp.Header, "Accept-Encoding")
+	accepted := parseAcceptEncoding(req.Header.Get("Accept-Encoding"))
+	upstream := resp.Body
+
+	current := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if current != "" && current != "identity" {
+		if _, ok := decoders[current]; !ok || !p.decompress || accepted.quality(current) > 0 {
+			return
+		}
+		resp.Body = decode(current, resp.Body)
+		resp.Header.Del("Content-Encoding")
+		setUnknownLength(resp)
+	} else if resp.ContentLength >= 0 && resp.ContentLength < p.minSize {
+		// Responses of unknown length are compressed, they may be streamed
+		return
+	}
+
+	encoding := p.negotiate(accepted)
+	if encoding == "" {
+		return
+	}
+	resp.Body = encode(encoding, resp.Body, upstream)
+	resp.Header.Set("Content-Encoding", encoding)
+	setUnknownLength(resp)
+}
+
+// compressible reports whether the media type matches one of the content types of the policy
+func (p *Policy) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range p.contentTypes {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the encoding of the policy the client prefers, the order of the policy
+// breaking ties
+func (p *Policy) negotiate(accepted acceptEncoding) string {
+	best, bestQ := "", 0.0
+	for _, encoding := range p.encodings {
+		if q := accepted.quality(encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// setUnknownLength drops the headers that no longer describe a re-encoded body. ETags become
+// weak as the encoded bytes differ from those of the upstream.
+func setUnknownLength(resp *http.Response) {
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Accept-Ranges")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+}
+
+// acceptEncoding holds the quality values of the encodings accepted by a client
+type acceptEncoding map[string]float64
+
+func parseAcceptEncoding(header string) acceptEncoding {
+	accepted := make(acceptEncoding)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = Gzip
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				} else {
+					q = 0
+				}
+			}
+		}
+		accepted[name] = q
+	}
+	return accepted
+}
+
+// quality returns the quality value of an encoding, 0 if the client doesn't accept it
+func (a acceptEncoding) quality(encoding string) float64 {
+	if q, ok := a[encoding]; ok {
+		return q
+	}
+	return a["*"]
+}
+
+// addVary lists a request header in the Vary header unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
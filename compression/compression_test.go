@@ -0,0 +1,207 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+var body = strings.Repeat("compress me please ", 200)
+
+func newResponse(contentType, data string) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {contentType}, "Content-Length": {strconv.Itoa(len(data))}, "Etag": {`"v1"`}},
+		Body:          io.NopCloser(strings.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}
+}
+
+func newRequest(acceptEncoding string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return req
+}
+
+func decodeAll(t *testing.T, encoding string, r io.Reader) string {
+	var dec io.Reader
+	switch encoding {
+	case Brotli:
+		dec = brotli.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		require.NoError(t, err)
+		defer zr.Close()
+		dec = zr
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		dec = gr
+	default:
+		dec = r
+	}
+	data, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestNegotiate(t *testing.T) {
+	p, err := NewPolicy(&config.CompressionConfig{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate, br, zstd", Brotli},
+		{"gzip, zstd", Zstd},
+		{"gzip;q=1, br;q=0.5", Gzip},
+		{"x-gzip", Gzip},
+		{"*", Brotli},
+		{"*, br;q=0", Zstd},
+		{"br;q=0, zstd;q=0, gzip;q=0", ""},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			require.Equal(t, tc.expected, p.negotiate(parseAcceptEncoding(tc.acceptEncoding)))
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := NewPolicy(&config.CompressionConfig{})
+	require.NoError(t, err)
+
+	for _, encoding := range []string{Brotli, Zstd, Gzip} {
+		t.Run(encoding, func(t *testing.T) {
+			resp := newResponse("text/html; charset=utf-8", body)
+			p.Apply(resp, newRequest(encoding))
+
+			require.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			require.Empty(t, resp.Header.Get("Content-Length"))
+			require.Equal(t, int64(-1), resp.ContentLength)
+			require.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+
+			encoded, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Less(t, len(encoded), len(body))
+			require.Equal(t, body, decodeAll(t, encoding, bytes.NewReader(encoded)))
+		})
+	}
+
+	skipped := []struct {
+		name string
+		resp *http.Response
+		req  *http.Request
+	}{
+		{"Too small", newResponse("application/json", `{"ok":true}`), newRequest("gzip")},
+		{"Not compressible", newResponse("image/png", body), newRequest("gzip")},
+		{"Not accepted", newResponse("text/plain", body), newRequest("")},
+		{"HEAD", newResponse("text/plain", body), httptest.NewRequest(http.MethodHead, "http://example.com/", nil)},
+	}
+	for _, tc := range skipped {
+		t.Run(tc.name, func(t *testing.T) {
+			p.Apply(tc.resp, tc.req)
+			require.Empty(t, tc.resp.Header.Get("Content-Encoding"))
+			require.NotEmpty(t, tc.resp.Header.Get("Content-Length"))
+		})
+	}
+
+	t.Run("Already encoded", func(t *testing.T) {
+		resp := newResponse("text/plain", "encoded")
+		resp.Header.Set("Content-Encoding", "gzip")
+		p.Apply(resp, newRequest("br"))
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		data, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "encoded", string(data))
+	})
+}
+
+func TestApplyDecompress(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(body))
+	zw.Close()
+	encoded := buf.String()
+
+	p, err := NewPolicy(&config.CompressionConfig{Encodings: []string{"zstd"}, Decompress: true})
+	require.NoError(t, err)
+
+	// Clients that don't accept the encoding of the upstream get the response decompressed
+	resp := newResponse("application/json", encoded)
+	resp.Header.Set("Content-Encoding", "gzip")
+	p.Apply(resp, newRequest(""))
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.Equal(t, body, decodeAll(t, "", resp.Body))
+
+	// or encoded with one they accept
+	resp = newResponse("application/json", encoded)
+	resp.Header.Set("Content-Encoding", "gzip")
+	p.Apply(resp, newRequest("zstd"))
+	require.Equal(t, Zstd, resp.Header.Get("Content-Encoding"))
+	require.Equal(t, body, decodeAll(t, Zstd, resp.Body))
+
+	// Clients accepting the encoding of the upstream get it untouched
+	resp = newResponse("application/json", encoded)
+	resp.Header.Set("Content-Encoding", "gzip")
+	p.Apply(resp, newRequest("gzip"))
+	require.Equal(t, Gzip, resp.Header.Get("Content-Encoding"))
+	data, _ := io.ReadAll(resp.Body)
+	require.Equal(t, encoded, string(data))
+}
+
+func TestApplyStreaming(t *testing.T) {
+	p, err := NewPolicy(&config.CompressionConfig{Encodings: []string{"gzip"}})
+	require.NoError(t, err)
+
+	src, w := io.Pipe()
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/event-stream"}},
+		Body:          src,
+		ContentLength: -1,
+	}
+	p.Apply(resp, newRequest("gzip"))
+	require.Equal(t, Gzip, resp.Header.Get("Content-Encoding"))
+
+	// Events are decompressed by the client before the stream ends
+	go w.Write([]byte("data: first\n\n"))
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	event := make([]byte, len("data: first\n\n"))
+	_, err = io.ReadFull(zr, event)
+	require.NoError(t, err)
+	require.Equal(t, "data: first\n\n", string(event))
+
+	w.Close()
+	resp.Body.Close()
+}
+
+func TestNewPolicyValidation(t *testing.T) {
+	invalid := []config.CompressionConfig{
+		{Encodings: []string{"compress"}},
+		{ContentTypes: []string{"text/["}},
+		{MinSize: -1},
+	}
+	for _, conf := range invalid {
+		conf := conf
+		_, err := NewPolicy(&conf)
+		require.Error(t, err)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"io/ioutil"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,6 +18,12 @@ type GlobalConfig struct {
 	MongoURI            string `yaml:"mongo_uri"`
 	MongoDatabaseName   string `yaml:"mongo_db_name"`
 	MongoCollectionName string `yaml:"mongo_collection_name"`
+	SecretsFile         string `yaml:"secrets_file"`
+	MongoSecretsName    string `yaml:"mongo_secrets_collection_name"`
+	RoutesFile          string `yaml:"routes_file"`
+	MongoRoutesName     string `yaml:"mongo_routes_collection_name"`
+	// TemplateEnv lists the environment variables templates may reference as {env.NAME}
+	TemplateEnv []string `yaml:"template_env"`
 }
 
 // SSLConfig holds the SSL configuration
@@ -40,12 +47,190 @@ type BasicAuthConfig struct {
 	CredentialsFile string `json:"credentialsFile" yaml:"credentialsFile"`
 }
 
+type HMACConfig struct {
+	Algorithm     string        `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	Label         string        `json:"label,omitempty" yaml:"label,omitempty"`
+	SignedHeaders []string      `json:"signedHeaders,omitempty" yaml:"signedHeaders,omitempty"`
+	ClockSkew     time.Duration `json:"clockSkew,omitempty" yaml:"clockSkew,omitempty"`
+	MaxBodySize   int64         `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+}
+
 // Auth config
 type AuthConfig struct {
 	AuthType        string           `json:"type" yaml:"type"`
 	UserDataHeader  string           `json:"userDataHeader" yaml:"userDataHeader"`
 	JWT             *JWTConfig       `json:"jwt" yaml:"jwt"`
 	BasicAuthConfig *BasicAuthConfig `json:"basic" yaml:"basic"`
+	HMAC            *HMACConfig      `json:"hmac,omitempty" yaml:"hmac,omitempty"`
+}
+
+// ClientCredentialsConfig holds the OAuth2 client-credentials grant configuration
+type ClientCredentialsConfig struct {
+	ClientID        string            `json:"clientId" yaml:"clientId"`
+	ClientSecret    string            `json:"clientSecret,omitempty" yaml:"clientSecret,omitempty"`
+	ClientSecretEnv string            `json:"clientSecretEnvVariable,omitempty" yaml:"clientSecretEnvVariable,omitempty"`
+	TokenURL        string            `json:"tokenUrl" yaml:"tokenUrl"`
+	Scopes          []string          `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	EndpointParams  map[string]string `json:"endpointParams,omitempty" yaml:"endpointParams,omitempty"`
+	AuthStyle       string            `json:"authStyle,omitempty" yaml:"authStyle,omitempty"`
+	Timeout         time.Duration     `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// UpstreamAuthConfig holds the credentials the gateway presents to a backend service
+type UpstreamAuthConfig struct {
+	AuthType          string                   `json:"type" yaml:"type"`
+	Token             string                   `json:"token,omitempty" yaml:"token,omitempty"`
+	TokenEnv          string                   `json:"tokenEnvVariable,omitempty" yaml:"tokenEnvVariable,omitempty"`
+	BasicAuthConfig   *BasicAuthConfig         `json:"basic,omitempty" yaml:"basic,omitempty"`
+	ClientCredentials *ClientCredentialsConfig `json:"clientCredentials,omitempty" yaml:"clientCredentials,omitempty"`
+}
+
+// CORSConfig holds the cross-origin resource sharing policy of the gateway or a backend service
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins,omitempty" yaml:"allowedOrigins,omitempty"`
+	AllowedMethods   []string `json:"allowedMethods,omitempty" yaml:"allowedMethods,omitempty"`
+	AllowedHeaders   []string `json:"allowedHeaders,omitempty" yaml:"allowedHeaders,omitempty"`
+	ExposedHeaders   []string `json:"exposedHeaders,omitempty" yaml:"exposedHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty" yaml:"allowCredentials,omitempty"`
+	MaxAge           int      `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+}
+
+// MirrorConfig holds the target receiving a copy of the traffic of a backend service.
+// Timeout is in seconds.
+type MirrorConfig struct {
+	Target        string        `json:"target" yaml:"target"`
+	Percentage    float64       `json:"percentage" yaml:"percentage"`
+	MaxConcurrent int           `json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty"`
+	MaxBodySize   int64         `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+	Timeout       time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// RedirectConfig answers the requests of a backend service with a redirect. Scheme, Host and Path
+// replace those of the request URL when set and may reference path parameters as {name}.
+type RedirectConfig struct {
+	StatusCode int    `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	Scheme     string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Host       string `json:"host,omitempty" yaml:"host,omitempty"`
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
+	DropQuery  bool   `json:"dropQuery,omitempty" yaml:"dropQuery,omitempty"`
+}
+
+// StaticResponseConfig answers the requests of a backend service with a fixed response
+type StaticResponseConfig struct {
+	StatusCode int               `json:"statusCode,omitempty" yaml:"statusCode,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body       string            `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// StaticConfig serves the files of a local directory. CacheControl rules are matched in order
+// against the file name, or against the file path when the pattern contains a slash.
+type StaticConfig struct {
+	Root          string             `json:"root" yaml:"root"`
+	Index         string             `json:"index,omitempty" yaml:"index,omitempty"`
+	SPAFallback   bool               `json:"spaFallback,omitempty" yaml:"spaFallback,omitempty"`
+	Precompressed bool               `json:"precompressed,omitempty" yaml:"precompressed,omitempty"`
+	CacheControl  []CacheControlRule `json:"cacheControl,omitempty" yaml:"cacheControl,omitempty"`
+}
+
+// CacheControlRule sets the Cache-Control header of the files matching a pattern
+type CacheControlRule struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Value   string `json:"value" yaml:"value"`
+}
+
+// BodyTransformConfig reshapes the JSON bodies of the requests and responses of a backend
+// service. Bodies larger than MaxBodySize bytes are passed through untouched.
+type BodyTransformConfig struct {
+	Request     *JSONTransformConfig `json:"request,omitempty" yaml:"request,omitempty"`
+	Response    *JSONTransformConfig `json:"response,omitempty" yaml:"response,omitempty"`
+	MaxBodySize int64                `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+}
+
+// JSONTransformConfig changes a JSON document. Fields are addressed by dot separated paths.
+// Fields are renamed first, then removed, then added. Extract then replaces the document with
+// the value at a path, and Template with the output of a Go template executed on the document.
+type JSONTransformConfig struct {
+	Rename   map[string]string      `json:"rename,omitempty" yaml:"rename,omitempty"`
+	Remove   []string               `json:"remove,omitempty" yaml:"remove,omitempty"`
+	Add      map[string]interface{} `json:"add,omitempty" yaml:"add,omitempty"`
+	Extract  string                 `json:"extract,omitempty" yaml:"extract,omitempty"`
+	Template string                 `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// CacheConfig holds the response caching rules of a backend service. TTL is in seconds and
+// overrides the freshness lifetime given by the response headers.
+type CacheConfig struct {
+	Methods      []string      `json:"methods,omitempty" yaml:"methods,omitempty"`
+	StatusCodes  []int         `json:"statusCodes,omitempty" yaml:"statusCodes,omitempty"`
+	TTL          time.Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	KeyHeaders   []string      `json:"keyHeaders,omitempty" yaml:"keyHeaders,omitempty"`
+	IgnoreQuery  bool          `json:"ignoreQuery,omitempty" yaml:"ignoreQuery,omitempty"`
+	MaxEntrySize int64         `json:"maxEntrySize,omitempty" yaml:"maxEntrySize,omitempty"`
+}
+
+// CoalesceConfig holds the request coalescing rules of a backend service. Requests with the same
+// method, path, query and key headers share one upstream call. MaxWait is in seconds and
+// MaxBufferSize in bytes.
+type CoalesceConfig struct {
+	KeyHeaders    []string      `json:"keyHeaders,omitempty" yaml:"keyHeaders,omitempty"`
+	IgnoreQuery   bool          `json:"ignoreQuery,omitempty" yaml:"ignoreQuery,omitempty"`
+	MaxWait       time.Duration `json:"maxWait,omitempty" yaml:"maxWait,omitempty"`
+	MaxBufferSize int64         `json:"maxBufferSize,omitempty" yaml:"maxBufferSize,omitempty"`
+}
+
+// CompressionConfig holds the response compression rules of the gateway or a backend service.
+// Encodings are listed in order of preference and MinSize is in bytes.
+type CompressionConfig struct {
+	Disabled     bool     `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Encodings    []string `json:"encodings,omitempty" yaml:"encodings,omitempty"`
+	ContentTypes []string `json:"contentTypes,omitempty" yaml:"contentTypes,omitempty"`
+	MinSize      int64    `json:"minSize,omitempty" yaml:"minSize,omitempty"`
+	Decompress   bool     `json:"decompress,omitempty" yaml:"decompress,omitempty"`
+}
+
+// LimitsConfig holds the size limits of requests. MaxBodySize and MaxHeaderBytes are in bytes,
+// zero leaves a limit unset.
+type LimitsConfig struct {
+	MaxBodySize    int64 `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+	MaxHeaderBytes int   `json:"maxHeaderBytes,omitempty" yaml:"maxHeaderBytes,omitempty"`
+	MaxHeaders     int   `json:"maxHeaders,omitempty" yaml:"maxHeaders,omitempty"`
+	MaxURLLength   int   `json:"maxURLLength,omitempty" yaml:"maxURLLength,omitempty"`
+}
+
+// OpenAPIConfig holds the OpenAPI 3 spec requests to a backend service are validated against.
+// Spec is a file path or an http(s) URL.
+type OpenAPIConfig struct {
+	Spec              string `json:"spec" yaml:"spec"`
+	ValidateResponses bool   `json:"validateResponses,omitempty" yaml:"validateResponses,omitempty"`
+}
+
+// GRPCConfig holds the protobuf descriptor set, written by protoc with --include_imports and
+// --descriptor_set_out, whose google.api.http annotations bind REST/JSON requests to the methods
+// of a gRPC service
+type GRPCConfig struct {
+	DescriptorSet string `json:"descriptorSet" yaml:"descriptorSet"`
+}
+
+// IPFilterConfig holds the IP addresses and CIDR ranges allowed or denied access
+type IPFilterConfig struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// InternalTokenConfig configures the token minted by the gateway for a backend service
+type InternalTokenConfig struct {
+	Header   string            `json:"header,omitempty" yaml:"header,omitempty"`
+	Audience string            `json:"audience,omitempty" yaml:"audience,omitempty"`
+	TTL      time.Duration     `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Claims   map[string]string `json:"claims,omitempty" yaml:"claims,omitempty"`
+}
+
+// TokenIssuerConfig holds the configuration of the key used to sign internal tokens
+type TokenIssuerConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Issuer  string `yaml:"issuer"`
+	KeyFile string `yaml:"key_file"`
+	KeyID   string `yaml:"key_id"`
 }
 
 // APIConfig holds the API server configuration
@@ -56,8 +241,37 @@ type APIConfig struct {
 
 // GatewayConfig holds the gateway server configuration
 type GatewayConfig struct {
-	Addr string    `yaml:"addr"`
-	SSL  SSLConfig `yaml:"ssl"`
+	Addr           string             `yaml:"addr"`
+	SSL            SSLConfig          `yaml:"ssl"`
+	CORS           *CORSConfig        `yaml:"cors"`
+	IPFilter       *IPFilterConfig    `yaml:"ip_filter"`
+	TrustedProxies []string           `yaml:"trusted_proxies"`
+	ProxyProtocol  bool               `yaml:"proxy_protocol"`
+	Cache          *CacheStoreConfig  `yaml:"cache"`
+	Compression    *CompressionConfig `yaml:"compression"`
+	Limits         *LimitsConfig      `yaml:"limits"`
+	HTTP2          *HTTP2Config       `yaml:"http2"`
+}
+
+// HTTP2Config holds the HTTP/2 settings of the gateway listener. HTTP/2 is negotiated on TLS
+// listeners unless disabled, H2C serves cleartext HTTP/2 on plain ones. IdleTimeout is in seconds.
+type HTTP2Config struct {
+	Disabled                     bool          `yaml:"disabled"`
+	H2C                          bool          `yaml:"h2c"`
+	MaxConcurrentStreams         uint32        `yaml:"max_concurrent_streams"`
+	MaxReadFrameSize             uint32        `yaml:"max_read_frame_size"`
+	MaxUploadBufferPerConnection int32         `yaml:"max_upload_buffer_per_connection"`
+	MaxUploadBufferPerStream     int32         `yaml:"max_upload_buffer_per_stream"`
+	IdleTimeout                  time.Duration `yaml:"idle_timeout"`
+}
+
+// CacheStoreConfig holds the store of the response cache. MaxBytes is the budget of the memory
+// store; the redis store defaults to the redis_uri of the global section.
+type CacheStoreConfig struct {
+	Type        string `yaml:"type"`
+	MaxBytes    int64  `yaml:"max_bytes"`
+	RedisURI    string `yaml:"redis_uri"`
+	RedisPrefix string `yaml:"redis_prefix"`
 }
 
 // LoggingConfig holds the logging configuration
@@ -73,11 +287,12 @@ type PluginConfig struct {
 
 // Config holds the complete application configuration
 type Config struct {
-	GlobalConfig  GlobalConfig  `yaml:"global"`
-	APIConfig     APIConfig     `yaml:"api"`
-	GatewayConfig GatewayConfig `yaml:"gateway"`
-	LoggingConfig LoggingConfig `yaml:"logging"`
-	PluginConfig  PluginConfig  `yaml:"plugins"`
+	GlobalConfig  GlobalConfig      `yaml:"global"`
+	APIConfig     APIConfig         `yaml:"api"`
+	GatewayConfig GatewayConfig     `yaml:"gateway"`
+	LoggingConfig LoggingConfig     `yaml:"logging"`
+	PluginConfig  PluginConfig      `yaml:"plugins"`
+	TokenIssuer   TokenIssuerConfig `yaml:"token_issuer"`
 }
 
 // LoadConfig loads the application configuration from a YAML file and environment variables
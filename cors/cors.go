@@ -0,0 +1,232 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const (
+	headerOrigin           = "Origin"
+	headerVary             = "Vary"
+	headerRequestMethod    = "Access-Control-Request-Method"
+	headerRequestHeaders   = "Access-Control-Request-Headers"
+	headerAllowOrigin      = "Access-Control-Allow-Origin"
+	headerAllowMethods     = "Access-Control-Allow-Methods"
+	headerAllowHeaders     = "Access-Control-Allow-Headers"
+	headerAllowCredentials = "Access-Control-Allow-Credentials"
+	headerExposeHeaders    = "Access-Control-Expose-Headers"
+	headerMaxAge           = "Access-Control-Max-Age"
+
+	// regexPrefix marks an allowed origin as a regular expression
+	regexPrefix = "~"
+)
+
+var (
+	defaultAllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	defaultAllowedHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization"}
+)
+
+// Policy is a compiled CORS configuration
+type Policy struct {
+	allowAllOrigins  bool
+	origins          map[string]bool
+	originPatterns   []*regexp.Regexp
+	methods          map[string]bool
+	allowedMethods   string
+	allowAllHeaders  bool
+	headers          map[string]bool
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewPolicy compiles a CORS configuration. Allowed origins are matched exactly, may contain
+// '*' wildcards (e.g. "https://*.example.com") or are regular expressions when prefixed with '~'.
+// Regular expressions must match the whole origin.
+func NewPolicy(conf *config.CORSConfig) (*Policy, error) {
+	p := &Policy{
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowCredentials: conf.AllowCredentials,
+	}
+
+	for _, origin := range conf.AllowedOrigins {
+		switch {
+		case origin == "*":
+			p.allowAllOrigins = true
+		case strings.HasPrefix(origin, regexPrefix):
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(origin, regexPrefix) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed origin pattern %s: %w", origin, err)
+			}
+			p.originPatterns = append(p.originPatterns, re)
+		case strings.Contains(origin, "*"):
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[a-z0-9.-]+`)
+			p.originPatterns = append(p.originPatterns, regexp.MustCompile("^"+pattern+"$"))
+		default:
+			p.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	// Reflecting any origin with credentials would let every site make authenticated requests
+	if p.allowAllOrigins && p.allowCredentials {
+		return nil, fmt.Errorf("allowed origin * cannot be combined with allowCredentials")
+	}
+
+	methods := conf.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultAllowedMethods
+	}
+	normalized := make([]string, 0, len(methods))
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		p.methods[m] = true
+		normalized = append(normalized, m)
+	}
+	p.allowedMethods = strings.Join(normalized, ", ")
+
+	headers := conf.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultAllowedHeaders
+	}
+	for _, h := range headers {
+		if h == "*" {
+			p.allowAllHeaders = true
+			continue
+		}
+		p.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	p.allowedHeaders = strings.Join(headers, ", ")
+
+	p.exposedHeaders = strings.Join(conf.ExposedHeaders, ", ")
+	if conf.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(conf.MaxAge)
+	}
+
+	return p, nil
+}
+
+// IsPreflight reports whether the request is a CORS preflight request
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(headerOrigin) != "" && r.Header.Get(headerRequestMethod) != ""
+}
+
+// varyOnOrigin reports whether the response depends on the Origin request header
+func (p *Policy) varyOnOrigin() bool {
+	return !p.allowAllOrigins
+}
+
+func (p *Policy) isOriginAllowed(origin string) bool {
+	if p.allowAllOrigins {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, re := range p.originPatterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) areHeadersAllowed(requested string) bool {
+	if p.allowAllHeaders {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !p.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Policy) setAllowOrigin(h http.Header, origin string) {
+	if p.allowAllOrigins {
+		h.Set(headerAllowOrigin, "*")
+	} else {
+		h.Set(headerAllowOrigin, origin)
+	}
+	if p.allowCredentials {
+		h.Set(headerAllowCredentials, "true")
+	}
+}
+
+// HandlePreflight answers a preflight request. Preflights for disallowed origins, methods
+// or headers are rejected without CORS headers.
+func (p *Policy) HandlePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	addVary(h, headerOrigin, headerRequestMethod, headerRequestHeaders)
+
+	origin := r.Header.Get(headerOrigin)
+	method := strings.ToUpper(r.Header.Get(headerRequestMethod))
+	requestedHeaders := r.Header.Get(headerRequestHeaders)
+	if !p.isOriginAllowed(origin) || !p.methods[method] || !p.areHeadersAllowed(requestedHeaders) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	p.setAllowOrigin(h, origin)
+	h.Set(headerAllowMethods, p.allowedMethods)
+	if p.allowAllHeaders {
+		if requestedHeaders != "" {
+			h.Set(headerAllowHeaders, requestedHeaders)
+		}
+	} else {
+		h.Set(headerAllowHeaders, p.allowedHeaders)
+	}
+	if p.maxAge != "" {
+		h.Set(headerMaxAge, p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Decorate adds the CORS headers for an actual request to the response headers,
+// replacing any CORS headers set by the upstream service.
+func (p *Policy) Decorate(h http.Header, r *http.Request) {
+	for _, k := range []string{headerAllowOrigin, headerAllowCredentials, headerExposeHeaders, headerAllowMethods, headerAllowHeaders, headerMaxAge} {
+		h.Del(k)
+	}
+	if p.varyOnOrigin() {
+		addVary(h, headerOrigin)
+	}
+
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" || !p.isOriginAllowed(origin) {
+		return
+	}
+
+	p.setAllowOrigin(h, origin)
+	if p.exposedHeaders != "" {
+		h.Set(headerExposeHeaders, p.exposedHeaders)
+	}
+}
+
+// addVary adds values to the Vary header unless they are already present
+func addVary(h http.Header, values ...string) {
+	existing := make(map[string]bool)
+	for _, v := range h.Values(headerVary) {
+		for _, field := range strings.Split(v, ",") {
+			existing[strings.ToLower(strings.TrimSpace(field))] = true
+		}
+	}
+	if existing["*"] {
+		return
+	}
+	for _, v := range values {
+		if !existing[strings.ToLower(v)] {
+			h.Add(headerVary, v)
+		}
+	}
+}
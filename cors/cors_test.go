@@ -0,0 +1,109 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight(t *testing.T) {
+	policy, err := NewPolicy(&config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.tenants.example.com", `~^https://review-\d+\.example\.org$`, `~https://admin\.example\.com`},
+		AllowedMethods:   []string{"get", "put"},
+		AllowedHeaders:   []string{"Content-Type", "X-Api-Version"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		description    string
+		origin         string
+		method         string
+		headers        string
+		expectedStatus int
+	}{
+		{"exact origin", "https://app.example.com", "PUT", "content-type, x-api-version", http.StatusNoContent},
+		{"origin is case insensitive", "https://APP.example.com", "GET", "", http.StatusNoContent},
+		{"wildcard origin", "https://acme.tenants.example.com", "GET", "", http.StatusNoContent},
+		{"wildcard does not match parent", "https://tenants.example.com", "GET", "", http.StatusForbidden},
+		{"regex origin", "https://review-42.example.org", "GET", "", http.StatusNoContent},
+		{"regex origin without anchors", "https://admin.example.com", "GET", "", http.StatusNoContent},
+		{"regex origin matches the whole origin", "https://admin.example.com.evil.io", "GET", "", http.StatusForbidden},
+		{"unknown origin", "https://evil.com", "GET", "", http.StatusForbidden},
+		{"method not allowed", "https://app.example.com", "DELETE", "", http.StatusForbidden},
+		{"header not allowed", "https://app.example.com", "GET", "X-Secret", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "http://gateway/api", nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+			if tc.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tc.headers)
+			}
+			require.True(t, IsPreflight(req))
+
+			rr := httptest.NewRecorder()
+			policy.HandlePreflight(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			require.Contains(t, rr.Header().Values("Vary"), "Origin")
+			if tc.expectedStatus != http.StatusNoContent {
+				require.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+				return
+			}
+			require.Equal(t, tc.origin, rr.Header().Get("Access-Control-Allow-Origin"))
+			require.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+			require.Equal(t, "GET, PUT", rr.Header().Get("Access-Control-Allow-Methods"))
+			require.Equal(t, "Content-Type, X-Api-Version", rr.Header().Get("Access-Control-Allow-Headers"))
+			require.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+		})
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	policy, err := NewPolicy(&config.CORSConfig{
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway/api", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+
+	h := make(http.Header)
+	h.Set("Access-Control-Allow-Origin", "https://upstream.example.com")
+	h.Set("Vary", "Accept-Encoding")
+	policy.Decorate(h, req)
+
+	require.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "X-Request-Id", h.Get("Access-Control-Expose-Headers"))
+	// Responses allowed for any origin do not depend on the Origin header
+	require.Equal(t, []string{"Accept-Encoding"}, h.Values("Vary"))
+
+	policy, err = NewPolicy(&config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}})
+	require.NoError(t, err)
+
+	req.Header.Set("Origin", "https://evil.com")
+	h = make(http.Header)
+	policy.Decorate(h, req)
+	require.Empty(t, h.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, []string{"Origin"}, h.Values("Vary"))
+
+	// Decorating twice does not duplicate the Vary header
+	policy.Decorate(h, req)
+	require.Equal(t, []string{"Origin"}, h.Values("Vary"))
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	_, err := NewPolicy(&config.CORSConfig{AllowedOrigins: []string{"~(unclosed"}})
+	require.Error(t, err)
+
+	_, err = NewPolicy(&config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	require.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const DefaultIndex = "index.html"
+
+// encodings are the precompressed variants looked up next to a file, in order of preference
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Server serves the files of a local directory
+type Server struct {
+	root          http.FileSystem
+	index         string
+	spaFallback   bool
+	precompressed bool
+	cacheRules    []config.CacheControlRule
+}
+
+// NewServer compiles a static configuration. The root must be an existing directory.
+func NewServer(conf *config.StaticConfig) (*Server, error) {
+	if conf.Root == "" {
+		return nil, fmt.Errorf("static root is required")
+	}
+	info, err := os.Stat(conf.Root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid static root %s: %w", conf.Root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("static root %s is not a directory", conf.Root)
+	}
+
+	for _, rule := range conf.CacheControl {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cache control pattern %s: %w", rule.Pattern, err)
+		}
+	}
+
+	index := conf.Index
+	if index == "" {
+		index = DefaultIndex
+	}
+
+	return &Server{
+		root:          http.Dir(conf.Root),
+		index:         index,
+		spaFallback:   conf.SPAFallback,
+		precompressed: conf.Precompressed,
+		cacheRules:    conf.CacheControl,
+	}, nil
+}
+
+// Serve answers the request with the file at urlPath, the request path after the strip and
+// rewrite settings of the route. Directories are served by their index file. With the SPA
+// fallback, missing paths without a file extension are served the index file of the root.
+func (s *Server) Serve(w http.ResponseWriter, req *http.Request, urlPath string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	requested := path.Clean("/" + urlPath)
+	name := requested
+	f, info, err := s.open(name)
+	if err == nil && info.IsDir() {
+		f.Close()
+		// Relative links of the index file only resolve below a trailing slash
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			target := req.URL.Path + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, target, http.StatusMovedPermanently)
+			return
+		}
+		name = path.Join(name, s.index)
+		f, info, err = s.open(name)
+	}
+
+	if err != nil && s.spaFallback && os.IsNotExist(err) && path.Ext(requested) == "" {
+		name = "/" + s.index
+		f, info, err = s.open(name)
+	}
+
+	if err == nil && info.IsDir() {
+		f.Close()
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.NotFound(w, req)
+		case os.IsPermission(err):
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.serveFile(w, req, name, f, info)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, req *http.Request, name string, f http.File, info os.FileInfo) {
+	defer func() { f.Close() }()
+
+	// The content type is that of the original file, also when a compressed variant is served
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		var buf [512]byte
+		n, _ := io.ReadFull(f, buf[:])
+		ctype = http.DetectContentType(buf[:n])
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", ctype)
+
+	if value := s.cacheControl(name); value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+
+	var variant string
+	if s.precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range encodings {
+			if !acceptsEncoding(req, enc.name) {
+				continue
+			}
+			cf, cinfo, err := s.open(name + enc.ext)
+			if err != nil {
+				continue
+			}
+			if cinfo.IsDir() {
+				cf.Close()
+				continue
+			}
+			f.Close()
+			f, info, variant = cf, cinfo, enc.name
+			w.Header().Set("Content-Encoding", enc.name)
+			break
+		}
+	}
+
+	// Variants get their own validator as their bytes differ from the original
+	etag := fmt.Sprintf(`"%x-%x`, info.ModTime().UnixNano(), info.Size())
+	if variant != "" {
+		etag += "-" + variant
+	}
+	w.Header().Set("ETag", etag+`"`)
+
+	// ServeContent answers conditional and range requests
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
+
+func (s *Server) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// cacheControl returns the value of the first cache control rule matching the file
+func (s *Server) cacheControl(name string) string {
+	for _, rule := range s.cacheRules {
+		subject := path.Base(name)
+		if strings.Contains(rule.Pattern, "/") {
+			subject = name
+		}
+		if ok, _ := path.Match(rule.Pattern, subject); ok {
+			return rule.Value
+		}
+	}
+	return ""
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of the request lists the encoding
+// without a zero quality
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+				continue
+			}
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") && strings.Trim(strings.TrimPrefix(param, "q="), "0.") == "" {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
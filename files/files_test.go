@@ -0,0 +1,134 @@
+package files
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, conf config.StaticConfig) *Server {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":          "<html>app</html>",
+		"assets/app.js":       "console.log('app')",
+		"assets/app.js.gz":    "gzip bytes",
+		"assets/app.js.br":    "brotli bytes",
+		"docs/index.html":     "<html>docs</html>",
+		"assets/data.bin":     "0123456789",
+		"assets/style.css":    "body{}",
+		"assets/style.css.gz": "gzip css",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+
+	conf.Root = root
+	server, err := NewServer(&conf)
+	require.NoError(t, err)
+	return server
+}
+
+func serve(s *Server, method string, urlPath string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://example.com"+urlPath, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.Serve(w, req, req.URL.Path)
+	return w
+}
+
+func TestServe(t *testing.T) {
+	s := newTestServer(t, config.StaticConfig{})
+
+	w := serve(s, http.MethodGet, "/", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>app</html>", w.Body.String())
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = serve(s, http.MethodGet, "/docs", nil)
+	require.Equal(t, http.StatusMovedPermanently, w.Code)
+	require.Equal(t, "/docs/", w.Header().Get("Location"))
+
+	w = serve(s, http.MethodGet, "/docs/", nil)
+	require.Equal(t, "<html>docs</html>", w.Body.String())
+
+	w = serve(s, http.MethodGet, "/assets/app.js", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "console.log('app')", w.Body.String())
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+
+	require.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/dashboard", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/../../etc/passwd", nil).Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve(s, http.MethodPost, "/", nil).Code)
+}
+
+func TestServeSPAFallback(t *testing.T) {
+	s := newTestServer(t, config.StaticConfig{SPAFallback: true})
+
+	w := serve(s, http.MethodGet, "/dashboard/settings", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>app</html>", w.Body.String())
+
+	// Missing assets are not masked by the application
+	require.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/assets/missing.js", nil).Code)
+}
+
+func TestServeConditionalAndRange(t *testing.T) {
+	s := newTestServer(t, config.StaticConfig{})
+
+	w := serve(s, http.MethodGet, "/assets/data.bin", nil)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	w = serve(s, http.MethodGet, "/assets/data.bin", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serve(s, http.MethodGet, "/assets/data.bin", map[string]string{"Range": "bytes=2-5"})
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "2345", w.Body.String())
+	require.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+}
+
+func TestServePrecompressed(t *testing.T) {
+	s := newTestServer(t, config.StaticConfig{Precompressed: true})
+
+	w := serve(s, http.MethodGet, "/assets/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	require.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "brotli bytes", w.Body.String())
+	require.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	brEtag := w.Header().Get("ETag")
+
+	w = serve(s, http.MethodGet, "/assets/app.js", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "gzip bytes", w.Body.String())
+	require.NotEqual(t, brEtag, w.Header().Get("ETag"))
+
+	// Variants missing on disk fall back to the original
+	w = serve(s, http.MethodGet, "/assets/style.css", map[string]string{"Accept-Encoding": "br"})
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.Equal(t, "body{}", w.Body.String())
+}
+
+func TestServeCacheControl(t *testing.T) {
+	s := newTestServer(t, config.StaticConfig{CacheControl: []config.CacheControlRule{
+		{Pattern: "/assets/*", Value: "public, max-age=31536000, immutable"},
+		{Pattern: "*.html", Value: "no-cache"},
+	}})
+
+	require.Equal(t, "public, max-age=31536000, immutable", serve(s, http.MethodGet, "/assets/app.js", nil).Header().Get("Cache-Control"))
+	require.Equal(t, "no-cache", serve(s, http.MethodGet, "/docs/", nil).Header().Get("Cache-Control"))
+
+	_, err := NewServer(&config.StaticConfig{Root: t.TempDir(), CacheControl: []config.CacheControlRule{{Pattern: "[", Value: "no-store"}}})
+	require.Error(t, err)
+	_, err = NewServer(&config.StaticConfig{Root: filepath.Join(t.TempDir(), "missing")})
+	require.Error(t, err)
+}
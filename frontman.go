@@ -5,11 +5,22 @@ import (
 	"crypto/tls"
 	"fmt"
 	"github.com/Frontman-Labs/frontman/api"
+	"github.com/Frontman-Labs/frontman/auth"
 	"github.com/julienschmidt/httprouter"
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/compression"
 	"github.com/Frontman-Labs/frontman/config"
+	"github.com/Frontman-Labs/frontman/cors"
 	"github.com/Frontman-Labs/frontman/gateway"
+	"github.com/Frontman-Labs/frontman/ipfilter"
+	"github.com/Frontman-Labs/frontman/limits"
 	"github.com/Frontman-Labs/frontman/log"
 	"github.com/Frontman-Labs/frontman/plugins"
 	"github.com/Frontman-Labs/frontman/service"
@@ -23,6 +34,7 @@ type Frontman struct {
 	backendServices service.ServiceRegistry
 	conf            *config.Config
 	log             log.Logger
+	resolver        *ipfilter.Resolver
 }
 
 // NewFrontman creates a new Frontman instance with a Redis client connection factory
@@ -35,8 +47,73 @@ func NewFrontman(conf *config.Config, log log.Logger) (*Frontman, error) {
 		return nil, err
 	}
 
+	var (
+		routerOpts  []api.RouterOption
+		gatewayOpts []gateway.APIGatewayOption
+	)
+
+	// Create the issuer for gateway-minted internal tokens
+	if conf.TokenIssuer.Enabled {
+		issuer, err := auth.NewTokenIssuer(&conf.TokenIssuer)
+		if err != nil {
+			return nil, err
+		}
+		routerOpts = append(routerOpts, api.WithTokenIssuer(issuer))
+		gatewayOpts = append(gatewayOpts, gateway.WithTokenIssuer(issuer))
+	}
+
+	// Compile the default CORS policy of the gateway
+	if conf.GatewayConfig.CORS != nil {
+		policy, err := cors.NewPolicy(conf.GatewayConfig.CORS)
+		if err != nil {
+			return nil, err
+		}
+		gatewayOpts = append(gatewayOpts, gateway.WithCORSPolicy(policy))
+	}
+
+	// Compile the default compression policy of the gateway
+	if conf.GatewayConfig.Compression != nil {
+		policy, err := compression.NewPolicy(conf.GatewayConfig.Compression)
+		if err != nil {
+			return nil, err
+		}
+		gatewayOpts = append(gatewayOpts, gateway.WithCompressionPolicy(policy))
+	}
+
+	// Compile the default request limits of the gateway
+	if conf.GatewayConfig.Limits != nil {
+		requestLimits, err := limits.NewLimits(conf.GatewayConfig.Limits)
+		if err != nil {
+			return nil, err
+		}
+		gatewayOpts = append(gatewayOpts, gateway.WithLimits(requestLimits))
+	}
+
+	// Resolve client IPs behind trusted proxies and load the global IP rules
+	resolver, err := ipfilter.NewResolver(conf.GatewayConfig.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	ipFilter, err := ipfilter.NewFilter(conf.GatewayConfig.IPFilter)
+	if err != nil {
+		return nil, err
+	}
+	routerOpts = append(routerOpts, api.WithIPFilter(ipFilter))
+	gatewayOpts = append(gatewayOpts, gateway.WithIPFilter(ipFilter), gateway.WithClientIPResolver(resolver))
+
+	// Create the response cache shared by the services with caching rules
+	if conf.GatewayConfig.Cache != nil {
+		store, err := cache.NewStore(ctx, conf.GatewayConfig.Cache, conf.GlobalConfig.RedisURI)
+		if err != nil {
+			return nil, err
+		}
+		responseCache := cache.New(store)
+		routerOpts = append(routerOpts, api.WithCache(responseCache))
+		gatewayOpts = append(gatewayOpts, gateway.WithCache(responseCache))
+	}
+
 	// Create management API router
-	servicesRouter := api.NewServicesRouter(serviceRegistry)
+	servicesRouter := api.NewServicesRouter(serviceRegistry, routerOpts...)
 
 	// Load plugins
 	var plug []plugins.FrontmanPlugin
@@ -50,7 +127,7 @@ func NewFrontman(conf *config.Config, log log.Logger) (*Frontman, error) {
 	}
 
 	// Create new APIGateway instance
-	apiGateway := gateway.NewAPIGateway(serviceRegistry, plug, conf, log)
+	apiGateway := gateway.NewAPIGateway(serviceRegistry, plug, conf, log, gatewayOpts...)
 
 	// Create the Frontman instance
 	return &Frontman{
@@ -59,6 +136,7 @@ func NewFrontman(conf *config.Config, log log.Logger) (*Frontman, error) {
 		backendServices: serviceRegistry,
 		conf:            conf,
 		log:             log,
+		resolver:        resolver,
 	}, nil
 }
 
@@ -86,8 +164,12 @@ func (gw *Frontman) Start() error {
 	}
 	apiHandler = gw.service
 	api := createServer(apiAddr, apiHandler, apicert)
+	apiListener, err := net.Listen("tcp", apiAddr)
+	if err != nil {
+		return err
+	}
 	go func() {
-		if err := startServer(api); err != nil {
+		if err := startServer(api, apiListener); err != nil {
 			gw.log.Fatal(err)
 		}
 	}()
@@ -104,17 +186,39 @@ func (gw *Frontman) Start() error {
 		// Redirect HTTP traffic to HTTPS
 		httpAddr := "0.0.0.0:80"
 		httpRedirect := createRedirectServer(httpAddr, gatewayAddr)
+		redirectListener, err := net.Listen("tcp", httpAddr)
+		if err != nil {
+			return err
+		}
 		gw.log.Infof("Started HTTP redirect server on %s", httpAddr)
 		go func() {
-			if err := startServer(httpRedirect); err != nil {
+			if err := startServer(httpRedirect, redirectListener); err != nil {
 				gw.log.Fatal(err)
 			}
 		}()
 	}
 	gatewayHandler = gw.router
 	gateway := createServer(gatewayAddr, gatewayHandler, gwcert)
+	if requestLimits := gw.conf.GatewayConfig.Limits; requestLimits != nil && requestLimits.MaxHeaderBytes > 0 {
+		// The server rejects larger headers before they reach the gateway handler
+		gateway.MaxHeaderBytes = requestLimits.MaxHeaderBytes
+	}
+	if err := configureHTTP2(gateway, gw.conf.GatewayConfig.HTTP2); err != nil {
+		return err
+	}
+	gatewayListener, err := net.Listen("tcp", gatewayAddr)
+	if err != nil {
+		return err
+	}
+	if gw.conf.GatewayConfig.ProxyProtocol {
+		// Take the client address from PROXY protocol headers sent by trusted proxies
+		gatewayListener = &proxyproto.Listener{
+			Listener: gatewayListener,
+			Policy:   gw.resolver.ProxyProtocolPolicy,
+		}
+	}
 	gw.log.WithFields(log.InfoLevel, fmt.Sprintf("Started Frontman Frontman on %s", gatewayAddr), log.Bool("tls_enabled", gw.conf.GatewayConfig.SSL.Enabled))
-	if err := startServer(gateway); err != nil {
+	if err := startServer(gateway, gatewayListener); err != nil {
 		return err
 	}
 
@@ -123,7 +227,7 @@ func (gw *Frontman) Start() error {
 
 func createRedirectServer(addr string, redirectAddr string) *http.Server {
 	redirect := func(w http.ResponseWriter, req *http.Request) {
-		httpsURL := "https://" + req.Host + req.URL.Path
+		httpsURL := "https://" + req.Host + req.URL.RequestURI()
 		http.Redirect(w, req, httpsURL, http.StatusMovedPermanently)
 	}
 	return &http.Server{
@@ -145,13 +249,51 @@ func createServer(addr string, handler http.Handler, cert *tls.Certificate) *htt
 	return server
 }
 
-func startServer(server *http.Server) error {
+// configureHTTP2 applies the HTTP/2 settings of the gateway to its server. HTTP/2 is negotiated
+// with ALPN on TLS, h2c serves it in cleartext to clients with prior knowledge or an Upgrade.
+// Without settings the server keeps the defaults of net/http.
+func configureHTTP2(server *http.Server, conf *config.HTTP2Config) error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Disabled {
+		// A non-nil map keeps net/http from enabling HTTP/2 on TLS connections
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	if conf.MaxReadFrameSize != 0 && (conf.MaxReadFrameSize < 16<<10 || conf.MaxReadFrameSize > 1<<24-1) {
+		return fmt.Errorf("invalid HTTP/2 max read frame size %d, expected 16384 to 16777215", conf.MaxReadFrameSize)
+	}
+	if conf.MaxUploadBufferPerConnection < 0 || conf.MaxUploadBufferPerStream < 0 || conf.IdleTimeout < 0 {
+		return fmt.Errorf("HTTP/2 settings cannot be negative")
+	}
+
+	h2s := &http2.Server{
+		MaxConcurrentStreams:         conf.MaxConcurrentStreams,
+		MaxReadFrameSize:             conf.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: conf.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     conf.MaxUploadBufferPerStream,
+		IdleTimeout:                  conf.IdleTimeout * time.Second,
+	}
+	// ConfigureServer would create a TLS config for cleartext servers, which then can't start
+	if server.TLSConfig != nil {
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			return err
+		}
+	}
+	if conf.H2C {
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	}
+	return nil
+}
+
+func startServer(server *http.Server, listener net.Listener) error {
 	if server.TLSConfig != nil {
-		if err := server.ListenAndServeTLS("", ""); err != nil {
+		if err := server.ServeTLS(listener, "", ""); err != nil {
 			return fmt.Errorf("Failed to start server with TLS: %w", err)
 		}
 	} else {
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.Serve(listener); err != nil {
 			return fmt.Errorf("Failed to start server without TLS: %w", err)
 		}
 	}
@@ -1,9 +1,16 @@
 package frontman
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"golang.org/x/net/http2"
 )
 
 func TestCreateRedirectServer(t *testing.T) {
@@ -32,4 +39,102 @@ func TestCreateRedirectServer(t *testing.T) {
 	if location := rr.Header().Get("Location"); location != expectedURL {
 		t.Errorf("Unexpected Location header value: got %v, expected %v", location, expectedURL)
 	}
+
+	// The query string is preserved
+	req, err = http.NewRequest("GET", "http://example.com/search?q=frontman&page=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	redirectServer.Handler.ServeHTTP(rr, req)
+
+	expectedURL = "https://example.com/search?q=frontman&page=2"
+	if location := rr.Header().Get("Location"); location != expectedURL {
+		t.Errorf("Unexpected Location header value: got %v, expected %v", location, expectedURL)
+	}
+}
+
+func TestConfigureHTTP2(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	serve := func(t *testing.T, server *http.Server) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go startServer(server, listener)
+		t.Cleanup(func() { server.Close() })
+		return listener.Addr().String()
+	}
+	get := func(t *testing.T, client *http.Client, url string) string {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.Proto
+	}
+
+	t.Run("h2c", func(t *testing.T) {
+		server := createServer("", protoHandler, nil)
+		if err := configureHTTP2(server, &config.HTTP2Config{H2C: true, MaxConcurrentStreams: 10}); err != nil {
+			t.Fatal(err)
+		}
+		addr := serve(t, server)
+
+		// Clients with prior knowledge speak HTTP/2 without TLS
+		client := &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}}
+		if proto := get(t, client, "http://"+addr); proto != "HTTP/2.0" {
+			t.Errorf("Expected HTTP/2.0, got %s", proto)
+		}
+		// while the others keep using HTTP/1.1
+		if proto := get(t, http.DefaultClient, "http://"+addr); proto != "HTTP/1.1" {
+			t.Errorf("Expected HTTP/1.1, got %s", proto)
+		}
+	})
+
+	// Borrow the certificate of a test server for the TLS listeners
+	tlsServer := httptest.NewTLSServer(protoHandler)
+	defer tlsServer.Close()
+	cert := tlsServer.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsServer.Certificate())
+
+	testCases := []struct {
+		name     string
+		conf     *config.HTTP2Config
+		expected string
+	}{
+		{"TLS defaults", nil, "HTTP/2.0"},
+		{"TLS tuned", &config.HTTP2Config{MaxConcurrentStreams: 50, MaxReadFrameSize: 1 << 20, IdleTimeout: 30}, "HTTP/2.0"},
+		{"TLS disabled", &config.HTTP2Config{Disabled: true}, "HTTP/1.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := createServer("", protoHandler, &cert)
+			if err := configureHTTP2(server, tc.conf); err != nil {
+				t.Fatal(err)
+			}
+			addr := serve(t, server)
+
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "example.com"},
+				ForceAttemptHTTP2: true,
+			}}
+			if proto := get(t, client, "https://"+addr); proto != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, proto)
+			}
+		})
+	}
+
+	if err := configureHTTP2(createServer("", protoHandler, nil), &config.HTTP2Config{MaxReadFrameSize: 1024}); err == nil {
+		t.Errorf("Expected an error for a frame size below the HTTP/2 minimum")
+	}
 }
@@ -1,9 +1,21 @@
 package gateway
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/compression"
 	"github.com/Frontman-Labs/frontman/config"
+	"github.com/Frontman-Labs/frontman/cors"
+	"github.com/Frontman-Labs/frontman/grpcproxy"
+	"github.com/Frontman-Labs/frontman/ipfilter"
+	"github.com/Frontman-Labs/frontman/limits"
 	"github.com/Frontman-Labs/frontman/log"
+	"github.com/Frontman-Labs/frontman/mirror"
+	"github.com/Frontman-Labs/frontman/openapi"
 	"github.com/Frontman-Labs/frontman/plugins"
 	"github.com/Frontman-Labs/frontman/service"
 	"io"
@@ -12,23 +24,111 @@ import (
 	"strings"
 )
 
+const requestIDHeader = "X-Request-Id"
+
 type APIGateway struct {
-	reg   service.ServiceRegistry
-	plugs []plugins.FrontmanPlugin
-	conf  *config.Config
-	log   log.Logger
+	reg         service.ServiceRegistry
+	plugs       []plugins.FrontmanPlugin
+	conf        *config.Config
+	log         log.Logger
+	issuer      *auth.TokenIssuer
+	cors        *cors.Policy
+	ipFilter    *ipfilter.Filter
+	resolver    *ipfilter.Resolver
+	cache       *cache.Cache
+	compression *compression.Policy
+	limits      *limits.Limits
+}
+
+type APIGatewayOption func(*APIGateway)
+
+// WithTokenIssuer sets the issuer used to mint internal tokens for backend services
+func WithTokenIssuer(issuer *auth.TokenIssuer) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.issuer = issuer
+	}
+}
+
+// WithCORSPolicy sets the CORS policy applied to backend services without their own policy
+func WithCORSPolicy(policy *cors.Policy) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.cors = policy
+	}
+}
+
+// WithIPFilter sets the global IP allow and deny rules
+func WithIPFilter(filter *ipfilter.Filter) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.ipFilter = filter
+	}
+}
+
+// WithClientIPResolver sets the resolver used to determine the client IP behind trusted proxies
+func WithClientIPResolver(resolver *ipfilter.Resolver) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.resolver = resolver
+	}
+}
+
+// WithCache sets the cache answering requests of backend services with caching rules
+func WithCache(c *cache.Cache) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.cache = c
+	}
+}
+
+// WithCompressionPolicy sets the compression policy applied to backend services without their own policy
+func WithCompressionPolicy(policy *compression.Policy) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.compression = policy
+	}
 }
 
-func NewAPIGateway(bs service.ServiceRegistry, plugs []plugins.FrontmanPlugin, conf *config.Config, logger log.Logger) *APIGateway {
-	return &APIGateway{
-		reg:   bs,
-		plugs: plugs,
-		conf:  conf,
-		log:   logger,
+// WithLimits sets the request limits applied where backend services don't set their own
+func WithLimits(l *limits.Limits) APIGatewayOption {
+	return func(g *APIGateway) {
+		g.limits = l
 	}
 }
 
+func NewAPIGateway(bs service.ServiceRegistry, plugs []plugins.FrontmanPlugin, conf *config.Config, logger log.Logger, opts ...APIGatewayOption) *APIGateway {
+	g := &APIGateway{
+		reg:      bs,
+		plugs:    plugs,
+		conf:     conf,
+		log:      logger,
+		resolver: &ipfilter.Resolver{},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
 func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// gRPC clients only understand errors carried by a gRPC status, translate those of the gateway
+	if grpcproxy.IsGRPC(req) || grpcproxy.IsGRPCWeb(req) {
+		grpcWriter := grpcproxy.NewResponseWriter(w, req)
+		defer grpcWriter.Finish()
+		w = grpcWriter
+	}
+
+	// Reject clients denied by the global IP rules before doing any other work
+	clientIP := g.resolver.ClientIP(req)
+	if g.ipFilter != nil && !g.ipFilter.Allowed(clientIP) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	// Routing keeps clients on the same backend of a traffic split by their resolved IP
+	ctx := service.WithClientIP(req.Context(), clientIP)
+
+	// Identify the request for header rules, keeping an ID assigned by a proxy in front of the gateway
+	requestID := req.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	req = req.WithContext(service.WithRequestID(ctx, requestID))
+
 	for _, plugin := range g.plugs {
 		if err := plugin.PreRequest(req, g.reg, g.conf); err != nil {
 			g.log.Errorf("Plugin error: %v", err)
@@ -38,50 +138,89 @@ func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Find the backend service that matches the request
-	backendService := g.reg.GetTrie().FindBackendService(req)
+	match := g.reg.GetTrie().Lookup(req)
 
 	// If the backend service was not found, return a 404 error
-	if backendService == nil {
+	if match == nil {
 		http.NotFound(w, req)
 		return
 	}
+	backendService := match.Service
 
-	// Get the upstream target URL for this request
-	upstreamTarget := backendService.GetLoadBalancer().ChooseTarget(backendService.UpstreamTargets)
+	// Make the captured path parameters available to plugins through the request context
+	req = req.WithContext(service.WithParams(req.Context(), match.Params))
 
-	urlPath := req.URL.Path
-	if backendService.StripPath {
-		urlPath = strings.TrimPrefix(req.URL.Path, backendService.Path)
+	// Rules that failed to compile are reported when the service is initialised, the service
+	// stays closed until they are fixed
+	rules := backendService.GetIPRules()
+	if backendService.IPFilter != nil && rules == nil {
+		http.Error(w, "ip filter not available", http.StatusServiceUnavailable)
+		return
+	}
+	if rules != nil && !rules.Allowed(clientIP) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
 	}
 
-	// Use the compiledRewriteMatch field in the backendService struct to apply the rewrite
-	if backendService.GetCompiledRewriteMatch() != nil {
-		urlPath = backendService.GetCompiledRewriteMatch().ReplaceAllString(urlPath, backendService.RewriteReplace)
+	// gRPC services are called natively, gRPC-Web calls from browsers are translated on the way
+	// and their trailers sent back in the body
+	if backendService.Scheme == service.SchemeGRPC && grpcproxy.IsGRPCWeb(req) {
+		var webWriter *grpcproxy.WebWriter
+		webWriter, req = grpcproxy.TranslateWeb(w, req)
+		defer webWriter.Finish()
+		w = webWriter
 	}
 
-	// Create a new target URL with the service path and scheme
-	targetURL, err := url.Parse(upstreamTarget + urlPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Reject oversized requests before authenticating them or contacting the upstream
+	if backendService.Limits != nil && backendService.GetLimits() == nil {
+		http.Error(w, "request limits not available", http.StatusServiceUnavailable)
+		return
+	}
+	if requestLimits := backendService.GetLimits().Merge(g.limits); requestLimits != nil && !requestLimits.Enforce(w, req) {
 		return
 	}
 
-	// Add query parameters if they are available
-	if req.URL.RawQuery != "" {
-		targetURL.RawQuery = req.URL.RawQuery
+	// Answer CORS preflight requests at the gateway and decorate every other response
+	corsPolicy := backendService.GetCORSPolicy()
+	if corsPolicy == nil {
+		corsPolicy = g.cors
+	}
+	if corsPolicy != nil {
+		if cors.IsPreflight(req) {
+			corsPolicy.HandlePreflight(w, req)
+			return
+		}
+		corsPolicy.Decorate(w.Header(), req)
 	}
 
-	// Get client for backend service
-	client := backendService.GetHttpClient()
+	// Strip and rewrite settings belong to the route the request matched
+	route := match.Route
+	urlPath := req.URL.Path
+	if route.StripPath {
+		urlPath = strings.TrimPrefix(req.URL.Path, match.MatchedPath)
+	}
+
+	// Rewrite the path, host and query of the upstream request as configured on the route.
+	// Path parameters and host captures can be referenced in the templates as {name}.
+	urlPath, upstreamHost, rawQuery, err := route.RewriteRequest(req, match.Params, urlPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Copy the headers from the original request
 	headers := make(http.Header)
 	copyHeaders(headers, req.Header)
 
+	var claims map[string]interface{}
 	if backendService.AuthConfig != nil {
 		tokenValidator := backendService.GetTokenValidator()
+		if tokenValidator == nil {
+			http.Error(w, "auth not available", http.StatusInternalServerError)
+			return
+		}
 		// Backend service has auth config specified
-		claims, err := tokenValidator.ValidateToken(req)
+		claims, err = tokenValidator.ValidateToken(req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
@@ -97,14 +236,215 @@ func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 
 	}
+
+	if backendService.InternalToken != nil {
+		// Never forward a client supplied token in place of the gateway-minted one
+		headers.Del(backendService.GetInternalTokenHeader())
+		if g.issuer == nil {
+			g.log.Errorf("Internal token requested by service %s but no token issuer is configured", backendService.Name)
+			http.Error(w, "internal token issuer not configured", http.StatusInternalServerError)
+			return
+		}
+		// Only credentials the token validator checked name the subject of the token
+		var identity map[string]interface{}
+		if backendService.AuthConfig != nil {
+			identity = auth.Identity(claims, req)
+		}
+		token, err := g.issuer.Issue(identity, backendService.InternalToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		headers.Set(backendService.GetInternalTokenHeader(), token)
+	}
+
+	if backendService.UpstreamAuth != nil {
+		credentials := backendService.GetUpstreamCredentials()
+		if credentials == nil {
+			http.Error(w, "upstream credentials not available", http.StatusInternalServerError)
+			return
+		}
+		if err := credentials.SetCredentials(headers); err != nil {
+			g.log.Errorf("Failed to set upstream credentials for service %s: %v", backendService.Name, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	// Redirect, response and static services answer at the gateway without an upstream, once
+	// the request is authenticated
+	switch backendService.Type {
+	case service.ServiceTypeRedirect:
+		redirect := backendService.GetRedirect()
+		if redirect == nil {
+			http.Error(w, "redirect not available", http.StatusInternalServerError)
+			return
+		}
+		redirect.Serve(w, req, urlPath, match.Params.Expand)
+		return
+	case service.ServiceTypeResponse:
+		static := backendService.GetStaticResponse()
+		if static == nil {
+			http.Error(w, "static response not available", http.StatusInternalServerError)
+			return
+		}
+		static.ServeHTTP(w, req)
+		return
+	case service.ServiceTypeStatic:
+		server := backendService.GetFileServer()
+		if server == nil {
+			http.Error(w, "static files not available", http.StatusInternalServerError)
+			return
+		}
+		server.Serve(w, req, urlPath)
+		return
+	}
+
+	// Get the upstream target URL for this request
+	upstreamTarget := backendService.GetLoadBalancer().ChooseTarget(backendService.UpstreamTargets)
+
+	// Create a new target URL with the service path and scheme
+	targetURL, err := url.Parse(upstreamTarget + urlPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Add query parameters if they are available
+	if rawQuery != "" {
+		targetURL.RawQuery = rawQuery
+	}
+	if upstreamHost == "" {
+		upstreamHost = targetURL.Host
+	}
+
+	// Get client for backend service
+	client := backendService.GetHttpClient()
+
 	// Remove the X-Forwarded-For header to prevent spoofing
 	headers.Del("X-Forwarded-For")
 
+	// Apply the header rules of the service last so they can override the headers set above
+	transformer := backendService.GetHeaderTransformer()
+	if backendService.Headers != nil && transformer == nil {
+		http.Error(w, "header rules not available", http.StatusServiceUnavailable)
+		return
+	}
+	if transformer != nil {
+		transformer.TransformRequest(headers, req, claims)
+	}
+
+	// gRPC calls are streamed both ways and answered with the trailers of the upstream, they skip
+	// the body handling below
+	if backendService.Scheme == service.SchemeGRPC {
+		transcoder := backendService.GetTranscoder()
+		if !grpcproxy.IsGRPC(req) && transcoder == nil {
+			// Descriptors that failed to load are reported when the service is initialised
+			if backendService.GRPC != nil {
+				http.Error(w, "grpc transcoder not available", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+		upstreamReq := (&http.Request{
+			Method:        req.Method,
+			URL:           targetURL,
+			Proto:         req.Proto,
+			ProtoMajor:    req.ProtoMajor,
+			ProtoMinor:    req.ProtoMinor,
+			Header:        headers,
+			Body:          req.Body,
+			ContentLength: req.ContentLength,
+			Host:          upstreamHost,
+		}).WithContext(req.Context())
+		decorate := func(header http.Header) {
+			if transformer != nil {
+				transformer.TransformResponse(header, req, claims)
+			}
+			if corsPolicy != nil {
+				corsPolicy.Decorate(header, req)
+			}
+		}
+		defer backendService.GetLoadBalancer().Done(upstreamTarget)
+
+		// REST/JSON requests are transcoded to the method their path is bound to
+		if !grpcproxy.IsGRPC(req) {
+			g.log.Infof("Transcoding request to %s: %s %s", upstreamTarget, req.Method, urlPath)
+			if !transcoder.Transcode(w, upstreamReq, urlPath, client, decorate) {
+				http.NotFound(w, req)
+			}
+			return
+		}
+
+		g.log.Infof("Sending gRPC call to %s: %s", upstreamTarget, urlPath)
+		if err := grpcproxy.Forward(w, upstreamReq, client, decorate); err != nil {
+			g.log.Infof("Error proxying gRPC call to %s: %v", upstreamTarget, err)
+		}
+		return
+	}
+
+	// Reshape JSON request bodies before they are mirrored or sent upstream
+	bodyTransformer := backendService.GetBodyTransformer()
+	if bodyTransformer != nil {
+		bodyTransformer.TransformRequest(req)
+	}
+
+	// Check the request as it will be sent upstream against the OpenAPI spec of the service.
+	// Requests are not let through unchecked when the spec failed to load.
+	var operation *openapi.Operation
+	validator := backendService.GetOpenAPIValidator()
+	if backendService.OpenAPI != nil && validator == nil {
+		http.Error(w, "openapi validator not available", http.StatusServiceUnavailable)
+		return
+	}
+	if validator != nil {
+		checked := (&http.Request{
+			Method:        req.Method,
+			URL:           &url.URL{Path: urlPath, RawQuery: rawQuery},
+			Header:        headers,
+			Body:          req.Body,
+			ContentLength: req.ContentLength,
+			Host:          upstreamHost,
+		}).WithContext(req.Context())
+		operation, err = validator.ValidateRequest(checked)
+		if err != nil {
+			var validationErr *openapi.Error
+			switch {
+			case errors.As(err, &validationErr):
+				validationErr.Write(w)
+			case limits.IsBodyTooLarge(err):
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			default:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		// The validator read the body and put it back
+		req.Body, req.ContentLength = checked.Body, checked.ContentLength
+	}
+
+	// Copy a share of the requests to the mirror target, which must be done before the body is
+	// handed to the primary upstream. The mirror never receives the credentials, the internal
+	// token or the user data meant for the primary upstream.
+	var shadow *mirror.Shadow
+	if m := backendService.GetMirror(); m != nil {
+		mirrored := headers.Clone()
+		mirrored.Del("Authorization")
+		if backendService.InternalToken != nil {
+			mirrored.Del(backendService.GetInternalTokenHeader())
+		}
+		if backendService.AuthConfig != nil {
+			mirrored.Del(backendService.GetUserDataHeader())
+		}
+		shadow = m.Copy(req, urlPath, rawQuery, mirrored)
+	}
+
 	// Log a message indicating that the request is being sent to the target service
 	g.log.Infof("Sending request to %s: %s %s", upstreamTarget, req.Method, urlPath)
 
 	// Send the request to the target service using the client with the specified transport
-	resp, err := client.Do(&http.Request{
+	upstreamReq := &http.Request{
 		Method:        req.Method,
 		URL:           targetURL,
 		Proto:         req.Proto,
@@ -113,17 +453,45 @@ func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		Header:        headers,
 		Body:          req.Body,
 		ContentLength: req.ContentLength,
-		Host:          targetURL.Host,
-	})
+		Host:          upstreamHost,
+	}
+	// Answer from the response cache when the service has caching rules
+	fetch := client.Do
+	if policy := backendService.GetCachePolicy(); policy != nil && g.cache != nil {
+		fetch = func(r *http.Request) (*http.Response, error) {
+			return g.cache.Do(policy, backendService.Name, client, r)
+		}
+	}
+
+	// Collapse identical concurrent requests into one upstream call, which also spares the
+	// upstream when a popular cache entry expires
+	var resp *http.Response
+	if coalescer := backendService.GetCoalescer(); coalescer != nil {
+		resp, err = coalescer.Do(upstreamReq.WithContext(req.Context()), req.Header, fetch)
+	} else {
+		resp, err = fetch(upstreamReq.WithContext(req.Context()))
+	}
 	if err != nil {
+		shadow.Done(http.StatusBadGateway)
+		if limits.IsBodyTooLarge(err) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		g.log.Infof("Error sending request: %v\n", err.Error())
 		return
 	}
+	shadow.Done(resp.StatusCode)
 
 	backendService.GetLoadBalancer().Done(upstreamTarget)
 
-	defer resp.Body.Close()
+	// The body may be replaced by transforms and compression, close the last one
+	defer func() { resp.Body.Close() }()
+
+	// Report responses of the upstream that break its contract, they are still returned
+	if err := operation.ValidateResponse(req.Context(), resp); err != nil {
+		g.log.Warnf("Service %s answered %s %s: %v", backendService.Name, req.Method, urlPath, err)
+	}
 
 	for _, plugin := range g.plugs {
 		if err := plugin.PostResponse(resp, g.reg, g.conf); err != nil {
@@ -133,11 +501,30 @@ func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if bodyTransformer != nil {
+		bodyTransformer.TransformResponse(resp)
+	}
+
+	// Compress the response for the client, once its body has its final shape
+	compressionPolicy := backendService.GetCompressionPolicy()
+	if compressionPolicy == nil {
+		compressionPolicy = g.compression
+	}
+	if compressionPolicy != nil {
+		compressionPolicy.Apply(resp, req)
+	}
+
 	// Log a message indicating that the response has been received from the target service
 	g.log.Infof("Response received from %s: %d %s", upstreamTarget, resp.StatusCode, resp.Status)
 
 	// Copy the response headers back to the client
 	copyHeaders(w.Header(), resp.Header)
+	if transformer != nil {
+		transformer.TransformResponse(w.Header(), req, claims)
+	}
+	if corsPolicy != nil {
+		corsPolicy.Decorate(w.Header(), req)
+	}
 
 	// Set the status code and body of the response
 	w.WriteHeader(resp.StatusCode)
@@ -145,6 +532,14 @@ func (g *APIGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 }
 
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
 func copyHeaders(dst, src http.Header) {
 	for k, v := range src {
 		dst[k] = v
@@ -1,12 +1,43 @@
 package gateway
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/compression"
+	"github.com/Frontman-Labs/frontman/ipfilter"
+	"github.com/Frontman-Labs/frontman/limits"
 	"github.com/Frontman-Labs/frontman/loadbalancer"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -48,16 +79,49 @@ func (p *testPlugin) Close() plugins.PluginError {
 }
 
 type mockHTTPClient struct {
-	mockResponse *http.Response
-	mockErr      error
-	requestURL   string
+	mockResponse  *http.Response
+	mockErr       error
+	requestURL    string
+	requestHost   string
+	requestHeader http.Header
 }
 
 func (m *mockHTTPClient) RoundTrip(req *http.Request) (*http.Response, error) {
 	m.requestURL = req.URL.String()
+	m.requestHost = req.Host
+	m.requestHeader = req.Header
+	if m.mockResponse != nil {
+		// Like http.Transport, link the response to the request that produced it
+		m.mockResponse.Request = req
+	}
 	return m.mockResponse, m.mockErr
 }
 
+// newTestRegistry returns a memory registry holding the services, which must be initialized first
+func newTestRegistry(tb testing.TB, services ...*service.BackendService) service.ServiceRegistry {
+	tb.Helper()
+	reg, err := service.NewServiceRegistry(context.Background(), "memory", nil)
+	if err != nil {
+		tb.Fatalf("could not create service registry due to: %s", err)
+	}
+	for _, bs := range services {
+		if err := reg.AddService(bs); err != nil {
+			tb.Fatalf("could not add service %s due to: %s", bs.Name, err)
+		}
+	}
+	return reg
+}
+
+// newTestGateway returns a gateway serving the services of the registry
+func newTestGateway(tb testing.TB, reg service.ServiceRegistry, plugs []plugins.FrontmanPlugin, opts ...APIGatewayOption) *APIGateway {
+	tb.Helper()
+	logger, err := log.NewZapLogger("info")
+	if err != nil {
+		tb.Fatalf("could not create logger due to: %s", err)
+	}
+	return NewAPIGateway(reg, plugs, &config.Config{}, logger, opts...)
+}
+
 func TestGatewayHandler(t *testing.T) {
 	testCases := []struct {
 		name                       string
@@ -303,6 +367,335 @@ func TestGatewayHandler(t *testing.T) {
 	}
 }
 
+func TestGatewayInternalToken(t *testing.T) {
+	issuer, err := auth.NewTokenIssuer(&config.TokenIssuerConfig{Issuer: "frontman"})
+	if err != nil {
+		t.Fatalf("could not create token issuer: %s", err)
+	}
+
+	bs := &service.BackendService{
+		Name:            "internal_token",
+		Path:            "/api",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		AuthConfig: &config.AuthConfig{
+			AuthType:        "basic",
+			BasicAuthConfig: &config.BasicAuthConfig{Username: "test", Password: "test"},
+		},
+		InternalToken: &config.InternalTokenConfig{
+			Header: "X-Identity",
+			Claims: map[string]string{"gateway": "frontman"},
+		},
+	}
+	bs.Init()
+
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+		},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil, WithTokenIssuer(issuer))
+
+	req := httptest.NewRequest("GET", "http://localhost/api/anything", nil)
+	req.SetBasicAuth("test", "test")
+	req.Header.Set("X-Identity", "forged")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	token, err := jwt.Parse([]byte(mockClient.requestHeader.Get("X-Identity")), jwt.WithKeySet(issuer.PublicKeys()))
+	if err != nil {
+		t.Fatalf("Upstream received an invalid internal token: %s", err)
+	}
+	if token.Subject() != "test" {
+		t.Errorf("Expected internal token subject to be 'test', got '%s'", token.Subject())
+	}
+	if claim, _ := token.Get("gateway"); claim != "frontman" {
+		t.Errorf("Expected internal token to carry the configured claims, got '%v'", claim)
+	}
+}
+
+func TestGatewayInternalTokenWithoutAuth(t *testing.T) {
+	issuer, err := auth.NewTokenIssuer(&config.TokenIssuerConfig{})
+	if err != nil {
+		t.Fatalf("could not create token issuer: %s", err)
+	}
+
+	bs := &service.BackendService{
+		Name:            "internal_token",
+		Path:            "/api",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		InternalToken:   &config.InternalTokenConfig{Claims: map[string]string{"gateway": "frontman"}},
+	}
+	bs.Init()
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	handler := newTestGateway(t, newTestRegistry(t, bs), nil, WithTokenIssuer(issuer))
+
+	// Nothing checked the credentials, so they do not name the subject of the token
+	req := httptest.NewRequest("GET", "http://localhost/api/anything", nil)
+	req.SetBasicAuth("admin", "whatever")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	token, err := jwt.Parse([]byte(mockClient.requestHeader.Get(auth.DefaultInternalTokenHeader)), jwt.WithKeySet(issuer.PublicKeys()))
+	if err != nil {
+		t.Fatalf("Upstream received an invalid internal token: %s", err)
+	}
+	if token.Subject() != "" {
+		t.Errorf("Expected internal token without subject, got '%s'", token.Subject())
+	}
+}
+
+func TestGatewayCORS(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "cors",
+		Path:            "/api",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		CORS: &config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"GET", "POST"},
+		},
+	}
+	bs.Init()
+
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Vary": []string{"Accept-Encoding"}},
+			Body:       http.NoBody,
+		},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	// Preflight requests are answered by the gateway
+	req := httptest.NewRequest(http.MethodOptions, "http://localhost/api/orders", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if mockClient.requestURL != "" {
+		t.Errorf("Expected preflight not to be proxied, upstream received '%s'", mockClient.requestURL)
+	}
+
+	// Actual requests are proxied and decorated
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/api/orders", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected Access-Control-Allow-Origin to be set, got '%s'", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if vary := w.Header().Values("Vary"); !reflect.DeepEqual(vary, []string{"Accept-Encoding", "Origin"}) {
+		t.Errorf("Expected Vary to include the upstream value and Origin, got %v", vary)
+	}
+}
+
+func TestGatewayCORSPreflightMethodMatch(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "orders_write",
+		Path:            "/api",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		Match:           &service.MatchConditions{Methods: []string{"POST"}},
+		CORS: &config.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"POST"},
+		},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	for requested, expected := range map[string]int{
+		// Preflights match the service by the method they ask for
+		"POST":   http.StatusNoContent,
+		"DELETE": http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "http://localhost/api/orders", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", requested)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("Expected status code %d for a %s preflight, got %d", expected, requested, w.Code)
+		}
+	}
+}
+
+func TestGatewayIPFilter(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "internal",
+		Path:            "/internal",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		IPFilter:        &config.IPFilterConfig{Allow: []string{"10.0.0.0/8"}},
+	}
+	bs.Init()
+	bs.GetHttpClient().Transport = &mockHTTPClient{
+		mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+	}
+
+	reg := newTestRegistry(t, bs)
+
+	resolver, _ := ipfilter.NewResolver([]string{"192.168.0.1"})
+	filter, _ := ipfilter.NewFilter(&config.IPFilterConfig{Deny: []string{"10.6.6.6"}})
+	handler := newTestGateway(t, reg, nil, WithIPFilter(filter), WithClientIPResolver(resolver))
+
+	testCases := []struct {
+		name               string
+		remoteAddr         string
+		forwardedFor       string
+		expectedStatusCode int
+	}{
+		{"Internal client", "10.1.1.1:5000", "", http.StatusOK},
+		{"External client", "203.0.113.7:5000", "", http.StatusForbidden},
+		{"Internal client behind trusted proxy", "192.168.0.1:5000", "10.1.1.1", http.StatusOK},
+		{"Spoofed forwarded header from untrusted peer", "203.0.113.7:5000", "10.1.1.1", http.StatusForbidden},
+		{"Globally denied client", "10.6.6.6:5000", "", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "http://localhost/internal/status", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatusCode {
+			t.Errorf("[%s] Expected status code %d, got %d", tc.name, tc.expectedStatusCode, w.Code)
+		}
+	}
+
+	// Global rules can be replaced at runtime
+	filter.Update(config.IPFilterConfig{Deny: []string{"10.1.1.1"}})
+	req := httptest.NewRequest("GET", "http://localhost/internal/status", nil)
+	req.RemoteAddr = "10.1.1.1:5000"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected updated global rules to deny the client, got %d", w.Code)
+	}
+}
+
+func TestGatewayInvalidServiceSettings(t *testing.T) {
+	// Stored services are initialised without validation, invalid settings close the service
+	testCases := []struct {
+		name    string
+		service *service.BackendService
+	}{
+		{"IP filter", &service.BackendService{IPFilter: &config.IPFilterConfig{Allow: []string{"10.0.0.0/8", "bogus"}}}},
+		{"Header rules", &service.BackendService{Headers: &service.HeaderRules{Request: []service.HeaderRule{{Action: "replace", Name: "X-User"}}}}},
+		{"Request limits", &service.BackendService{Limits: &config.LimitsConfig{MaxBodySize: -1}}},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bs := tc.service
+			bs.Name = fmt.Sprintf("invalid-%d", i)
+			bs.Path = "/" + bs.Name
+			bs.UpstreamTargets = []string{"http://localhost:8000"}
+			bs.Init()
+			bs.GetHttpClient().Transport = &mockHTTPClient{
+				mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+			}
+			handler := newTestGateway(t, newTestRegistry(t, bs), nil)
+
+			req := httptest.NewRequest("GET", "http://localhost/"+bs.Name, nil)
+			req.RemoteAddr = "8.8.8.8:5000"
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+		})
+	}
+}
+
+func TestGatewayHMACAuth(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "partners",
+		Path:            "/partners",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		AuthConfig:      &config.AuthConfig{AuthType: "hmac"},
+	}
+	bs.Init()
+	bs.GetHttpClient().Transport = &mockHTTPClient{
+		mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+	}
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	sign := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://localhost/partners/orders", nil)
+		if err := auth.SignRequest(req, "acme", "s3cr3t", auth.HMACSHA256, []string{"@method", "@authority", "@path", "@query"}); err != nil {
+			t.Fatalf("could not sign request: %s", err)
+		}
+		return req
+	}
+
+	// The key is unknown until its secret is added to the registry
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, sign())
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for unknown key, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	reg.GetSecrets().SetSecret("acme", "s3cr3t")
+	signed := sign()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signed)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d for signed request, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Updating the service rebuilds its validator but does not forget the signatures it saw
+	updated := &service.BackendService{
+		Name:            "partners",
+		Path:            "/partners",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		AuthConfig:      &config.AuthConfig{AuthType: "hmac"},
+	}
+	updated.Init()
+	if err := reg.UpdateService(updated); err != nil {
+		t.Fatalf("could not update service: %s", err)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signed)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for replayed request, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
 func TestFindBackendService(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -393,6 +786,1606 @@ func TestFindBackendService(t *testing.T) {
 	}
 }
 
+func TestFindBackendServiceMatchConditions(t *testing.T) {
+	present := true
+	absent := false
+	services := []*service.BackendService{
+		{Name: "api-v1", Path: "/api"},
+		{Name: "api-v2", Path: "/api", Match: &service.MatchConditions{
+			Headers: []service.ValueMatcher{{Name: "x-api-version", Exact: "2"}},
+		}},
+		{Name: "api-beta", Path: "/api", Match: &service.MatchConditions{
+			Query:   []service.ValueMatcher{{Name: "channel", Regex: "^beta-[0-9]+$"}},
+			Cookies: []service.ValueMatcher{{Name: "session", Present: &present}},
+		}},
+		{Name: "orders-write", Path: "/api/orders", Match: &service.MatchConditions{
+			Methods: []string{"post", "put"},
+			Headers: []service.ValueMatcher{{Name: "X-Read-Only", Present: &absent}},
+		}},
+		{Name: "deep", Path: "/api/v1/reports"},
+	}
+
+	reg := newTestRegistry(t, services...)
+
+	testCases := []struct {
+		name     string
+		method   string
+		url      string
+		header   map[string]string
+		cookie   *http.Cookie
+		expected string
+	}{
+		{"No conditions", "GET", "http://localhost/api/orders", nil, nil, "api-v1"},
+		{"Header equals", "GET", "http://localhost/api/orders", map[string]string{"X-Api-Version": "2"}, nil, "api-v2"},
+		{"Header mismatch", "GET", "http://localhost/api/orders", map[string]string{"X-Api-Version": "3"}, nil, "api-v1"},
+		{"Query regex and cookie present", "GET", "http://localhost/api/orders?channel=beta-7", nil, &http.Cookie{Name: "session", Value: "abc"}, "api-beta"},
+		{"Query regex without cookie", "GET", "http://localhost/api/orders?channel=beta-7", nil, nil, "api-v1"},
+		{"Method on longer prefix", "POST", "http://localhost/api/orders/1", nil, nil, "orders-write"},
+		{"Header must be absent", "POST", "http://localhost/api/orders/1", map[string]string{"X-Read-Only": "1"}, nil, "api-v1"},
+		{"Method mismatch backs off to shorter prefix", "GET", "http://localhost/api/orders/1", nil, nil, "api-v1"},
+		{"Unmatched deeper path backs off", "GET", "http://localhost/api/v1/other", nil, nil, "api-v1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+
+			result := reg.GetTrie().FindBackendService(req)
+			if result == nil || result.Name != tc.expected {
+				t.Errorf("Expected service %s, got %#v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestFindBackendServicePathParams(t *testing.T) {
+	services := []*service.BackendService{
+		{Name: "users", Path: "/users/:id"},
+		{Name: "me", Path: "/users/me"},
+		{Name: "user-orders", Path: "/users/:id/orders/:orderId"},
+		{Name: "files", Path: "/files/*filepath"},
+		{Name: "files-readme", Path: "/files/README"},
+		{Name: "tenant", Domain: "example.com", Path: "/:tenant/dashboard"},
+	}
+
+	reg := newTestRegistry(t, services...)
+
+	testCases := []struct {
+		name        string
+		url         string
+		expected    string
+		params      service.Params
+		matchedPath string
+	}{
+		{"Literal beats parameter", "http://localhost/users/me", "me", service.Params{}, "/users/me"},
+		{"Parameter", "http://localhost/users/42", "users", service.Params{"id": "42"}, "/users/42"},
+		{"Parameter prefix", "http://localhost/users/42/profile", "users", service.Params{"id": "42"}, "/users/42"},
+		{"Backtracks from literal to parameter", "http://localhost/users/me/orders/7", "user-orders", service.Params{"id": "me", "orderId": "7"}, "/users/me/orders/7"},
+		{"Literal beats catch-all", "http://localhost/files/README", "files-readme", service.Params{}, "/files/README"},
+		{"Catch-all", "http://localhost/files/css/site.css", "files", service.Params{"filepath": "css/site.css"}, "/files"},
+		{"Empty catch-all", "http://localhost/files", "files", service.Params{"filepath": ""}, "/files"},
+		{"Domain parameter", "http://example.com/acme/dashboard", "tenant", service.Params{"tenant": "acme"}, "/acme/dashboard"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			match := reg.GetTrie().Lookup(req)
+			if match == nil || match.Service.Name != tc.expected {
+				t.Fatalf("Expected service %s, got %#v", tc.expected, match)
+			}
+			if !reflect.DeepEqual(match.Params, tc.params) {
+				t.Errorf("Expected params %v, got %v", tc.params, match.Params)
+			}
+			if match.MatchedPath != tc.matchedPath {
+				t.Errorf("Expected matched path %s, got %s", tc.matchedPath, match.MatchedPath)
+			}
+		})
+	}
+
+	if match := reg.GetTrie().Lookup(httptest.NewRequest("GET", "http://localhost/users", nil)); match != nil {
+		t.Errorf("Expected no match for a missing parameter, got %s", match.Service.Name)
+	}
+}
+
+func TestFindBackendServiceHostPatterns(t *testing.T) {
+	services := []*service.BackendService{
+		{Name: "catch-all", Path: "/"},
+		{Name: "wildcard", Domain: "*.example.com", Path: "/"},
+		{Name: "tenant", Domain: ":tenant.apps.example.com", Path: "/"},
+		{Name: "admin", Domain: "admin.apps.example.com", Path: "/"},
+		{Name: "regional", Domain: `~^(?P<tenant>[a-z]+)-(?P<region>eu|us)\.example\.net$`, Path: "/"},
+		{Name: "tenant-api", Domain: "*.example.org", Path: "/api"},
+	}
+
+	reg := newTestRegistry(t, services...)
+
+	testCases := []struct {
+		name     string
+		host     string
+		path     string
+		expected string
+		params   service.Params
+	}{
+		{"Exact host beats patterns", "admin.apps.example.com", "/", "admin", service.Params{}},
+		{"Label capture beats wildcard", "acme.apps.example.com", "/", "tenant", service.Params{"tenant": "acme"}},
+		{"Wildcard", "shop.example.com", "/", "wildcard", service.Params{}},
+		{"Wildcard spans labels", "a.b.example.com", "/", "wildcard", service.Params{}},
+		{"Wildcard requires a subdomain", "example.com", "/", "catch-all", service.Params{}},
+		{"Case and trailing dot", "Shop.EXAMPLE.com.:8080", "/", "wildcard", service.Params{}},
+		{"Regex captures", "acme-eu.example.net", "/", "regional", service.Params{"tenant": "acme", "region": "eu"}},
+		{"Regex mismatch", "acme-ap.example.net", "/", "catch-all", service.Params{}},
+		{"Pattern without matching path falls back", "shop.example.org", "/other", "catch-all", service.Params{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://localhost"+tc.path, nil)
+			req.Host = tc.host
+			match := reg.GetTrie().Lookup(req)
+			if match == nil || match.Service.Name != tc.expected {
+				t.Fatalf("Expected service %s, got %#v", tc.expected, match)
+			}
+			if !reflect.DeepEqual(match.Params, tc.params) {
+				t.Errorf("Expected params %v, got %v", tc.params, match.Params)
+			}
+		})
+	}
+
+	if err := reg.AddService(&service.BackendService{Name: "invalid", Domain: "~(", Path: "/"}); err == nil {
+		t.Errorf("Expected an invalid host pattern to be rejected")
+	}
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "orders",
+		UpstreamTargets: []string{"http://localhost:8000"},
+	}
+	bs.Init()
+
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+		},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	reg := newTestRegistry(t, bs)
+
+	routes := []*service.Route{
+		{Name: "orders", Service: "orders", Paths: []string{"/orders"}},
+		{Name: "orders-v1", Service: "orders", Paths: []string{"/v1/orders"}, StripPath: true, RewriteMatch: "^(.*)$", RewriteReplace: "/orders$1"},
+		{Name: "orders-partner", Service: "orders", Hosts: []string{"partner.example.com"}, Paths: []string{"/"}, Match: &service.MatchConditions{Methods: []string{"GET"}}},
+	}
+	for _, r := range routes {
+		r.Init()
+		if err := reg.AddRoute(r); err != nil {
+			t.Fatalf("Unexpected error adding route %s: %s", r.Name, err)
+		}
+	}
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		name        string
+		method      string
+		url         string
+		statusCode  int
+		upstreamURL string
+	}{
+		{"Route", "GET", "http://localhost/orders/1", http.StatusOK, "http://localhost:8000/orders/1"},
+		{"Route with rewrite", "GET", "http://localhost/v1/orders/1", http.StatusOK, "http://localhost:8000/orders/1"},
+		{"Route with host", "GET", "http://partner.example.com/orders/1", http.StatusOK, "http://localhost:8000/orders/1"},
+		{"Route match conditions", "POST", "http://partner.example.com/invoices", http.StatusNotFound, ""},
+		{"Service without path is not routed", "GET", "http://localhost/", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient.requestURL = ""
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("Expected status code %d, got %d", tc.statusCode, w.Code)
+			}
+			if mockClient.requestURL != tc.upstreamURL {
+				t.Errorf("Expected upstream URL %q, got %q", tc.upstreamURL, mockClient.requestURL)
+			}
+		})
+	}
+
+	if err := reg.RemoveService("orders"); !errors.As(err, &service.ErrServiceInUse{}) {
+		t.Errorf("Expected removing a service referenced by routes to fail, got %v", err)
+	}
+	if err := reg.AddRoute(&service.Route{Name: "missing", Service: "missing", Paths: []string{"/missing"}}); !errors.As(err, &service.ErrServiceNotFound{}) {
+		t.Errorf("Expected a route to an unknown service to fail, got %v", err)
+	}
+	if err := reg.AddRoute(&service.Route{Name: "orders", Service: "orders", Paths: []string{"/other"}}); !errors.As(err, &service.ErrRouteExists{}) {
+		t.Errorf("Expected a duplicate route name to fail, got %v", err)
+	}
+
+	if err := reg.RemoveRoute("orders-v1"); err != nil {
+		t.Fatalf("Unexpected error removing route: %s", err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/v1/orders/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected removed route to stop matching, got status %d", w.Code)
+	}
+}
+
+func TestFindBackendServicePriority(t *testing.T) {
+	services := []*service.BackendService{
+		{Name: "domain", Domain: "example.com", Path: "/"},
+		{Name: "deep", Path: "/api/orders"},
+		{Name: "maintenance", Path: "/api", Priority: 10, Match: &service.MatchConditions{
+			Headers: []service.ValueMatcher{{Name: "X-Maintenance", Exact: "on"}},
+		}},
+		{Name: "first", Path: "/reports"},
+		{Name: "second", Path: "/reports", Priority: 1},
+	}
+
+	reg := newTestRegistry(t, services...)
+
+	testCases := []struct {
+		name     string
+		url      string
+		header   map[string]string
+		expected string
+	}{
+		{"Domain without priorities", "http://example.com/api/orders", nil, "domain"},
+		{"Longest path without priorities", "http://localhost/api/orders", nil, "deep"},
+		{"Priority beats domain and path", "http://example.com/api/orders", map[string]string{"X-Maintenance": "on"}, "maintenance"},
+		{"Priority beats registration order", "http://localhost/reports", nil, "second"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+
+			result := reg.GetTrie().FindBackendService(req)
+			if result == nil || result.Name != tc.expected {
+				t.Errorf("Expected service %s, got %#v", tc.expected, result)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/api/orders", nil)
+	candidates := reg.GetTrie().Explain(req)
+	var order []string
+	for _, c := range candidates {
+		order = append(order, c.Route)
+	}
+	if expected := []string{"maintenance", "domain", "deep"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Expected candidates %v, got %v", expected, order)
+	}
+	if candidates[0].Selected || candidates[0].Reason != "header X-Maintenance does not match" {
+		t.Errorf("Unexpected explanation for unmatched route: %+v", candidates[0])
+	}
+	if !candidates[1].Selected || candidates[2].Selected {
+		t.Errorf("Expected only the domain route to be selected: %+v", candidates)
+	}
+}
+
+func TestFindBackendServiceTrafficSplit(t *testing.T) {
+	reg := newTestRegistry(t)
+	for _, name := range []string{"orders-v1", "orders-v2", "orders-next"} {
+		reg.AddService(&service.BackendService{Name: name})
+	}
+
+	route := &service.Route{
+		Name:  "orders",
+		Paths: []string{"/orders"},
+		Split: &service.TrafficSplit{
+			Backends: []service.WeightedBackend{
+				{Service: "orders-v1", Weight: 90},
+				{Service: "orders-v2", Weight: 10},
+				{Service: "orders-next", Weight: 0},
+			},
+			PinHeader: "X-Canary",
+			PinCookie: "canary",
+			HashOn:    "header:X-User-Id",
+		},
+	}
+	if err := reg.AddRoute(route); err != nil {
+		t.Fatalf("Unexpected error adding route: %s", err)
+	}
+
+	lookup := func(header map[string]string, cookie *http.Cookie) string {
+		req := httptest.NewRequest("GET", "http://localhost/orders", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return reg.GetTrie().FindBackendService(req).Name
+	}
+
+	if name := lookup(map[string]string{"X-Canary": "orders-next"}, nil); name != "orders-next" {
+		t.Errorf("Expected header to pin orders-next, got %s", name)
+	}
+	if name := lookup(nil, &http.Cookie{Name: "canary", Value: "orders-v2"}); name != "orders-v2" {
+		t.Errorf("Expected cookie to pin orders-v2, got %s", name)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		user := map[string]string{"X-User-Id": fmt.Sprintf("user-%d", i)}
+		name := lookup(user, nil)
+		for j := 0; j < 3; j++ {
+			if again := lookup(user, nil); again != name {
+				t.Fatalf("Expected user-%d to stick to %s, got %s", i, name, again)
+			}
+		}
+		counts[name]++
+	}
+	if counts["orders-next"] != 0 {
+		t.Errorf("Expected a backend with weight 0 to receive no unpinned traffic, got %d requests", counts["orders-next"])
+	}
+	if counts["orders-v2"] < 100 || counts["orders-v2"] > 300 {
+		t.Errorf("Expected roughly 10%% of clients on orders-v2, got %v", counts)
+	}
+
+	if err := reg.RemoveService("orders-v2"); !errors.As(err, &service.ErrServiceInUse{}) {
+		t.Errorf("Expected removing a service of a traffic split to fail, got %v", err)
+	}
+}
+
+func TestAddServiceRouteConflicts(t *testing.T) {
+	reg := newTestRegistry(t,
+		&service.BackendService{Name: "users", Path: "/users/:id"},
+		&service.BackendService{Name: "static", Path: "/static/*filepath"},
+	)
+
+	testCases := []struct {
+		name string
+		path string
+	}{
+		{"Parameter name mismatch", "/users/:userId/orders"},
+		{"Catch-all name mismatch", "/static/*path"},
+		{"Catch-all not last", "/assets/*filepath/raw"},
+		{"Unnamed parameter", "/orders/:"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := reg.AddService(&service.BackendService{Name: "conflicting", Path: tc.path})
+			var conflict service.ErrRouteConflict
+			if !errors.As(err, &conflict) {
+				t.Fatalf("Expected route conflict, got %v", err)
+			}
+			if len(reg.GetServices()) != 2 {
+				t.Errorf("Expected the conflicting service not to be added")
+			}
+		})
+	}
+
+	if err := reg.UpdateService(&service.BackendService{Name: "users", Path: "/users/:userId"}); err != nil {
+		t.Errorf("Expected renaming the parameter of the only route to succeed, got %s", err)
+	}
+}
+
+func TestGatewayPathParams(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "orders",
+		Path:            "/users/:id/orders",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		StripPath:       true,
+		Domain:          ":tenant.example.com",
+		RewriteMatch:    "^(.*)$",
+		RewriteReplace:  "/{tenant}/customers/{id}/orders$1",
+	}
+	bs.Init()
+
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+		},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	reg := newTestRegistry(t, bs)
+
+	var pluginParams service.Params
+	plugin := &testPlugin{
+		postResponse: func(resp *http.Response, _ service.ServiceRegistry, _ *config.Config) plugins.PluginError {
+			pluginParams = service.PathParams(resp.Request)
+			return nil
+		},
+	}
+
+	handler := newTestGateway(t, reg, []plugins.FrontmanPlugin{plugin})
+
+	req := httptest.NewRequest("GET", "http://acme.example.com/users/42/orders/7", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if expected := "http://localhost:8000/acme/customers/42/orders/7"; mockClient.requestURL != expected {
+		t.Errorf("Expected upstream URL %s, got %s", expected, mockClient.requestURL)
+	}
+	if pluginParams["id"] != "42" {
+		t.Errorf("Expected plugins to see the path parameters, got %v", pluginParams)
+	}
+}
+
+func TestGatewayMirror(t *testing.T) {
+	primaryBodies := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		primaryBodies <- r.Header.Get("Authorization") + " " + string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	mirrorBodies := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrorBodies <- r.Header.Get("Authorization") + r.URL.RequestURI() + " " + string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer target.Close()
+
+	bs := &service.BackendService{
+		Name:            "orders",
+		Path:            "/orders",
+		UpstreamTargets: []string{upstream.URL},
+		Mirror:          &config.MirrorConfig{Target: target.URL, Percentage: 100},
+		UpstreamAuth:    &config.UpstreamAuthConfig{AuthType: "bearer", Token: "upstream-token"},
+		Rewrite:         &service.URLRewrite{Query: &service.QueryRewrite{Remove: []string{"debug"}}},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	req := httptest.NewRequest("POST", "http://localhost/orders/7?debug=1", strings.NewReader(`{"qty":2}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if body := <-primaryBodies; body != `Bearer upstream-token {"qty":2}` {
+		t.Errorf("Expected the upstream to receive the credentials and the full body, got %s", body)
+	}
+
+	select {
+	case mirrored := <-mirrorBodies:
+		if expected := `/orders/7 {"qty":2}`; mirrored != expected {
+			t.Errorf("Expected mirrored request %s without credentials or the removed query, got %s", expected, mirrored)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the request to be mirrored")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bs.GetMirror().Stats().Matched != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected matching status codes to be counted, got %+v", bs.GetMirror().Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGatewayDirectResponses(t *testing.T) {
+	moved := &service.BackendService{
+		Name:     "moved",
+		Type:     service.ServiceTypeRedirect,
+		Path:     "/users/:id/profile",
+		Redirect: &config.RedirectConfig{StatusCode: http.StatusMovedPermanently, Scheme: "https", Host: "accounts.example.com", Path: "/profiles/{id}"},
+	}
+	legacy := &service.BackendService{
+		Name:           "legacy",
+		Type:           service.ServiceTypeRedirect,
+		Path:           "/v1",
+		RewriteMatch:   "^/v1",
+		RewriteReplace: "/v2",
+		Redirect:       &config.RedirectConfig{StatusCode: http.StatusPermanentRedirect},
+	}
+	maintenance := &service.BackendService{
+		Name:     "maintenance",
+		Type:     service.ServiceTypeResponse,
+		Path:     "/shop",
+		Response: &config.StaticResponseConfig{StatusCode: http.StatusServiceUnavailable, Headers: map[string]string{"Retry-After": "60"}, Body: "Back soon"},
+	}
+
+	for _, bs := range []*service.BackendService{moved, legacy, maintenance} {
+		bs.Init()
+	}
+	reg := newTestRegistry(t, moved, legacy, maintenance)
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedBody       string
+	}{
+		{"Redirect with parameters", "GET", "http://localhost/users/42/profile?tab=1", http.StatusMovedPermanently, "https://accounts.example.com/profiles/42?tab=1", ""},
+		{"Redirect with rewrite", "POST", "http://localhost/v1/orders", http.StatusPermanentRedirect, "http://localhost/v2/orders", ""},
+		{"Static response", "GET", "http://localhost/shop/cart", http.StatusServiceUnavailable, "", "Back soon"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatusCode {
+			t.Errorf("[%s] Expected status code %d, got %d", tc.name, tc.expectedStatusCode, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != tc.expectedLocation {
+			t.Errorf("[%s] Expected location %s, got %s", tc.name, tc.expectedLocation, location)
+		}
+		if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+			t.Errorf("[%s] Expected body %s, got %s", tc.name, tc.expectedBody, w.Body.String())
+		}
+	}
+}
+
+func TestGatewayDirectResponsesAuth(t *testing.T) {
+	basicAuth := &config.AuthConfig{
+		AuthType:        "basic",
+		BasicAuthConfig: &config.BasicAuthConfig{Username: "test", Password: "test"},
+	}
+	moved := &service.BackendService{
+		Name:       "moved",
+		Type:       service.ServiceTypeRedirect,
+		Path:       "/moved",
+		AuthConfig: basicAuth,
+		Redirect:   &config.RedirectConfig{StatusCode: http.StatusFound, Host: "internal.example.com"},
+	}
+	report := &service.BackendService{
+		Name:       "report",
+		Type:       service.ServiceTypeResponse,
+		Path:       "/report",
+		AuthConfig: basicAuth,
+		Response:   &config.StaticResponseConfig{StatusCode: http.StatusOK, Body: "confidential"},
+	}
+
+	for _, bs := range []*service.BackendService{moved, report} {
+		bs.Init()
+	}
+	reg := newTestRegistry(t, moved, report)
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		name               string
+		url                string
+		authenticated      bool
+		expectedStatusCode int
+	}{
+		{"Redirect without credentials", "http://localhost/moved", false, http.StatusUnauthorized},
+		{"Redirect with credentials", "http://localhost/moved", true, http.StatusFound},
+		{"Static response without credentials", "http://localhost/report", false, http.StatusUnauthorized},
+		{"Static response with credentials", "http://localhost/report", true, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		if tc.authenticated {
+			req.SetBasicAuth("test", "test")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatusCode {
+			t.Errorf("[%s] Expected status code %d, got %d", tc.name, tc.expectedStatusCode, w.Code)
+		}
+		if !tc.authenticated && strings.Contains(w.Body.String(), "confidential") {
+			t.Errorf("[%s] Expected the response to be withheld, got %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestGatewayStaticFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("<html>app</html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	frontend := &service.BackendService{
+		Name:      "frontend",
+		Type:      service.ServiceTypeStatic,
+		Path:      "/app",
+		StripPath: true,
+		Static:    &config.StaticConfig{Root: root, SPAFallback: true},
+	}
+	api := &service.BackendService{
+		Name:            "api",
+		Path:            "/app/api",
+		UpstreamTargets: []string{"http://localhost:8000"},
+	}
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+	}
+
+	frontend.Init()
+	api.Init()
+	reg := newTestRegistry(t, frontend, api)
+	api.GetHttpClient().Transport = mockClient
+
+	handler := newTestGateway(t, reg, nil)
+
+	req := httptest.NewRequest("GET", "http://localhost/app/settings/profile", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "<html>app</html>" {
+		t.Errorf("Expected the application to be served, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "http://localhost/app/api/users", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if expected := "http://localhost:8000/app/api/users"; mockClient.requestURL != expected {
+		t.Errorf("Expected API requests to be proxied to %s, got %s", expected, mockClient.requestURL)
+	}
+}
+
+func TestGatewayStaticFilesAuth(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "report.txt"), []byte("confidential"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := &service.BackendService{
+		Name:      "reports",
+		Type:      service.ServiceTypeStatic,
+		Path:      "/reports",
+		StripPath: true,
+		Static:    &config.StaticConfig{Root: root},
+		AuthConfig: &config.AuthConfig{
+			AuthType:        "basic",
+			BasicAuthConfig: &config.BasicAuthConfig{Username: "test", Password: "test"},
+		},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	req := httptest.NewRequest("GET", "http://localhost/reports/report.txt", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "confidential") {
+		t.Errorf("Expected unauthenticated requests to be rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "http://localhost/reports/report.txt", nil)
+	req.SetBasicAuth("test", "test")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "confidential" {
+		t.Errorf("Expected the file to be served, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGatewayRewrite(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "items",
+		Domain:          ":tenant.example.com",
+		Path:            "/items/:id",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		Rewrite: &service.URLRewrite{
+			Path: "/v2/{tenant}/items/{id}",
+			Host: "{header.X-Region}.items.internal",
+			Query: &service.QueryRewrite{
+				Add:    map[string]string{"tenant": "{tenant}"},
+				Remove: []string{"debug"},
+				Rename: map[string]string{"q": "search"},
+			},
+		},
+	}
+	regex := &service.BackendService{
+		Name:            "legacy",
+		Path:            "/legacy",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		Rewrite:         &service.URLRewrite{Match: "^/legacy/(\\w+)$", Path: "/modern/$1/{header.X-Version}"},
+	}
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody},
+	}
+
+	for _, s := range []*service.BackendService{bs, regex} {
+		s.Init()
+		s.GetHttpClient().Transport = mockClient
+	}
+	reg := newTestRegistry(t, bs, regex)
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		name         string
+		url          string
+		header       map[string]string
+		expectedURL  string
+		expectedHost string
+	}{
+		{
+			"Template rewrite",
+			"http://acme.example.com/items/42?q=shoes&debug=1",
+			map[string]string{"X-Region": "eu"},
+			"http://localhost:8000/v2/acme/items/42?search=shoes&tenant=acme",
+			"eu.items.internal",
+		},
+		{
+			"Regex rewrite",
+			"http://localhost/legacy/orders?page=2",
+			map[string]string{"X-Version": "$2"},
+			"http://localhost:8000/modern/orders/$2?page=2",
+			"localhost:8000",
+		},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("[%s] Expected status code %d, got %d", tc.name, http.StatusOK, w.Code)
+		}
+		if mockClient.requestURL != tc.expectedURL {
+			t.Errorf("[%s] Expected upstream URL %s, got %s", tc.name, tc.expectedURL, mockClient.requestURL)
+		}
+		if mockClient.requestHost != tc.expectedHost {
+			t.Errorf("[%s] Expected upstream host %s, got %s", tc.name, tc.expectedHost, mockClient.requestHost)
+		}
+	}
+}
+
+func TestGatewayHeaderRules(t *testing.T) {
+	t.Setenv("FRONTMAN_TEST_REGION", "eu-west")
+	service.SetTemplateEnv([]string{"FRONTMAN_TEST_REGION"})
+	t.Cleanup(func() { service.SetTemplateEnv(nil) })
+
+	bs := &service.BackendService{
+		Name:            "users",
+		Path:            "/users/:id",
+		UpstreamTargets: []string{"http://localhost:8000"},
+		Headers: &service.HeaderRules{
+			Request: []service.HeaderRule{
+				{Action: service.HeaderActionSet, Name: "X-User-Id", Value: "{id}"},
+				{Action: service.HeaderActionSet, Name: "X-Forwarded-For", Value: "{client_ip}"},
+				{Action: service.HeaderActionSet, Name: "X-Request-Id", Value: "{request_id}"},
+				{Action: service.HeaderActionAppend, Name: "X-Tags", Value: "region={env.FRONTMAN_TEST_REGION}"},
+				{Action: service.HeaderActionRemove, Name: "Cookie"},
+				{Action: service.HeaderActionRename, Name: "X-Legacy-Token", To: "X-Token"},
+			},
+			Response: []service.HeaderRule{
+				{Action: service.HeaderActionRemove, Name: "Server"},
+				{Action: service.HeaderActionSet, Name: "X-Request-Id", Value: "{request_id}"},
+			},
+		},
+	}
+	bs.Init()
+
+	mockClient := &mockHTTPClient{
+		mockResponse: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Server": []string{"upstream/1.0"}},
+			Body:       http.NoBody,
+		},
+	}
+	bs.GetHttpClient().Transport = mockClient
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	req := httptest.NewRequest("GET", "http://localhost/users/42", nil)
+	req.RemoteAddr = "10.1.1.1:5000"
+	req.Header.Set("X-Request-Id", "abc123")
+	req.Header.Set("X-Tags", "web")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Legacy-Token", "t0k3n")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	expected := http.Header{
+		"X-User-Id":       []string{"42"},
+		"X-Forwarded-For": []string{"10.1.1.1"},
+		"X-Request-Id":    []string{"abc123"},
+		"X-Tags":          []string{"web", "region=eu-west"},
+		"X-Token":         []string{"t0k3n"},
+	}
+	for name, values := range expected {
+		if got := mockClient.requestHeader.Values(name); !reflect.DeepEqual(got, values) {
+			t.Errorf("Expected upstream header %s to be %v, got %v", name, values, got)
+		}
+	}
+	for _, name := range []string{"Cookie", "X-Legacy-Token"} {
+		if got := mockClient.requestHeader.Get(name); got != "" {
+			t.Errorf("Expected upstream header %s to be removed, got %s", name, got)
+		}
+	}
+
+	if got := w.Header().Get("Server"); got != "" {
+		t.Errorf("Expected the Server header to be removed, got %s", got)
+	}
+	if got := w.Header().Get("X-Request-Id"); got != "abc123" {
+		t.Errorf("Expected the request ID in the response, got %s", got)
+	}
+}
+
+func TestGatewayBodyTransform(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Expected upstream Content-Length %d, got %d", len(body), r.ContentLength)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":{"received":%s,"internal":true}}`, body)
+	}))
+	defer upstream.Close()
+
+	bs := &service.BackendService{
+		Name:            "legacy",
+		Path:            "/legacy",
+		UpstreamTargets: []string{upstream.URL},
+		BodyTransform: &config.BodyTransformConfig{
+			Request:  &config.JSONTransformConfig{Rename: map[string]string{"name": "customer_name"}, Add: map[string]interface{}{"source": "gateway"}},
+			Response: &config.JSONTransformConfig{Extract: "data", Remove: []string{"data.internal"}},
+		},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	req := httptest.NewRequest("POST", "http://localhost/legacy/customers", strings.NewReader(`{"name":"Ada"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	expected := `{"received":{"customer_name":"Ada","source":"gateway"}}`
+	if w.Body.String() != expected {
+		t.Errorf("Expected body %s, got %s", expected, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(expected)) {
+		t.Errorf("Expected Content-Length %d, got %s", len(expected), got)
+	}
+}
+
+func TestGatewayCache(t *testing.T) {
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "%s %d", r.Method, requests)
+	}))
+	defer upstream.Close()
+
+	bs := &service.BackendService{
+		Name:            "catalog",
+		Path:            "/catalog",
+		StripPath:       true,
+		UpstreamTargets: []string{upstream.URL},
+		Cache:           &config.CacheConfig{},
+		Headers: &service.HeaderRules{
+			Response: []service.HeaderRule{{Action: service.HeaderActionSet, Name: "X-Service", Value: "catalog"}},
+		},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil, WithCache(cache.New(cache.NewMemoryStore(0))))
+
+	testCases := []struct {
+		method       string
+		expectedBody string
+		expectedHit  string
+	}{
+		{"GET", "GET 1", "MISS"},
+		{"GET", "GET 1", "HIT"},
+		{"POST", "POST 2", ""},
+		{"GET", "GET 1", "HIT"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, "http://localhost/catalog/items", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Body.String() != tc.expectedBody {
+			t.Errorf("Expected body %s, got %s", tc.expectedBody, w.Body.String())
+		}
+		if got := w.Header().Get(cache.StatusHeader); got != tc.expectedHit {
+			t.Errorf("Expected %s %s, got %s", cache.StatusHeader, tc.expectedHit, got)
+		}
+		// Header rules apply to cached responses too
+		if got := w.Header().Get("X-Service"); got != "catalog" {
+			t.Errorf("Expected X-Service catalog, got %s", got)
+		}
+	}
+}
+
+func TestGatewayCoalesce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte("popular"))
+	}))
+	defer upstream.Close()
+
+	bs := &service.BackendService{
+		Name:            "popular",
+		Path:            "/popular",
+		UpstreamTargets: []string{upstream.URL},
+		Coalesce:        &config.CoalesceConfig{},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	const n = 10
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, n)
+	for i := 0; i < n; i++ {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/popular/item", nil))
+		}(recorders[i])
+	}
+
+	// Give the requests time to join the upstream call before it completes
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", got)
+	}
+	for _, w := range recorders {
+		if w.Code != http.StatusOK || w.Body.String() != "popular" {
+			t.Errorf("Expected status 200 and body popular, got %d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGatewayCoalesceUpstreamAuth(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte(r.Header.Get("X-Client")))
+	}))
+	defer upstream.Close()
+
+	// The upstream sees the same credentials for every user, the client credentials keep the
+	// users apart
+	bs := &service.BackendService{
+		Name:            "profile",
+		Path:            "/profile",
+		UpstreamTargets: []string{upstream.URL},
+		Coalesce:        &config.CoalesceConfig{},
+		UpstreamAuth:    &config.UpstreamAuthConfig{AuthType: "bearer", Token: "upstream-token"},
+		Headers: &service.HeaderRules{
+			Request: []service.HeaderRule{{Action: service.HeaderActionSet, Name: "X-Client", Value: "{header.Authorization}"}},
+		},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	users := []string{"Bearer alice", "Bearer bob", "Bearer alice", "Bearer bob"}
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, len(users))
+	for i, user := range users {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder, user string) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "http://localhost/profile", nil)
+			req.Header.Set("Authorization", user)
+			handler.ServeHTTP(w, req)
+		}(recorders[i], user)
+	}
+
+	// Give the requests time to join the upstream calls before they complete
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("Expected 1 upstream request per user, got %d", got)
+	}
+	for i, w := range recorders {
+		if w.Code != http.StatusOK || w.Body.String() != users[i] {
+			t.Errorf("Expected status 200 and the response of %s, got %d %s", users[i], w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGatewayCompression(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	bs := &service.BackendService{
+		Name:            "text",
+		Path:            "/text",
+		UpstreamTargets: []string{upstream.URL},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	policy, err := compression.NewPolicy(&config.CompressionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newTestGateway(t, reg, nil, WithCompressionPolicy(policy))
+
+	req := httptest.NewRequest("GET", "http://localhost/text/doc", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, got %s", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary Accept-Encoding, got %s", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := io.ReadAll(zr)
+	if string(decoded) != body {
+		t.Errorf("Expected decompressed body to match the upstream body")
+	}
+
+	// Services can turn off the compression of the gateway
+	bs.Compression = &config.CompressionConfig{Disabled: true}
+	bs.Init()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Expected no Content-Encoding, got %s", got)
+	}
+}
+
+func TestGatewayLimits(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	uploads := &service.BackendService{
+		Name:            "uploads",
+		Path:            "/uploads",
+		UpstreamTargets: []string{upstream.URL},
+		Limits:          &config.LimitsConfig{MaxBodySize: 64},
+	}
+	uploads.Init()
+	api := &service.BackendService{
+		Name:            "api",
+		Path:            "/api",
+		UpstreamTargets: []string{upstream.URL},
+	}
+	api.Init()
+
+	reg := newTestRegistry(t, uploads, api)
+
+	global, err := limits.NewLimits(&config.LimitsConfig{MaxBodySize: 16, MaxURLLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newTestGateway(t, reg, nil, WithLimits(global))
+
+	testCases := []struct {
+		name          string
+		url           string
+		body          string
+		chunked       bool
+		expectedCode  int
+		expectedCalls int32
+	}{
+		{"Global body limit", "http://localhost/api/items", strings.Repeat("x", 32), false, http.StatusRequestEntityTooLarge, 0},
+		{"URL too long", "http://localhost/api/" + strings.Repeat("x", 32), "", false, http.StatusRequestURITooLong, 0},
+		{"Service body limit", "http://localhost/uploads/file", strings.Repeat("x", 32), false, http.StatusOK, 1},
+		{"Chunked body over the limit", "http://localhost/uploads/file", strings.Repeat("x", 128), true, http.StatusRequestEntityTooLarge, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			req := httptest.NewRequest("POST", tc.url, strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d, got %d", tc.expectedCode, w.Code)
+			}
+			if got := atomic.LoadInt32(&requests); got > tc.expectedCalls {
+				t.Errorf("Expected at most %d upstream requests, got %d", tc.expectedCalls, got)
+			}
+		})
+	}
+}
+
+func TestGatewayOpenAPIValidation(t *testing.T) {
+	var received string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	spec := `
+openapi: 3.0.3
+info:
+  title: Orders
+  version: "1.0"
+paths:
+  /orders:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [item]
+              properties:
+                item:
+                  type: string
+                quantity:
+                  type: integer
+                  minimum: 1
+      responses:
+        "201":
+          description: Created
+`
+	specPath := filepath.Join(t.TempDir(), "orders.yaml")
+	if err := os.WriteFile(specPath, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	orders := &service.BackendService{
+		Name:            "orders",
+		Path:            "/shop",
+		StripPath:       true,
+		UpstreamTargets: []string{upstream.URL},
+		OpenAPI:         &config.OpenAPIConfig{Spec: specPath, ValidateResponses: true},
+	}
+	orders.Init()
+
+	reg := newTestRegistry(t, orders)
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+		forwarded    bool
+	}{
+		{"Valid request", "POST", "http://localhost/shop/orders", `{"item":"book","quantity":2}`, http.StatusCreated, true},
+		{"Invalid body", "POST", "http://localhost/shop/orders", `{"quantity":0}`, http.StatusBadRequest, false},
+		{"Unknown operation", "GET", "http://localhost/shop/orders", "", http.StatusMethodNotAllowed, false},
+		{"Unknown path", "POST", "http://localhost/shop/carts", `{}`, http.StatusNotFound, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d, got %d", tc.expectedCode, w.Code)
+			}
+			if tc.forwarded && received != tc.body {
+				t.Errorf("Expected the upstream to receive %s, got %s", tc.body, received)
+			}
+			if !tc.forwarded {
+				if received != "" {
+					t.Errorf("Expected the request not to reach the upstream")
+				}
+				var validationErr struct {
+					Error   string `json:"error"`
+					Details []struct {
+						In     string `json:"in"`
+						Name   string `json:"name"`
+						Reason string `json:"reason"`
+					} `json:"details"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &validationErr); err != nil || validationErr.Error == "" {
+					t.Errorf("Expected a JSON error, got %s", w.Body.String())
+				}
+				if tc.expectedCode == http.StatusBadRequest && len(validationErr.Details) != 2 {
+					t.Errorf("Expected 2 violations, got %v", validationErr.Details)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayOpenAPIUnavailable(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer upstream.Close()
+
+	// The spec is missing when the service is initialised, it is not looked up again afterwards
+	specPath := filepath.Join(t.TempDir(), "orders.yaml")
+	orders := &service.BackendService{
+		Name:            "orders",
+		Path:            "/orders",
+		UpstreamTargets: []string{upstream.URL},
+		OpenAPI:         &config.OpenAPIConfig{Spec: specPath},
+	}
+	orders.Init()
+	if err := os.WriteFile(specPath, []byte("openapi: 3.0.3\ninfo:\n  title: Orders\n  version: \"1.0\"\npaths: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := newTestRegistry(t, orders)
+
+	handler := newTestGateway(t, reg, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/orders", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("Expected unchecked requests not to reach the upstream, got %d", got)
+	}
+}
+
+func TestGatewayUpstreamProtocol(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	// The upstream speaks cleartext HTTP/2 to clients with prior knowledge and HTTP/1.1 to others
+	upstream := httptest.NewServer(h2c.NewHandler(protoHandler, &http2.Server{}))
+	defer upstream.Close()
+
+	h2cService := &service.BackendService{
+		Name:            "h2c",
+		Path:            "/h2c",
+		UpstreamTargets: []string{upstream.URL},
+		Protocol:        service.ProtocolH2C,
+	}
+	h2cService.Init()
+	http1Service := &service.BackendService{
+		Name:            "http1",
+		Path:            "/http1",
+		UpstreamTargets: []string{upstream.URL},
+	}
+	http1Service.Init()
+
+	reg := newTestRegistry(t, h2cService, http1Service)
+
+	handler := newTestGateway(t, reg, nil)
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"http://localhost/h2c/proto", "HTTP/2.0"},
+		{"http://localhost/http1/proto", "HTTP/1.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+			if proto := w.Body.String(); proto != tc.expected {
+				t.Errorf("Expected the upstream to be reached with %s, got %s", tc.expected, proto)
+			}
+		})
+	}
+}
+
+func TestGatewayGRPC(t *testing.T) {
+	// A gRPC health server stands for the upstream, it has unary and server streaming methods
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	healthService := &service.BackendService{
+		Name:            "health",
+		Scheme:          service.SchemeGRPC,
+		Path:            "/grpc.health.v1.Health",
+		UpstreamTargets: []string{"http://" + listener.Addr().String()},
+	}
+	healthService.Init()
+	downService := &service.BackendService{
+		Name:            "down",
+		Scheme:          service.SchemeGRPC,
+		Path:            "/down.v1.Down",
+		UpstreamTargets: []string{"http://127.0.0.1:1"},
+	}
+	downService.Init()
+
+	reg := newTestRegistry(t, healthService, downService)
+
+	gateway := httptest.NewServer(h2c.NewHandler(newTestGateway(t, reg, nil), &http2.Server{}))
+	defer gateway.Close()
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(gateway.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Unary", func(t *testing.T) {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING, got %s", resp.Status)
+		}
+	})
+
+	t.Run("Upstream status", func(t *testing.T) {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+		if code := status.Code(err); code != codes.NotFound {
+			t.Errorf("Expected the NotFound status of the upstream, got %s", code)
+		}
+	})
+
+	t.Run("Server streaming", func(t *testing.T) {
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "orders"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		first, err := stream.Recv()
+		if err != nil || first.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("Expected SERVING, got %v %v", first, err)
+		}
+		// Updates reach the client while the call is still open
+		healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_NOT_SERVING)
+		second, err := stream.Recv()
+		if err != nil || second.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Fatalf("Expected NOT_SERVING, got %v %v", second, err)
+		}
+	})
+
+	t.Run("Gateway errors", func(t *testing.T) {
+		err := conn.Invoke(ctx, "/unknown.v1.Service/Method", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+		if code := status.Code(err); code != codes.Unimplemented {
+			t.Errorf("Expected Unimplemented for an unknown service, got %s", code)
+		}
+		err = conn.Invoke(ctx, "/down.v1.Down/Method", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+		if code := status.Code(err); code != codes.Unavailable {
+			t.Errorf("Expected Unavailable for an unreachable upstream, got %s", code)
+		}
+	})
+}
+
+func TestGatewayGRPCWeb(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	// Bind the Check method to a REST path for transcoding
+	file := protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)
+	for _, method := range file.Service[0].Method {
+		if method.GetName() == "Check" {
+			method.Options = &descriptorpb.MethodOptions{}
+			proto.SetExtension(method.Options, annotations.E_Http, &annotations.HttpRule{
+				Pattern: &annotations.HttpRule_Get{Get: "/v1/health/{service}"},
+			})
+		}
+	}
+	descriptorSet, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptorPath := filepath.Join(t.TempDir(), "health.pb")
+	if err := os.WriteFile(descriptorPath, descriptorSet, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	healthService := &service.BackendService{
+		Name:            "health",
+		Scheme:          service.SchemeGRPC,
+		Path:            "/",
+		UpstreamTargets: []string{"http://" + listener.Addr().String()},
+		GRPC:            &config.GRPCConfig{DescriptorSet: descriptorPath},
+	}
+	healthService.Init()
+	if healthService.GetTranscoder() == nil {
+		t.Fatal("Expected a transcoder for the descriptor set")
+	}
+
+	reg := newTestRegistry(t, healthService)
+	gateway := httptest.NewServer(newTestGateway(t, reg, nil))
+	defer gateway.Close()
+
+	webCall := func(request *healthpb.HealthCheckRequest) (*http.Response, []byte) {
+		message, err := proto.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := base64.StdEncoding.EncodeToString(append([]byte{0, 0, 0, 0, byte(len(message))}, message...))
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/grpc.health.v1.Health/Check", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/grpc-web-text")
+		req.Header.Set("X-Grpc-Web", "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		encoded, _ := io.ReadAll(resp.Body)
+		// Every chunk of the response is padded on its own, decode each group of four characters
+		var decoded []byte
+		for i := 0; i+4 <= len(encoded); i += 4 {
+			part, err := base64.StdEncoding.DecodeString(string(encoded[i : i+4]))
+			if err != nil {
+				t.Fatalf("Invalid base64 response %q: %v", encoded, err)
+			}
+			decoded = append(decoded, part...)
+		}
+		return resp, decoded
+	}
+
+	t.Run("gRPC-Web", func(t *testing.T) {
+		resp, body := webCall(&healthpb.HealthCheckRequest{Service: "orders"})
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc-web-text" {
+			t.Fatalf("Expected a gRPC-Web text response, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if len(body) < 5 {
+			t.Fatalf("Expected a message frame, got %q", body)
+		}
+		size := int(body[4])
+		var result healthpb.HealthCheckResponse
+		if err := proto.Unmarshal(body[5:5+size], &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING, got %s", result.Status)
+		}
+		trailers := body[5+size:]
+		if len(trailers) < 5 || trailers[0] != 0x80 || !strings.Contains(string(trailers[5:]), "grpc-status: 0") {
+			t.Errorf("Expected a trailer frame with an OK status, got %q", trailers)
+		}
+	})
+
+	t.Run("gRPC-Web status", func(t *testing.T) {
+		resp, _ := webCall(&healthpb.HealthCheckRequest{Service: "unknown"})
+		if status := resp.Header.Get("Grpc-Status"); status != "5" {
+			t.Errorf("Expected the NotFound status of the upstream, got %q", status)
+		}
+	})
+
+	t.Run("Transcoding", func(t *testing.T) {
+		resp, err := http.Get(gateway.URL + "/v1/health/orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != `{"status":"SERVING"}` {
+			t.Errorf("Expected the JSON health of orders, got %d %s", resp.StatusCode, body)
+		}
+
+		resp, err = http.Get(gateway.URL + "/v1/health/unknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 for the NotFound status, got %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(gateway.URL + "/v1/unbound")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 for a path bound to no method, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestGatewayTranscoderUnavailable(t *testing.T) {
+	bs := &service.BackendService{
+		Name:            "health",
+		Scheme:          service.SchemeGRPC,
+		Path:            "/v1/health",
+		UpstreamTargets: []string{"http://127.0.0.1:1"},
+		GRPC:            &config.GRPCConfig{DescriptorSet: filepath.Join(t.TempDir(), "missing.pb")},
+	}
+	bs.Init()
+
+	reg := newTestRegistry(t, bs)
+
+	handler := newTestGateway(t, reg, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/v1/health/orders", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
 func BenchmarkGatewayHandler(b *testing.B) {
 	bs := &service.BackendService{
 		Name:            "test",
@@ -0,0 +1,184 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of gRPC calls. Calls may name their codec after a plus sign.
+const ContentType = "application/grpc"
+
+// maxMessageSize bounds the error bodies kept as the message of a gRPC status
+const maxMessageSize = 1024
+
+// IsGRPC reports whether the request is a gRPC call
+func IsGRPC(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == ContentType || strings.HasPrefix(mediaType, ContentType+"+")
+}
+
+// Forward sends a call to the upstream and streams the response back, flushing every read so
+// streaming RPCs flow both ways, and ends it with the trailers of the upstream. The grpc-timeout
+// of the call is the deadline of the upstream request. Failures are reported to the client as a
+// gRPC status and returned.
+func Forward(w http.ResponseWriter, req *http.Request, client *http.Client, decorate func(http.Header)) error {
+	ctx := req.Context()
+	if value := req.Header.Get("Grpc-Timeout"); value != "" {
+		timeout, err := ParseTimeout(value)
+		if err != nil {
+			WriteStatus(w, InvalidArgument, err.Error())
+			return err
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// gRPC servers reject calls from clients that can't receive trailers
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		WriteStatus(w, codeFromError(ctx, err), err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	if decorate != nil {
+		decorate(w.Header())
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	flusher := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	var readErr error
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				// The client is gone, cancelling the context of the request ends the upstream call
+				return err
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+
+	// Trailers are only known once the body has been read
+	for name, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+name] = values
+	}
+	if readErr != nil && resp.Trailer.Get("Grpc-Status") == "" {
+		status := make(http.Header)
+		SetStatus(status, codeFromError(ctx, readErr), readErr.Error())
+		for name, values := range status {
+			w.Header()[http.TrailerPrefix+name] = values
+		}
+	}
+	return readErr
+}
+
+// codeFromError returns the status of a call whose upstream request failed
+func codeFromError(ctx context.Context, err error) Code {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	return Unavailable
+}
+
+// ResponseWriter turns the HTTP errors written for a gRPC call into trailers-only responses
+// carrying the matching gRPC status, which is what gRPC clients understand
+type ResponseWriter struct {
+	http.ResponseWriter
+	contentType string
+	wroteHeader bool
+	status      int
+	message     bytes.Buffer
+}
+
+// NewResponseWriter wraps the response writer of a gRPC or gRPC-Web call, errors are answered
+// with the media type of the call. Finish must be called once the call has been answered.
+func NewResponseWriter(w http.ResponseWriter, req *http.Request) *ResponseWriter {
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = ContentType
+	}
+	return &ResponseWriter{ResponseWriter: w, contentType: contentType}
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.status != 0 {
+		return
+	}
+	if status == http.StatusOK {
+		w.wroteHeader = true
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// Hold errors back until their message is known
+	w.status = status
+}
+
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader && w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		if room := maxMessageSize - w.message.Len(); room > 0 {
+			if len(p) > room {
+				w.message.Write(p[:room])
+			} else {
+				w.message.Write(p)
+			}
+		}
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *ResponseWriter) Flush() {
+	if w.wroteHeader {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the wrapped response writer
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Finish writes the gRPC status of an error held back. The status set by an upstream that
+// answered with an HTTP error is kept.
+func (w *ResponseWriter) Finish() {
+	if w.status == 0 {
+		return
+	}
+	header := w.Header()
+	if status := header.Get("Grpc-Status"); status != "" {
+		// Forward the status and message of the upstream as they are
+		header.Del("Content-Length")
+		header.Set("Content-Type", w.contentType)
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+		return
+	}
+	header.Del("X-Content-Type-Options")
+	writeStatus(w.ResponseWriter, w.contentType, CodeFromHTTPStatus(w.status), strings.TrimSpace(w.message.String()))
+}
@@ -0,0 +1,353 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestParseTimeout(t *testing.T) {
+	valid := map[string]time.Duration{
+		"1H":        time.Hour,
+		"2M":        2 * time.Minute,
+		"30S":       30 * time.Second,
+		"100m":      100 * time.Millisecond,
+		"5u":        5 * time.Microsecond,
+		"99999999n": 99999999 * time.Nanosecond,
+	}
+	for value, expected := range valid {
+		timeout, err := ParseTimeout(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, timeout)
+	}
+
+	for _, value := range []string{"", "S", "10", "10s", "-1S", "123456789S"} {
+		_, err := ParseTimeout(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	for contentType, expected := range map[string]bool{
+		"application/grpc":       true,
+		"application/grpc+proto": true,
+		"application/grpc+json":  true,
+		"application/grpc-web":   false,
+		"application/json":       false,
+		"":                       false,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", contentType)
+		require.Equal(t, expected, IsGRPC(req), contentType)
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Run("Gateway error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, grpcRequest())
+		http.Error(w, "token expired: 100%", http.StatusUnauthorized)
+		w.Finish()
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+		require.Equal(t, "16", rec.Header().Get("Grpc-Status"))
+		require.Equal(t, "token expired: 100%25", rec.Header().Get("Grpc-Message"))
+		require.Empty(t, rec.Body.String())
+	})
+
+	t.Run("Upstream status", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, grpcRequest())
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "not found")
+		w.WriteHeader(http.StatusNotFound)
+		w.Finish()
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "5", rec.Header().Get("Grpc-Status"))
+		require.Equal(t, "not found", rec.Header().Get("Grpc-Message"))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, grpcRequest())
+		w.Write([]byte("message"))
+		w.Flush()
+		w.Finish()
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.True(t, rec.Flushed)
+		require.Empty(t, rec.Header().Get("Grpc-Status"))
+		require.Equal(t, "message", rec.Body.String())
+	})
+}
+
+func TestForwardTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}), &http2.Server{}))
+	defer upstream.Close()
+	client := h2cClient()
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL+"/pkg.Service/Method", strings.NewReader(""))
+	req.RequestURI = ""
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Grpc-Timeout", "50m")
+
+	rec := httptest.NewRecorder()
+	err := Forward(rec, req, client, nil)
+	require.Error(t, err)
+	require.Equal(t, "4", rec.Header().Get("Grpc-Status"))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the upstream call was not cancelled at the deadline")
+	}
+}
+
+func TestCodeFromHTTPStatus(t *testing.T) {
+	require.Equal(t, Unimplemented, CodeFromHTTPStatus(http.StatusNotFound))
+	require.Equal(t, PermissionDenied, CodeFromHTTPStatus(http.StatusForbidden))
+	require.Equal(t, ResourceExhausted, CodeFromHTTPStatus(http.StatusRequestEntityTooLarge))
+	require.Equal(t, Unavailable, CodeFromHTTPStatus(http.StatusBadGateway))
+	require.Equal(t, Unknown, CodeFromHTTPStatus(http.StatusTeapot))
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	require.Equal(t, http.StatusOK, HTTPStatusFromCode(OK))
+	require.Equal(t, http.StatusBadRequest, HTTPStatusFromCode(FailedPrecondition))
+	require.Equal(t, http.StatusNotFound, HTTPStatusFromCode(NotFound))
+	require.Equal(t, http.StatusConflict, HTTPStatusFromCode(Aborted))
+	require.Equal(t, http.StatusServiceUnavailable, HTTPStatusFromCode(Unavailable))
+	require.Equal(t, http.StatusInternalServerError, HTTPStatusFromCode(DataLoss))
+}
+
+func TestTranslateWeb(t *testing.T) {
+	t.Run("Text", func(t *testing.T) {
+		body := base64.StdEncoding.EncodeToString(frame(0, []byte("request")))
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", strings.NewReader(body))
+		req.Header.Set("Content-Type", WebTextContentType+"+proto")
+		req.Header.Set("X-Grpc-Web", "1")
+		require.True(t, IsGRPCWeb(req))
+
+		rec := httptest.NewRecorder()
+		w, call := TranslateWeb(rec, req)
+		require.Equal(t, ContentType+"+proto", call.Header.Get("Content-Type"))
+		require.Empty(t, call.Header.Get("X-Grpc-Web"))
+		decoded, err := io.ReadAll(call.Body)
+		require.NoError(t, err)
+		require.Equal(t, frame(0, []byte("request")), decoded)
+
+		w.Header().Set("Content-Type", ContentType+"+proto")
+		w.WriteHeader(http.StatusOK)
+		w.Write(frame(0, []byte("response")))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+		w.Finish()
+
+		require.Equal(t, WebTextContentType+"+proto", rec.Header().Get("Content-Type"))
+		require.Empty(t, rec.Header().Get(http.TrailerPrefix+"Grpc-Status"))
+		// Every write is a base64 chunk of its own
+		chunks := base64.StdEncoding.EncodeToString(frame(0, []byte("response"))) +
+			base64.StdEncoding.EncodeToString(frame(trailerFlag, []byte("grpc-message: done\r\ngrpc-status: 0\r\n")))
+		require.Equal(t, chunks, rec.Body.String())
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", bytes.NewReader(frame(0, []byte("request"))))
+		req.Header.Set("Content-Type", WebContentType)
+		rec := httptest.NewRecorder()
+		w, call := TranslateWeb(rec, req)
+		require.Equal(t, ContentType, call.Header.Get("Content-Type"))
+
+		w.Write(frame(0, []byte("response")))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Finish()
+
+		require.Equal(t, WebContentType, rec.Header().Get("Content-Type"))
+		require.Equal(t, append(frame(0, []byte("response")), frame(trailerFlag, []byte("grpc-status: 0\r\n"))...), rec.Body.Bytes())
+	})
+
+	t.Run("Gateway error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", WebTextContentType)
+		rec := httptest.NewRecorder()
+		grpcWriter := NewResponseWriter(rec, req)
+		w, _ := TranslateWeb(grpcWriter, req)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		w.Finish()
+		grpcWriter.Finish()
+
+		// The status is sent in the headers of a trailers-only response
+		require.Equal(t, WebTextContentType, rec.Header().Get("Content-Type"))
+		require.Equal(t, "7", rec.Header().Get("Grpc-Status"))
+		require.Equal(t, "forbidden", rec.Header().Get("Grpc-Message"))
+		require.Empty(t, rec.Body.String())
+	})
+}
+
+func TestTextReader(t *testing.T) {
+	// Clients may send padded chunks, split anywhere by the transport
+	body := base64.StdEncoding.EncodeToString([]byte("ab")) + base64.StdEncoding.EncodeToString([]byte("cde"))
+	r := &textReader{body: io.NopCloser(io.MultiReader(strings.NewReader(body[:3]), strings.NewReader(body[3:])))}
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "abcde", string(decoded))
+
+	r = &textReader{body: io.NopCloser(strings.NewReader("YWJj!!!!"))}
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+}
+
+func TestCompileTemplate(t *testing.T) {
+	template, err := compileTemplate("/v1/{name=shelves/*}/books/{book}:publish")
+	require.NoError(t, err)
+	params, ok := template.match("/v1/shelves/1/books/2:publish")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"name": "shelves/1", "book": "2"}, params)
+	_, ok = template.match("/v1/shelves/1/books/2")
+	require.False(t, ok)
+
+	template, err = compileTemplate("/v1/files/{path=**}")
+	require.NoError(t, err)
+	params, ok = template.match("/v1/files/a/b/c.txt")
+	require.True(t, ok)
+	require.Equal(t, "a/b/c.txt", params["path"])
+
+	for _, invalid := range []string{"v1/books", "/v1/{book", "/v1/{a={b}}"} {
+		_, err := compileTemplate(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestTranscoder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	transcoder, err := NewTranscoder(&config.GRPCConfig{DescriptorSet: writeHealthDescriptorSet(t)})
+	require.NoError(t, err)
+	client := h2cClient()
+	decorate := func(header http.Header) { header.Set("X-Decorated", "true") }
+
+	call := func(ctx context.Context, method, target string) (*httptest.ResponseRecorder, bool) {
+		req := httptest.NewRequest(method, "http://"+listener.Addr().String()+target, nil).WithContext(ctx)
+		req.RequestURI = ""
+		rec := httptest.NewRecorder()
+		return rec, transcoder.Transcode(rec, req, req.URL.Path, client, decorate)
+	}
+
+	t.Run("Unary", func(t *testing.T) {
+		rec, ok := call(context.Background(), http.MethodGet, "/v1/health/orders")
+		require.True(t, ok)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.Equal(t, "true", rec.Header().Get("X-Decorated"))
+		require.JSONEq(t, `{"status":"SERVING"}`, rec.Body.String())
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		rec, ok := call(context.Background(), http.MethodGet, "/v1/health/unknown")
+		require.True(t, ok)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		var body struct {
+			Code    Code   `json:"code"`
+			Message string `json:"message"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		require.Equal(t, NotFound, body.Code)
+		require.Equal(t, "unknown service", body.Message)
+	})
+
+	t.Run("Invalid query", func(t *testing.T) {
+		rec, ok := call(context.Background(), http.MethodGet, "/v1/health/orders?color=red")
+		require.True(t, ok)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Server streaming", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		rec, ok := call(ctx, http.MethodGet, "/v1/health/orders:watch")
+		require.True(t, ok)
+		require.Equal(t, http.StatusOK, rec.Code)
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		require.Len(t, lines, 2)
+		require.JSONEq(t, `{"result":{"status":"SERVING"}}`, lines[0])
+		require.Contains(t, lines[1], `"code":4`)
+	})
+
+	t.Run("Unbound", func(t *testing.T) {
+		_, ok := call(context.Background(), http.MethodPost, "/v1/health/orders")
+		require.False(t, ok)
+	})
+}
+
+// writeHealthDescriptorSet writes the descriptor set of the gRPC health service with the Check
+// and Watch methods bound to REST paths
+func writeHealthDescriptorSet(t *testing.T) string {
+	file := protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)
+	for _, method := range file.Service[0].Method {
+		path := "/v1/health/{service}"
+		if method.GetName() == "Watch" {
+			path += ":watch"
+		}
+		method.Options = &descriptorpb.MethodOptions{}
+		proto.SetExtension(method.Options, annotations.E_Http, &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Get{Get: path},
+		})
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "health.pb")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func h2cClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func grpcRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+	req.Header.Set("Content-Type", ContentType)
+	return req
+}
@@ -0,0 +1,159 @@
+package grpcproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is a gRPC status code
+type Code int
+
+// The status codes of gRPC, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// CodeFromHTTPStatus returns the gRPC status code for an HTTP error status of the gateway or of
+// an upstream that answered without a gRPC status
+func CodeFromHTTPStatus(status int) Code {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusUnsupportedMediaType, http.StatusNotImplemented:
+		return Unimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	case http.StatusRequestEntityTooLarge, http.StatusRequestURITooLong, http.StatusRequestHeaderFieldsTooLarge,
+		http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusInternalServerError:
+		return Internal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return Unavailable
+	}
+	return Unknown
+}
+
+// SetStatus sets the grpc-status and grpc-message of a response in its headers or trailers
+func SetStatus(header http.Header, code Code, message string) {
+	header.Set("Grpc-Status", strconv.Itoa(int(code)))
+	if message != "" {
+		header.Set("Grpc-Message", encodeMessage(message))
+	} else {
+		header.Del("Grpc-Message")
+	}
+}
+
+// WriteStatus answers a call with a trailers-only response carrying the status
+func WriteStatus(w http.ResponseWriter, code Code, message string) {
+	writeStatus(w, ContentType, code, message)
+}
+
+// writeStatus answers a call of the media type with a trailers-only response carrying the status
+func writeStatus(w http.ResponseWriter, contentType string, code Code, message string) {
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", contentType)
+	SetStatus(header, code, message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ParseTimeout parses the grpc-timeout header of a call, an integer of at most 8 digits
+// followed by its unit
+func ParseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("invalid grpc-timeout unit in %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeMessage percent-encodes a status message as required in the grpc-message header
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// HTTPStatusFromCode returns the HTTP status answering a REST client for the status of a call,
+// as mapped by google.rpc.Code
+func HTTPStatusFromCode(code Code) int {
+	switch code {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,618 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Transcoder translates REST/JSON requests into calls of the gRPC methods bound to their method
+// and path by google.api.http annotations
+type Transcoder struct {
+	bindings []*binding
+}
+
+// binding is an HTTP method and path template bound to a gRPC method
+type binding struct {
+	method       protoreflect.MethodDescriptor
+	httpMethod   string
+	template     *pathTemplate
+	body         string
+	responseBody string
+}
+
+// NewTranscoder loads the methods with google.api.http annotations of a protobuf descriptor set,
+// as written by protoc --include_imports --descriptor_set_out
+func NewTranscoder(conf *config.GRPCConfig) (*Transcoder, error) {
+	if conf.DescriptorSet == "" {
+		return nil, fmt.Errorf("grpc descriptor set is required")
+	}
+	data, err := os.ReadFile(conf.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grpc descriptor set %s: %w", conf.DescriptorSet, err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid grpc descriptor set %s: %w", conf.DescriptorSet, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grpc descriptor set %s: %w", conf.DescriptorSet, err)
+	}
+
+	t := &Transcoder{}
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len() && err == nil; j++ {
+				err = t.addMethod(methods.Get(j))
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.bindings) == 0 {
+		return nil, fmt.Errorf("grpc descriptor set %s has no methods with google.api.http annotations", conf.DescriptorSet)
+	}
+	// Variables match colons too, templates ending with a verb are tried first so /v1/{name}:cancel
+	// isn't taken by /v1/{name}
+	sort.SliceStable(t.bindings, func(i, j int) bool {
+		return t.bindings[i].template.verb && !t.bindings[j].template.verb
+	})
+	return t, nil
+}
+
+// addMethod binds the method to the HTTP rules of its annotations
+func (t *Transcoder) addMethod(method protoreflect.MethodDescriptor) error {
+	options, ok := method.Options().(*descriptorpb.MethodOptions)
+	if !ok || options == nil || !proto.HasExtension(options, annotations.E_Http) {
+		return nil
+	}
+	if method.IsStreamingClient() {
+		return fmt.Errorf("method %s streams requests and can't be transcoded", method.FullName())
+	}
+	rule := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		var httpMethod, path string
+		switch pattern := r.GetPattern().(type) {
+		case *annotations.HttpRule_Get:
+			httpMethod, path = http.MethodGet, pattern.Get
+		case *annotations.HttpRule_Put:
+			httpMethod, path = http.MethodPut, pattern.Put
+		case *annotations.HttpRule_Post:
+			httpMethod, path = http.MethodPost, pattern.Post
+		case *annotations.HttpRule_Delete:
+			httpMethod, path = http.MethodDelete, pattern.Delete
+		case *annotations.HttpRule_Patch:
+			httpMethod, path = http.MethodPatch, pattern.Patch
+		case *annotations.HttpRule_Custom:
+			httpMethod, path = strings.ToUpper(pattern.Custom.GetKind()), pattern.Custom.GetPath()
+		default:
+			return fmt.Errorf("method %s has an http rule without a pattern", method.FullName())
+		}
+
+		template, err := compileTemplate(path)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", method.FullName(), err)
+		}
+		t.bindings = append(t.bindings, &binding{
+			method:       method,
+			httpMethod:   httpMethod,
+			template:     template,
+			body:         r.GetBody(),
+			responseBody: r.GetResponseBody(),
+		})
+	}
+	return nil
+}
+
+// Transcode answers a REST/JSON request with the gRPC method bound to its method and path, which
+// is called through client. It reports false, without answering, when no method is bound to
+// the request. decorate may change the headers of the response.
+func (t *Transcoder) Transcode(w http.ResponseWriter, req *http.Request, path string, client *http.Client, decorate func(http.Header)) bool {
+	b, params := t.match(req.Method, path)
+	if b == nil {
+		return false
+	}
+
+	message := dynamicpb.NewMessage(b.method.Input())
+	if err := b.buildRequest(message, req, params); err != nil {
+		writeJSONError(w, InvalidArgument, err.Error(), decorate)
+		return true
+	}
+	data, err := proto.Marshal(message)
+	if err != nil {
+		writeJSONError(w, Internal, err.Error(), decorate)
+		return true
+	}
+
+	call := req.Clone(req.Context())
+	call.Method = http.MethodPost
+	call.URL = &url.URL{
+		Scheme: req.URL.Scheme,
+		Host:   req.URL.Host,
+		Path:   fmt.Sprintf("/%s/%s", b.method.Parent().FullName(), b.method.Name()),
+	}
+	call.Header.Set("Content-Type", ContentType+"+proto")
+	call.Header.Del("Content-Length")
+	call.Header.Del("Accept-Encoding")
+	call.Body = io.NopCloser(bytes.NewReader(frame(0, data)))
+	call.ContentLength = int64(5 + len(data))
+
+	rec := &callRecorder{client: w, binding: b, header: make(http.Header), decorate: decorate}
+	Forward(rec, call, client, nil)
+	rec.finish()
+	return true
+}
+
+// match returns the binding of a request and the values of its path variables
+func (t *Transcoder) match(method, path string) (*binding, map[string]string) {
+	for _, b := range t.bindings {
+		if b.httpMethod != method {
+			continue
+		}
+		if params, ok := b.template.match(path); ok {
+			return b, params
+		}
+	}
+	return nil, nil
+}
+
+// buildRequest fills the request message with the body, the path variables and the query of
+// the request, in that order
+func (b *binding) buildRequest(message *dynamicpb.Message, req *http.Request, params map[string]string) error {
+	if b.body != "" && req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if b.body != "*" {
+				// Nest the body under the field it is bound to
+				fields := strings.Split(b.body, ".")
+				for i := len(fields) - 1; i >= 0; i-- {
+					key, _ := json.Marshal(fields[i])
+					data = []byte(fmt.Sprintf("{%s:%s}", key, data))
+				}
+			}
+			if err := protojson.Unmarshal(data, message); err != nil {
+				return fmt.Errorf("invalid body: %w", err)
+			}
+		}
+	}
+
+	for field, value := range params {
+		if err := setField(message, field, value); err != nil {
+			return err
+		}
+	}
+
+	if b.body == "*" {
+		return nil
+	}
+	for field, values := range req.URL.Query() {
+		if _, ok := params[field]; ok {
+			continue
+		}
+		for _, value := range values {
+			if err := setField(message, field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setField sets the field at a dot separated path of the message from its text form. Values
+// are appended to repeated fields.
+func setField(message protoreflect.Message, path, value string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fields := message.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown field %s", path)
+		}
+
+		if i < len(names)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %s is not a message", path)
+			}
+			message = message.Mutable(fd).Message()
+			continue
+		}
+
+		if fd.IsMap() {
+			return fmt.Errorf("map field %s can't be set from the request", path)
+		}
+		v, err := parseValue(message, fd, value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field %s: %w", path, err)
+		}
+		if fd.IsList() {
+			message.Mutable(fd).List().Append(v)
+		} else {
+			message.Set(fd, v)
+		}
+	}
+	return nil
+}
+
+// parseValue converts the text form of a field value
+func parseValue(message protoreflect.Message, fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if v := fd.Enum().Values().ByName(protoreflect.Name(value)); v != nil {
+			return protoreflect.ValueOfEnum(v.Number()), nil
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown enum value %s", value)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// Well-known types such as timestamps and wrappers have a JSON form that can be written
+		// in a path or query
+		v := message.NewField(fd).Message()
+		if fd.IsList() {
+			v = message.Mutable(fd).List().NewElement().Message()
+		}
+		if err := protojson.Unmarshal([]byte(value), v.Interface()); err != nil {
+			quoted, _ := json.Marshal(value)
+			if err := protojson.Unmarshal(quoted, v.Interface()); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return protoreflect.ValueOfMessage(v), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+// callRecorder collects the response of a transcoded call and answers the REST client with JSON.
+// Messages of server streaming methods are written as they arrive, one JSON object per line.
+type callRecorder struct {
+	client   http.ResponseWriter
+	binding  *binding
+	header   http.Header
+	decorate func(http.Header)
+	status   int
+	pending  []byte
+	messages [][]byte
+	started  bool
+	err      error
+}
+
+func (r *callRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *callRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *callRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status != http.StatusOK || r.err != nil {
+		return len(p), nil
+	}
+
+	r.pending = append(r.pending, p...)
+	for len(r.pending) >= 5 {
+		size := binary.BigEndian.Uint32(r.pending[1:5])
+		if uint32(len(r.pending)-5) < size {
+			break
+		}
+		if r.pending[0] != 0 {
+			r.err = fmt.Errorf("compressed messages are not supported")
+			return len(p), nil
+		}
+		message := append([]byte(nil), r.pending[5:5+size]...)
+		r.pending = r.pending[5+size:]
+
+		if !r.binding.method.IsStreamingServer() {
+			r.messages = append(r.messages, message)
+			continue
+		}
+		data, err := r.binding.encodeResponse(message)
+		if err != nil {
+			r.err = err
+			return len(p), nil
+		}
+		r.start()
+		r.client.Write([]byte(`{"result":`))
+		r.client.Write(data)
+		r.client.Write([]byte("}\n"))
+		http.NewResponseController(r.client).Flush()
+	}
+	return len(p), nil
+}
+
+func (r *callRecorder) Flush() {}
+
+// start writes the headers of a successful response
+func (r *callRecorder) start() {
+	if r.started {
+		return
+	}
+	r.started = true
+	r.client.Header().Set("Content-Type", "application/json")
+	if r.decorate != nil {
+		r.decorate(r.client.Header())
+	}
+	r.client.WriteHeader(http.StatusOK)
+}
+
+// finish answers the client once the call has ended
+func (r *callRecorder) finish() {
+	code, message := r.callStatus()
+	if r.err != nil && code == OK {
+		code, message = Internal, r.err.Error()
+	}
+
+	if r.started {
+		// The status of a stream comes after its messages
+		if code != OK {
+			data, _ := json.Marshal(map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}})
+			r.client.Write(append(data, '\n'))
+		}
+		return
+	}
+	if code != OK {
+		writeJSONError(r.client, code, message, r.decorate)
+		return
+	}
+	if r.binding.method.IsStreamingServer() {
+		r.start()
+		return
+	}
+	if len(r.messages) != 1 {
+		writeJSONError(r.client, Internal, fmt.Sprintf("expected one response message, got %d", len(r.messages)), r.decorate)
+		return
+	}
+	data, err := r.binding.encodeResponse(r.messages[0])
+	if err != nil {
+		writeJSONError(r.client, Internal, err.Error(), r.decorate)
+		return
+	}
+	r.start()
+	r.client.Write(data)
+}
+
+// callStatus returns the gRPC status of the call, from its trailers or headers
+func (r *callRecorder) callStatus() (Code, string) {
+	status := r.header.Get(http.TrailerPrefix + "Grpc-Status")
+	message := r.header.Get(http.TrailerPrefix + "Grpc-Message")
+	if status == "" {
+		status, message = r.header.Get("Grpc-Status"), r.header.Get("Grpc-Message")
+	}
+	if status == "" {
+		if r.status != http.StatusOK {
+			return CodeFromHTTPStatus(r.status), http.StatusText(r.status)
+		}
+		return Internal, "call ended without a grpc-status"
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return Unknown, message
+	}
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	return Code(code), message
+}
+
+// encodeResponse converts a response message to JSON, keeping only the field named by the
+// response body of the binding if it has one
+func (b *binding) encodeResponse(data []byte) ([]byte, error) {
+	message := dynamicpb.NewMessage(b.method.Output())
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	if b.responseBody == "" {
+		return protojson.Marshal(message)
+	}
+
+	var m protoreflect.Message = message
+	names := strings.Split(b.responseBody, ".")
+	for i, name := range names {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("unknown response body field %s", b.responseBody)
+		}
+		if i < len(names)-1 {
+			m = m.Get(fd).Message()
+			continue
+		}
+		// Marshal the parent with the field alone and unwrap it
+		parent := m.New()
+		parent.Set(fd, m.Get(fd))
+		encoded, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(parent.Interface())
+		if err != nil {
+			return nil, err
+		}
+		var fieldsJSON map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &fieldsJSON); err != nil {
+			return nil, err
+		}
+		return fieldsJSON[fd.JSONName()], nil
+	}
+	return nil, fmt.Errorf("empty response body field")
+}
+
+// writeJSONError answers a REST client with the status of a failed call
+func writeJSONError(w http.ResponseWriter, code Code, message string, decorate func(http.Header)) {
+	w.Header().Set("Content-Type", "application/json")
+	if decorate != nil {
+		decorate(w.Header())
+	}
+	w.WriteHeader(HTTPStatusFromCode(code))
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message})
+}
+
+// pathTemplate matches request paths against a google.api.http path template
+type pathTemplate struct {
+	re     *regexp.Regexp
+	fields []string
+	verb   bool
+}
+
+// compileTemplate compiles a path template such as /v1/{name=shelves/*}/books/{book}:verb
+func compileTemplate(template string) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("invalid path template %s, it must start with /", template)
+	}
+	path, verb := template, ""
+	if i := strings.LastIndex(template, ":"); i > strings.LastIndex(template, "/") && i > strings.LastIndex(template, "}") {
+		path, verb = template[:i], template[i+1:]
+	}
+
+	segments, err := splitTemplate(path[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %s: %w", template, err)
+	}
+	t := &pathTemplate{verb: verb != ""}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, segment := range segments {
+		expr.WriteString("/")
+		if !strings.HasPrefix(segment, "{") {
+			expr.WriteString(segmentsExpr(segment))
+			continue
+		}
+		if !strings.HasSuffix(segment, "}") {
+			return nil, fmt.Errorf("invalid path template %s: unterminated variable", template)
+		}
+		field, pattern, ok := strings.Cut(segment[1:len(segment)-1], "=")
+		if !ok {
+			pattern = "*"
+		}
+		if field == "" || strings.ContainsAny(pattern, "{}") {
+			return nil, fmt.Errorf("invalid path template %s: invalid variable %s", template, segment)
+		}
+		expr.WriteString("(" + segmentsExpr(pattern) + ")")
+		t.fields = append(t.fields, field)
+	}
+	if verb != "" {
+		expr.WriteString(":" + regexp.QuoteMeta(verb))
+	}
+	expr.WriteString("$")
+
+	if t.re, err = regexp.Compile(expr.String()); err != nil {
+		return nil, fmt.Errorf("invalid path template %s: %w", template, err)
+	}
+	return t, nil
+}
+
+// splitTemplate splits a path template at the slashes outside of variables
+func splitTemplate(path string) ([]string, error) {
+	var segments []string
+	depth, start := 0, 0
+	for i, c := range path {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+		if depth < 0 || depth > 1 {
+			return nil, fmt.Errorf("unbalanced braces")
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced braces")
+	}
+	return append(segments, path[start:]), nil
+}
+
+// segmentsExpr returns the expression matching slash separated segments of a template
+func segmentsExpr(segments string) string {
+	parts := strings.Split(segments, "/")
+	for i, part := range parts {
+		switch part {
+		case "*":
+			parts[i] = "[^/]+"
+		case "**":
+			parts[i] = ".*"
+		default:
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// match returns the values of the variables of the template in the path
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	groups := t.re.FindStringSubmatch(path)
+	if groups == nil {
+		return nil, false
+	}
+	params := make(map[string]string, len(t.fields))
+	for i, field := range t.fields {
+		params[field] = groups[i+1]
+	}
+	return params, true
+}
@@ -0,0 +1,192 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// WebContentType is the media type of gRPC-Web calls with binary bodies
+	WebContentType = "application/grpc-web"
+	// WebTextContentType is the media type of gRPC-Web calls with base64 encoded bodies
+	WebTextContentType = "application/grpc-web-text"
+
+	// trailerFlag marks the frame holding the trailers at the end of a gRPC-Web response
+	trailerFlag = 0x80
+)
+
+// IsGRPCWeb reports whether the request is a gRPC-Web call
+func IsGRPCWeb(req *http.Request) bool {
+	_, _, ok := webMediaType(req)
+	return ok
+}
+
+// webMediaType returns the gRPC-Web media type of the request, without codec, whether its body
+// is base64 encoded
+func webMediaType(req *http.Request) (string, bool, bool) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return "", false, false
+	}
+	for _, base := range []string{WebTextContentType, WebContentType} {
+		if mediaType == base || strings.HasPrefix(mediaType, base+"+") {
+			return mediaType, base == WebTextContentType, true
+		}
+	}
+	return "", false, false
+}
+
+// WebWriter answers a gRPC-Web call with the response of the gRPC call it was translated to
+type WebWriter struct {
+	http.ResponseWriter
+	contentType string
+	text        bool
+	wroteHeader bool
+	failed      bool
+}
+
+// TranslateWeb turns a gRPC-Web call into a native gRPC call and returns the writer that
+// translates the response back. Finish must be called on the writer once the call has been
+// answered to send the trailers.
+func TranslateWeb(w http.ResponseWriter, req *http.Request) (*WebWriter, *http.Request) {
+	mediaType, text, _ := webMediaType(req)
+
+	call := req.Clone(req.Context())
+	call.Header.Set("Content-Type", ContentType+strings.TrimPrefix(strings.TrimPrefix(mediaType, WebTextContentType), WebContentType))
+	call.Header.Del("Content-Length")
+	call.Header.Del("X-Grpc-Web")
+	if text && req.Body != nil && req.Body != http.NoBody {
+		call.Body = &textReader{body: req.Body}
+		call.ContentLength = -1
+	}
+
+	return &WebWriter{ResponseWriter: w, contentType: mediaType, text: text}, call
+}
+
+func (w *WebWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	// Errors are left to the ResponseWriter of the call, which turns them into a status
+	w.failed = status != http.StatusOK
+	w.Header().Set("Content-Type", w.contentType)
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *WebWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.text || w.failed {
+		return w.ResponseWriter.Write(p)
+	}
+	// Every write is a padded base64 chunk, which clients decode as they arrive
+	if _, err := w.ResponseWriter.Write([]byte(base64.StdEncoding.EncodeToString(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *WebWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped response writer
+func (w *WebWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Finish sends the trailers of the gRPC call as the last frame of the body, gRPC-Web clients
+// can't rely on HTTP trailers
+func (w *WebWriter) Finish() {
+	header := w.Header()
+	trailers := make(map[string]string)
+	for name, values := range header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			trailers[strings.ToLower(strings.TrimPrefix(name, http.TrailerPrefix))] = strings.Join(values, ",")
+			delete(header, name)
+		}
+	}
+	// Trailers-only responses carry the status in their headers
+	if !w.wroteHeader || w.failed || len(trailers) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var block bytes.Buffer
+	for _, name := range names {
+		block.WriteString(name + ": " + trailers[name] + "\r\n")
+	}
+	w.Write(frame(trailerFlag, block.Bytes()))
+	w.Flush()
+}
+
+// frame prefixes a message with the flags and length of a gRPC frame
+func frame(flags byte, message []byte) []byte {
+	out := make([]byte, 5+len(message))
+	out[0] = flags
+	binary.BigEndian.PutUint32(out[1:5], uint32(len(message)))
+	copy(out[5:], message)
+	return out
+}
+
+// textReader decodes a base64 body. Clients may send it in several padded chunks, so every group
+// of four characters is decoded on its own.
+type textReader struct {
+	body    io.ReadCloser
+	encoded []byte
+	decoded []byte
+	err     error
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.err != nil {
+			if r.err == io.EOF && len(r.encoded) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, r.err
+		}
+
+		buf := make([]byte, 4096)
+		n, err := r.body.Read(buf)
+		r.err = err
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				r.encoded = append(r.encoded, c)
+			}
+		}
+
+		whole := len(r.encoded) - len(r.encoded)%4
+		var quantum [3]byte
+		for i := 0; i < whole; i += 4 {
+			m, err := base64.StdEncoding.Decode(quantum[:], r.encoded[i:i+4])
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.decoded = append(r.decoded, quantum[:m]...)
+		}
+		r.encoded = append(r.encoded[:0], r.encoded[whole:]...)
+	}
+
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
+
+func (r *textReader) Close() error {
+	return r.body.Close()
+}
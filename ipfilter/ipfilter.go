@@ -0,0 +1,130 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+// Rules is a compiled set of allow and deny rules
+type Rules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewRules compiles the allow and deny lists. Entries are CIDR ranges or single IP addresses.
+func NewRules(conf *config.IPFilterConfig) (*Rules, error) {
+	allow, err := ParseNetworks(conf.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := ParseNetworks(conf.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{allow: allow, deny: deny}, nil
+}
+
+// ParseNetworks parses a list of CIDR ranges or single IP addresses
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range: %s", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the IP passes the rules. Deny rules take precedence over allow
+// rules, and when allow rules are present only matching addresses are allowed.
+func (r *Rules) Allowed(ip net.IP) bool {
+	if len(r.allow) == 0 && len(r.deny) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if contains(r.deny, ip) {
+		return false
+	}
+	if len(r.allow) > 0 {
+		return contains(r.allow, ip)
+	}
+	return true
+}
+
+// Filter holds global rules that can be replaced at runtime
+type Filter struct {
+	mu    sync.RWMutex
+	conf  config.IPFilterConfig
+	rules *Rules
+}
+
+func NewFilter(conf *config.IPFilterConfig) (*Filter, error) {
+	f := &Filter{rules: &Rules{}}
+	if conf != nil {
+		if err := f.Update(*conf); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Update validates and replaces the rules of the filter
+func (f *Filter) Update(conf config.IPFilterConfig) error {
+	rules, err := NewRules(&conf)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.conf = conf
+	f.rules = rules
+	return nil
+}
+
+// Config returns the rules the filter was last updated with
+func (f *Filter) Config() config.IPFilterConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.conf
+}
+
+func (f *Filter) Allowed(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.rules.Allowed(ip)
+}
@@ -0,0 +1,89 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := NewRules(&config.IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"},
+		Deny:  []string{"10.0.13.0/24"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"10.0.13.37", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"fd00::1", true},
+		{"2001:db8::1", false},
+		{"8.8.8.8", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.allowed, rules.Allowed(net.ParseIP(tc.ip)), tc.ip)
+	}
+	require.False(t, rules.Allowed(nil))
+
+	rules, err = NewRules(&config.IPFilterConfig{Deny: []string{"203.0.113.0/24"}})
+	require.NoError(t, err)
+	require.True(t, rules.Allowed(net.ParseIP("8.8.8.8")))
+	require.False(t, rules.Allowed(net.ParseIP("203.0.113.7")))
+
+	_, err = NewRules(&config.IPFilterConfig{Allow: []string{"10.0.0.0/33"}})
+	require.Error(t, err)
+	_, err = NewRules(&config.IPFilterConfig{Deny: []string{"localhost"}})
+	require.Error(t, err)
+}
+
+func TestFilterUpdate(t *testing.T) {
+	filter, err := NewFilter(nil)
+	require.NoError(t, err)
+	require.True(t, filter.Allowed(net.ParseIP("8.8.8.8")))
+
+	require.NoError(t, filter.Update(config.IPFilterConfig{Deny: []string{"8.8.8.8"}}))
+	require.False(t, filter.Allowed(net.ParseIP("8.8.8.8")))
+	require.Equal(t, []string{"8.8.8.8"}, filter.Config().Deny)
+
+	// Invalid rules leave the previous rules in place
+	require.Error(t, filter.Update(config.IPFilterConfig{Deny: []string{"not-an-ip"}}))
+	require.False(t, filter.Allowed(net.ParseIP("8.8.8.8")))
+}
+
+func TestResolverClientIP(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		description  string
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{"untrusted peer ignores forwarded header", "203.0.113.7:4000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer uses forwarded header", "10.0.0.1:4000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"spoofed entries left of the client are ignored", "10.0.0.1:4000", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"multiple forwarded headers", "10.0.0.1:4000", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"trusted peer without forwarded header", "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"invalid forwarded entry stops the walk", "10.0.0.1:4000", []string{"1.2.3.4, garbage"}, "10.0.0.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := &http.Request{RemoteAddr: tc.remoteAddr, Header: http.Header{}}
+			for _, v := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			require.Equal(t, tc.expectedIP, resolver.ClientIP(req).String())
+		})
+	}
+}
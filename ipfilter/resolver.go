@@ -0,0 +1,72 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// Resolver determines the client IP of a request, trusting forwarding information only
+// when it was added by a trusted proxy.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	trusted, err := ParseNetworks(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// IsTrusted reports whether the IP belongs to a trusted proxy
+func (r *Resolver) IsTrusted(ip net.IP) bool {
+	return ip != nil && contains(r.trusted, ip)
+}
+
+// ClientIP returns the IP of the client. When the peer is a trusted proxy the X-Forwarded-For
+// header is walked from the right, returning the first address that is not a trusted proxy.
+func (r *Resolver) ClientIP(req *http.Request) net.IP {
+	peer := parseIP(req.RemoteAddr)
+	if !r.IsTrusted(peer) {
+		return peer
+	}
+
+	var forwarded []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+
+	client := peer
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := parseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !r.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// ProxyProtocolPolicy accepts PROXY protocol headers from trusted proxies only and rejects
+// connections from any other peer that sends one.
+func (r *Resolver) ProxyProtocolPolicy(upstream net.Addr) (proxyproto.Policy, error) {
+	if r.IsTrusted(parseIP(upstream.String())) {
+		return proxyproto.USE, nil
+	}
+	return proxyproto.REJECT, nil
+}
+
+// parseIP parses an IP address with an optional port
+func parseIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
@@ -0,0 +1,104 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+// Limits bounds the size of requests before any work is done for them
+type Limits struct {
+	maxBodySize    int64
+	maxHeaderBytes int
+	maxHeaders     int
+	maxURLLength   int
+}
+
+// NewLimits compiles the request limits of the gateway or a backend service
+func NewLimits(conf *config.LimitsConfig) (*Limits, error) {
+	if conf.MaxBodySize < 0 || conf.MaxHeaderBytes < 0 || conf.MaxHeaders < 0 || conf.MaxURLLength < 0 {
+		return nil, fmt.Errorf("request limits cannot be negative")
+	}
+	return &Limits{
+		maxBodySize:    conf.MaxBodySize,
+		maxHeaderBytes: conf.MaxHeaderBytes,
+		maxHeaders:     conf.MaxHeaders,
+		maxURLLength:   conf.MaxURLLength,
+	}, nil
+}
+
+// Merge returns the limits with those it leaves unset taken from fallback. Either may be nil.
+func (l *Limits) Merge(fallback *Limits) *Limits {
+	if l == nil {
+		return fallback
+	}
+	if fallback == nil {
+		return l
+	}
+
+	merged := *l
+	if merged.maxBodySize == 0 {
+		merged.maxBodySize = fallback.maxBodySize
+	}
+	if merged.maxHeaderBytes == 0 {
+		merged.maxHeaderBytes = fallback.maxHeaderBytes
+	}
+	if merged.maxHeaders == 0 {
+		merged.maxHeaders = fallback.maxHeaders
+	}
+	if merged.maxURLLength == 0 {
+		merged.maxURLLength = fallback.maxURLLength
+	}
+	return &merged
+}
+
+// Enforce checks the request against the limits and answers it with an error status if one is
+// exceeded. Bodies of unknown length are limited as they are read, see IsBodyTooLarge.
+func (l *Limits) Enforce(w http.ResponseWriter, req *http.Request) bool {
+	if l.maxURLLength > 0 && urlLength(req) > l.maxURLLength {
+		http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+		return false
+	}
+
+	if l.maxHeaders > 0 || l.maxHeaderBytes > 0 {
+		count, size := 0, 0
+		for name, values := range req.Header {
+			for _, value := range values {
+				count++
+				// Each line holds the name, a colon, a space, the value and CRLF
+				size += len(name) + len(value) + 4
+			}
+		}
+		if (l.maxHeaders > 0 && count > l.maxHeaders) || (l.maxHeaderBytes > 0 && size > l.maxHeaderBytes) {
+			http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+			return false
+		}
+	}
+
+	if l.maxBodySize > 0 && req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength > l.maxBodySize {
+			// Don't let the server read the body to keep the connection open
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, l.maxBodySize)
+	}
+
+	return true
+}
+
+// IsBodyTooLarge reports whether the error comes from reading a body larger than its limit
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func urlLength(req *http.Request) int {
+	if req.RequestURI != "" {
+		return len(req.RequestURI)
+	}
+	return len(req.URL.RequestURI())
+}
@@ -0,0 +1,77 @@
+package limits
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforce(t *testing.T) {
+	l, err := NewLimits(&config.LimitsConfig{MaxBodySize: 8, MaxHeaderBytes: 64, MaxHeaders: 3, MaxURLLength: 16})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		url      string
+		headers  map[string]string
+		body     string
+		expected int
+	}{
+		{"Within limits", "/short", map[string]string{"Accept": "*/*"}, "small", http.StatusOK},
+		{"URL too long", "/a/very/long/path?with=query", nil, "", http.StatusRequestURITooLong},
+		{"Too many headers", "/", map[string]string{"A": "1", "B": "2", "C": "3", "D": "4"}, "", http.StatusRequestHeaderFieldsTooLarge},
+		{"Headers too large", "/", map[string]string{"Cookie": strings.Repeat("x", 64)}, "", http.StatusRequestHeaderFieldsTooLarge},
+		{"Body too large", "/", nil, "more than eight bytes", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if l.Enforce(w, req) {
+				w.WriteHeader(http.StatusOK)
+			}
+			require.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
+
+func TestEnforceBodyOfUnknownLength(t *testing.T) {
+	l, err := NewLimits(&config.LimitsConfig{MaxBodySize: 8})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("more than eight bytes"))
+	req.ContentLength = -1
+	require.True(t, l.Enforce(httptest.NewRecorder(), req))
+
+	_, err = io.ReadAll(req.Body)
+	require.True(t, IsBodyTooLarge(err))
+}
+
+func TestMerge(t *testing.T) {
+	global, err := NewLimits(&config.LimitsConfig{MaxBodySize: 1024, MaxHeaders: 50})
+	require.NoError(t, err)
+	svc, err := NewLimits(&config.LimitsConfig{MaxBodySize: 1 << 20})
+	require.NoError(t, err)
+
+	merged := svc.Merge(global)
+	require.Equal(t, int64(1<<20), merged.maxBodySize)
+	require.Equal(t, 50, merged.maxHeaders)
+
+	var none *Limits
+	require.Equal(t, global, none.Merge(global))
+	require.Nil(t, none.Merge(nil))
+}
+
+func TestNewLimitsValidation(t *testing.T) {
+	_, err := NewLimits(&config.LimitsConfig{MaxBodySize: -1})
+	require.Error(t, err)
+}
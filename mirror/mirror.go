@@ -0,0 +1,220 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const (
+	DefaultMaxConcurrent       = 10
+	DefaultMaxBodySize   int64 = 1 << 20
+	DefaultTimeout             = 5
+)
+
+// Mirror sends a copy of a share of the requests of a backend service to a secondary target.
+// Responses of the target are discarded; only their status codes are compared with those of the
+// primary upstream.
+type Mirror struct {
+	target      *url.URL
+	percentage  float64
+	maxBodySize int64
+	timeout     time.Duration
+	slots       chan struct{}
+	client      *http.Client
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Stats counts the requests copied to a mirror target
+type Stats struct {
+	// Sent requests were copied to the target
+	Sent int64 `json:"sent"`
+	// Dropped requests were sampled but not copied because the concurrency limit was reached
+	// or the body exceeded the size limit
+	Dropped int64 `json:"dropped"`
+	// Failed requests could not be sent or did not complete within the timeout
+	Failed int64 `json:"failed"`
+	// Matched and Mismatched compare the status codes of the target and the primary upstream
+	Matched    int64 `json:"matched"`
+	Mismatched int64 `json:"mismatched"`
+	// StatusPairs counts the "primary:mirror" status code combinations
+	StatusPairs map[string]int64 `json:"statusPairs"`
+}
+
+func NewMirror(conf *config.MirrorConfig) (*Mirror, error) {
+	target, err := url.Parse(conf.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror target %s: %w", conf.Target, err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("mirror target %s must include a scheme and host", conf.Target)
+	}
+	if conf.Percentage < 0 || conf.Percentage > 100 {
+		return nil, errors.New("mirror percentage must be between 0 and 100")
+	}
+
+	maxConcurrent := conf.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrent
+	}
+	maxBodySize := conf.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Mirror{
+		target:      target,
+		percentage:  conf.Percentage,
+		maxBodySize: maxBodySize,
+		timeout:     timeout * time.Second,
+		slots:       make(chan struct{}, maxConcurrent),
+		client:      &http.Client{Timeout: timeout * time.Second},
+		stats:       Stats{StatusPairs: make(map[string]int64)},
+	}, nil
+}
+
+// Shadow is a request copied to the mirror target
+type Shadow struct {
+	primary chan int
+}
+
+// Done reports the status code returned to the client for the primary request. It must be called
+// once for every shadow, also when the primary request failed.
+func (s *Shadow) Done(status int) {
+	if s != nil {
+		s.primary <- status
+	}
+}
+
+// Copy samples the request and sends a copy to the mirror target in the background, returning nil
+// if the request is not mirrored. The body of the request is buffered and replaced so it can still
+// be sent to the primary upstream. path, rawQuery and header are those of the primary upstream
+// request.
+func (m *Mirror) Copy(req *http.Request, path string, rawQuery string, header http.Header) *Shadow {
+	if m.percentage < 100 && rand.Float64()*100 >= m.percentage {
+		return nil
+	}
+
+	// Never wait for a slot, the primary path must not be slowed down by the mirror
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.count(func(s *Stats) { s.Dropped++ })
+		return nil
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		buffered, err := io.ReadAll(io.LimitReader(req.Body, m.maxBodySize+1))
+		if err != nil || int64(len(buffered)) > m.maxBodySize {
+			// Hand the primary upstream whatever was read along with the rest of the body
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(buffered), req.Body), req.Body}
+			<-m.slots
+			m.count(func(s *Stats) { s.Dropped++ })
+			return nil
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(buffered))
+		body = buffered
+	}
+
+	target := *m.target
+	target.Path = singleJoiningSlash(m.target.Path, path)
+	target.RawQuery = rawQuery
+
+	shadow := &Shadow{primary: make(chan int, 1)}
+	go m.send(req.Method, target.String(), header.Clone(), body, shadow)
+	return shadow
+}
+
+func (m *Mirror) send(method string, target string, header http.Header, body []byte, shadow *Shadow) {
+	defer func() { <-m.slots }()
+
+	// The copy outlives the client request, so it gets its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		m.count(func(s *Stats) { s.Failed++ })
+		return
+	}
+	req.Header = header
+
+	m.count(func(s *Stats) { s.Sent++ })
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.count(func(s *Stats) { s.Failed++ })
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	select {
+	case primary := <-shadow.primary:
+		m.count(func(s *Stats) {
+			if primary == resp.StatusCode {
+				s.Matched++
+			} else {
+				s.Mismatched++
+			}
+			s.StatusPairs[strconv.Itoa(primary)+":"+strconv.Itoa(resp.StatusCode)]++
+		})
+	case <-ctx.Done():
+		m.count(func(s *Stats) { s.Failed++ })
+	}
+}
+
+func (m *Mirror) count(update func(s *Stats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(&m.stats)
+}
+
+// Stats returns a snapshot of the mirror statistics
+func (m *Mirror) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	stats.StatusPairs = make(map[string]int64, len(m.stats.StatusPairs))
+	for k, v := range m.stats.StatusPairs {
+		stats.StatusPairs[k] = v
+	}
+	return stats
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case a == "" || a == "/":
+		return b
+	case b == "":
+		return a
+	case a[len(a)-1] == '/' && b[0] == '/':
+		return a + b[1:]
+	case a[len(a)-1] != '/' && b[0] != '/':
+		return a + "/" + b
+	}
+	return a + b
+}
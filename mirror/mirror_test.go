@@ -0,0 +1,117 @@
+package mirror
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+type mirrored struct {
+	method string
+	uri    string
+	header string
+	body   string
+}
+
+func newTarget(t *testing.T, status int, block chan struct{}) (*httptest.Server, chan mirrored) {
+	received := make(chan mirrored, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if block != nil {
+			<-block
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- mirrored{method: r.Method, uri: r.URL.RequestURI(), header: r.Header.Get("X-Test"), body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func waitForStats(t *testing.T, m *Mirror, done func(Stats) bool) Stats {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats := m.Stats(); done(stats) {
+			return stats
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("mirror statistics not updated: %+v", m.Stats())
+	return Stats{}
+}
+
+func TestMirrorCopy(t *testing.T) {
+	server, received := newTarget(t, http.StatusInternalServerError, nil)
+
+	m, err := NewMirror(&config.MirrorConfig{Target: server.URL + "/shadow", Percentage: 100})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://gateway/api/orders?page=2", strings.NewReader(`{"item":1}`))
+	header := http.Header{"X-Test": []string{"copied"}}
+	shadow := m.Copy(req, "/orders", "page=2&size=10", header)
+	require.NotNil(t, shadow)
+
+	// The primary upstream still receives the full body
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"item":1}`, string(body))
+
+	shadow.Done(http.StatusOK)
+
+	select {
+	case r := <-received:
+		require.Equal(t, mirrored{method: http.MethodPost, uri: "/shadow/orders?page=2&size=10", header: "copied", body: `{"item":1}`}, r)
+	case <-time.After(2 * time.Second):
+		t.Fatal("mirror target did not receive the request")
+	}
+
+	stats := waitForStats(t, m, func(s Stats) bool { return s.Mismatched == 1 })
+	require.Equal(t, int64(1), stats.Sent)
+	require.Equal(t, int64(0), stats.Matched)
+	require.Equal(t, map[string]int64{"200:500": 1}, stats.StatusPairs)
+}
+
+func TestMirrorLimits(t *testing.T) {
+	block := make(chan struct{})
+	server, _ := newTarget(t, http.StatusOK, block)
+	defer close(block)
+
+	m, err := NewMirror(&config.MirrorConfig{Target: server.URL, Percentage: 100, MaxConcurrent: 1, MaxBodySize: 4})
+	require.NoError(t, err)
+
+	// The body exceeds the size limit, the primary upstream receives it untouched
+	req := httptest.NewRequest(http.MethodPost, "http://gateway/", strings.NewReader("too large"))
+	require.Nil(t, m.Copy(req, "/", "", http.Header{}))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, "too large", string(body))
+
+	// The first request occupies the only slot, the second is dropped without waiting
+	first := m.Copy(httptest.NewRequest(http.MethodGet, "http://gateway/", nil), "/", "", http.Header{})
+	require.NotNil(t, first)
+	defer first.Done(http.StatusOK)
+
+	start := time.Now()
+	require.Nil(t, m.Copy(httptest.NewRequest(http.MethodGet, "http://gateway/", nil), "/", "", http.Header{}))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	require.Equal(t, int64(2), m.Stats().Dropped)
+}
+
+func TestMirrorPercentage(t *testing.T) {
+	m, err := NewMirror(&config.MirrorConfig{Target: "http://localhost:1", Percentage: 0})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.Nil(t, m.Copy(httptest.NewRequest(http.MethodGet, "http://gateway/", nil), "/", "", http.Header{}))
+	}
+
+	_, err = NewMirror(&config.MirrorConfig{Target: "localhost", Percentage: 10})
+	require.Error(t, err)
+	_, err = NewMirror(&config.MirrorConfig{Target: "http://localhost", Percentage: 150})
+	require.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+const (
+	// MaxResponseSize bounds the responses buffered for validation, larger ones are not validated
+	MaxResponseSize = 1 << 20
+
+	specTimeout = 10 * time.Second
+)
+
+// Validator checks requests, and optionally responses, against the OpenAPI spec of a backend service
+type Validator struct {
+	router            routers.Router
+	validateResponses bool
+}
+
+// NewValidator loads the OpenAPI spec of a backend service and compiles its operations
+func NewValidator(conf *config.OpenAPIConfig) (*Validator, error) {
+	if conf.Spec == "" {
+		return nil, fmt.Errorf("openapi spec is required")
+	}
+
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	loader.ReadFromURIFunc = openapi3.ReadFromURIs(openapi3.ReadFromHTTP(&http.Client{Timeout: specTimeout}), openapi3.ReadFromFile)
+
+	var (
+		doc *openapi3.T
+		err error
+	)
+	if u, parseErr := url.Parse(conf.Spec); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		doc, err = loader.LoadFromURI(u)
+	} else {
+		doc, err = loader.LoadFromFile(conf.Spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load openapi spec %s: %w", conf.Spec, err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec %s: %w", conf.Spec, err)
+	}
+
+	// Operations are matched on the path sent upstream, whatever the servers the spec lists
+	doc.Servers = nil
+	for _, path := range doc.Paths.Map() {
+		path.Servers = nil
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid openapi spec %s: %w", conf.Spec, err)
+	}
+
+	return &Validator{
+		router:            router,
+		validateResponses: conf.ValidateResponses,
+	}, nil
+}
+
+// Operation is a request matched to an operation of the spec
+type Operation struct {
+	input             *openapi3filter.RequestValidationInput
+	validateResponses bool
+}
+
+// ValidateRequest finds the operation of the request and validates its parameters and body.
+// The body is put back in the request once read. Requests that don't match the spec are
+// reported with an *Error.
+func (v *Validator) ValidateRequest(req *http.Request) (*Operation, error) {
+	route, pathParams, err := v.router.FindRoute(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, routers.ErrMethodNotAllowed):
+			return nil, &Error{Status: http.StatusMethodNotAllowed, Message: "method not allowed by the openapi spec"}
+		case errors.Is(err, routers.ErrPathNotFound):
+			return nil, &Error{Status: http.StatusNotFound, Message: "path not found in the openapi spec"}
+		}
+		return nil, &Error{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// Clients are authenticated by the gateway, and the body is forwarded as sent
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+			SkipSettingDefaults: true,
+		},
+	}
+	if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+		var requestErr *openapi3filter.RequestError
+		if errors.As(err, &requestErr) && requestErr.Reason == "reading failed" {
+			// Let the caller tell failures to read the body, such as exceeded limits, apart
+			return nil, requestErr.Err
+		}
+		return nil, &Error{Status: http.StatusBadRequest, Message: "request does not match the openapi spec", Details: details(err, "", "")}
+	}
+
+	return &Operation{input: input, validateResponses: v.validateResponses}, nil
+}
+
+// ValidateResponse checks the response of the operation against the spec when response
+// validation is enabled. The body is put back in the response once read. Encoded responses and
+// responses larger than MaxResponseSize are not validated.
+func (o *Operation) ValidateResponse(ctx context.Context, resp *http.Response) error {
+	if o == nil || !o.validateResponses || o.input.Request.Method == http.MethodHead {
+		return nil
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil
+	}
+
+	var data []byte
+	if resp.Body != nil && resp.Body != http.NoBody {
+		var err error
+		data, err = io.ReadAll(io.LimitReader(resp.Body, MaxResponseSize+1))
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		if err != nil || len(data) > MaxResponseSize {
+			return nil
+		}
+	}
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: o.input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(bytes.NewReader(data)),
+		Options:                &openapi3filter.Options{MultiError: true},
+	}
+	if err := openapi3filter.ValidateResponse(ctx, input); err != nil {
+		return &Error{Status: http.StatusBadGateway, Message: "response does not match the openapi spec", Details: details(err, "", "")}
+	}
+	return nil
+}
+
+// Error describes why a request or response doesn't match the spec
+type Error struct {
+	Status  int      `json:"-"`
+	Message string   `json:"error"`
+	Details []Detail `json:"details,omitempty"`
+}
+
+// Detail is a single violation of the spec
+type Detail struct {
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	reasons := make([]string, 0, len(e.Details))
+	for _, d := range e.Details {
+		reasons = append(reasons, strings.TrimSpace(fmt.Sprintf("%s %s: %s", d.In, d.Name, d.Reason)))
+	}
+	if len(reasons) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(reasons, "; ")
+}
+
+// Write answers the request with the error as JSON
+func (e *Error) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// details flattens the errors reported by the validation, naming the parameter or the
+// location in the body each of them is about
+func details(err error, in, name string) []Detail {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var out []Detail
+		for _, e := range multi {
+			out = append(out, details(e, in, name)...)
+		}
+		return out
+	}
+
+	switch e := err.(type) {
+	case *openapi3filter.RequestError:
+		switch {
+		case e.Parameter != nil:
+			in, name = e.Parameter.In, e.Parameter.Name
+		case e.RequestBody != nil:
+			in = "body"
+		}
+		if e.Err != nil {
+			return details(e.Err, in, name)
+		}
+		return []Detail{{In: in, Name: name, Reason: e.Reason}}
+	case *openapi3filter.ResponseError:
+		if e.Err != nil {
+			return details(e.Err, "response", name)
+		}
+		return []Detail{{In: "response", Name: name, Reason: e.Reason}}
+	case *openapi3filter.ParseError:
+		if cause := e.RootCause(); cause != nil && cause != err {
+			return details(cause, in, name)
+		}
+		return []Detail{{In: in, Name: name, Reason: e.Error()}}
+	case *openapi3.SchemaError:
+		if pointer := e.JSONPointer(); len(pointer) > 0 && (in == "body" || in == "response") {
+			name = "/" + strings.Join(pointer, "/")
+		}
+		return []Detail{{In: in, Name: name, Reason: e.Reason}}
+	}
+	return []Detail{{In: in, Name: name, Reason: err.Error()}}
+}
+
+// readCloser reads a body put back together and closes the original one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
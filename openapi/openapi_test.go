@@ -0,0 +1,212 @@
+package openapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+const spec = `
+openapi: 3.0.3
+info:
+  title: Pets
+  version: "1.0"
+servers:
+  - url: https://pets.example.com/v1
+paths:
+  /pets:
+    post:
+      parameters:
+        - name: X-Tenant
+          in: header
+          required: true
+          schema:
+            type: string
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              properties:
+                name:
+                  type: string
+                age:
+                  type: integer
+                  minimum: 0
+      responses:
+        "201":
+          description: Created
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [id]
+                properties:
+                  id:
+                    type: integer
+  /pets/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+        - name: fields
+          in: query
+          schema:
+            type: string
+            enum: [short, full]
+      responses:
+        "200":
+          description: OK
+`
+
+func newValidator(t *testing.T, validateResponses bool) *Validator {
+	path := filepath.Join(t.TempDir(), "openapi.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(spec), 0o600))
+
+	v, err := NewValidator(&config.OpenAPIConfig{Spec: path, ValidateResponses: validateResponses})
+	require.NoError(t, err)
+	return v
+}
+
+func TestValidateRequest(t *testing.T) {
+	v := newValidator(t, false)
+
+	testCases := []struct {
+		name     string
+		method   string
+		url      string
+		headers  map[string]string
+		body     string
+		status   int
+		expected []Detail
+	}{
+		{"Valid body", http.MethodPost, "/pets", map[string]string{"X-Tenant": "acme"}, `{"name":"Rex","age":3}`, 0, nil},
+		{"Valid path and query", http.MethodGet, "/pets/42?fields=short", nil, "", 0, nil},
+		{"Invalid path parameter", http.MethodGet, "/pets/rex", nil, "", http.StatusBadRequest, []Detail{{In: "path", Name: "id"}}},
+		{"Invalid query parameter", http.MethodGet, "/pets/42?fields=all", nil, "", http.StatusBadRequest, []Detail{{In: "query", Name: "fields"}}},
+		{"Missing header", http.MethodPost, "/pets", nil, `{"name":"Rex"}`, http.StatusBadRequest, []Detail{{In: "header", Name: "X-Tenant"}}},
+		{"Invalid body", http.MethodPost, "/pets", map[string]string{"X-Tenant": "acme"}, `{"age":-1}`, http.StatusBadRequest, []Detail{{In: "body", Name: "/age"}, {In: "body"}}},
+		{"Unknown path", http.MethodGet, "/owners", nil, "", http.StatusNotFound, nil},
+		{"Method not allowed", http.MethodDelete, "/pets/42", nil, "", http.StatusMethodNotAllowed, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			operation, err := v.ValidateRequest(req)
+			if tc.status == 0 {
+				require.NoError(t, err)
+				require.NotNil(t, operation)
+
+				// The body is still there for the upstream
+				data, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+				require.Equal(t, tc.body, string(data))
+				return
+			}
+
+			validationErr, ok := err.(*Error)
+			require.True(t, ok, "expected a validation error, got %v", err)
+			require.Equal(t, tc.status, validationErr.Status)
+			require.Len(t, validationErr.Details, len(tc.expected))
+			for i, detail := range tc.expected {
+				require.Equal(t, detail.In, validationErr.Details[i].In)
+				if detail.Name != "" {
+					require.Equal(t, detail.Name, validationErr.Details[i].Name)
+				}
+				require.NotEmpty(t, validationErr.Details[i].Reason)
+			}
+
+			w := httptest.NewRecorder()
+			validationErr.Write(w)
+			require.Equal(t, tc.status, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	v := newValidator(t, true)
+
+	newOperation := func() *Operation {
+		req := httptest.NewRequest(http.MethodPost, "/pets", strings.NewReader(`{"name":"Rex"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Tenant", "acme")
+		operation, err := v.ValidateRequest(req)
+		require.NoError(t, err)
+		return operation
+	}
+	newResponse := func(body string) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	resp := newResponse(`{"id":1}`)
+	require.NoError(t, newOperation().ValidateResponse(context.Background(), resp))
+
+	resp = newResponse(`{"id":"one"}`)
+	err := newOperation().ValidateResponse(context.Background(), resp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "/id")
+
+	// The body is returned to the client whatever the outcome
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"one"}`, string(data))
+
+	// Responses aren't checked unless enabled
+	var none *Operation
+	require.NoError(t, none.ValidateResponse(context.Background(), newResponse(`{}`)))
+	operation, err := newValidator(t, false).ValidateRequest(httptest.NewRequest(http.MethodGet, "/pets/1", nil))
+	require.NoError(t, err)
+	require.NoError(t, operation.ValidateResponse(context.Background(), &http.Response{StatusCode: http.StatusTeapot, Header: http.Header{}, Body: http.NoBody}))
+}
+
+func TestNewValidatorErrors(t *testing.T) {
+	_, err := NewValidator(&config.OpenAPIConfig{})
+	require.Error(t, err)
+
+	_, err = NewValidator(&config.OpenAPIConfig{Spec: filepath.Join(t.TempDir(), "missing.yaml")})
+	require.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "invalid.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("openapi: 3.0.3\ninfo: {}\npaths: {}\n"), 0o600))
+	_, err = NewValidator(&config.OpenAPIConfig{Spec: path})
+	require.Error(t, err)
+}
+
+func TestNewValidatorFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write([]byte(spec))
+	}))
+	defer server.Close()
+
+	v, err := NewValidator(&config.OpenAPIConfig{Spec: server.URL + "/openapi.yaml"})
+	require.NoError(t, err)
+	_, err = v.ValidateRequest(httptest.NewRequest(http.MethodGet, "/pets/42", nil))
+	require.NoError(t, err)
+}
@@ -0,0 +1,119 @@
+package response
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const DefaultRedirectStatus = http.StatusFound
+
+// Redirect is a compiled redirect configuration
+type Redirect struct {
+	status    int
+	scheme    string
+	host      string
+	path      string
+	dropQuery bool
+}
+
+// NewRedirect compiles a redirect configuration. The status code defaults to 302 and must be one
+// of 301, 302, 307 or 308.
+func NewRedirect(conf *config.RedirectConfig) (*Redirect, error) {
+	status := conf.StatusCode
+	if status == 0 {
+		status = DefaultRedirectStatus
+	}
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("invalid redirect status code %d, expected 301, 302, 307 or 308", conf.StatusCode)
+	}
+
+	switch conf.Scheme {
+	case "", "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid redirect scheme %s, expected http or https", conf.Scheme)
+	}
+
+	return &Redirect{
+		status:    status,
+		scheme:    conf.Scheme,
+		host:      conf.Host,
+		path:      conf.Path,
+		dropQuery: conf.DropQuery,
+	}, nil
+}
+
+// Location returns the URL the request is redirected to. path is the request path after the strip
+// and rewrite settings of the route, it is kept unless the redirect replaces it. expand substitutes
+// the path parameters referenced in the host and path.
+func (r *Redirect) Location(req *http.Request, path string, expand func(string) string) string {
+	location := url.URL{Scheme: r.scheme, Host: req.Host, Path: path}
+	if location.Scheme == "" {
+		location.Scheme = "http"
+		if req.TLS != nil {
+			location.Scheme = "https"
+		}
+	}
+	if r.host != "" {
+		location.Host = expand(r.host)
+	}
+	if r.path != "" {
+		location.Path = expand(r.path)
+	}
+	if !r.dropQuery {
+		location.RawQuery = req.URL.RawQuery
+	}
+	return location.String()
+}
+
+// Serve redirects the request to its location
+func (r *Redirect) Serve(w http.ResponseWriter, req *http.Request, path string, expand func(string) string) {
+	http.Redirect(w, req, r.Location(req, path, expand), r.status)
+}
+
+// Static is a compiled fixed response
+type Static struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// NewStatic compiles a fixed response configuration. The status code defaults to 200.
+func NewStatic(conf *config.StaticResponseConfig) (*Static, error) {
+	status := conf.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("invalid response status code %d", conf.StatusCode)
+	}
+
+	header := make(http.Header, len(conf.Headers))
+	for k, v := range conf.Headers {
+		header.Set(k, v)
+	}
+	if conf.Body != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType([]byte(conf.Body)))
+	}
+
+	return &Static{status: status, header: header, body: []byte(conf.Body)}, nil
+}
+
+// ServeHTTP writes the fixed response, omitting the body for HEAD requests
+func (s *Static) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for k, v := range s.header {
+		w.Header()[k] = v
+	}
+	if len(s.body) > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.body)))
+	}
+	w.WriteHeader(s.status)
+	if req.Method != http.MethodHead {
+		w.Write(s.body)
+	}
+}
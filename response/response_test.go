@@ -0,0 +1,96 @@
+package response
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, "{id}", "42")
+	}
+
+	testCases := []struct {
+		name     string
+		conf     config.RedirectConfig
+		url      string
+		tls      bool
+		path     string
+		expected string
+	}{
+		{"Keep request URL", config.RedirectConfig{}, "http://example.com/a?b=c", false, "/a", "http://example.com/a?b=c"},
+		{"Keep TLS scheme", config.RedirectConfig{}, "https://example.com/a", true, "/a", "https://example.com/a"},
+		{"Rewritten path", config.RedirectConfig{Scheme: "https"}, "http://example.com/old/a", false, "/new/a", "https://example.com/new/a"},
+		{"Replace host and path", config.RedirectConfig{Host: "api.example.org", Path: "/users/{id}"}, "http://example.com/u/42?x=1", false, "/u/42", "http://api.example.org/users/42?x=1"},
+		{"Drop query", config.RedirectConfig{Path: "/", DropQuery: true}, "http://example.com/a?b=c", false, "/a", "http://example.com/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redirect, err := NewRedirect(&tc.conf)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if !tc.tls {
+				req.TLS = nil
+			} else if req.TLS == nil {
+				req.TLS = &tls.ConnectionState{}
+			}
+			require.Equal(t, tc.expected, redirect.Location(req, tc.path, expand))
+		})
+	}
+}
+
+func TestRedirectServe(t *testing.T) {
+	redirect, err := NewRedirect(&config.RedirectConfig{StatusCode: http.StatusPermanentRedirect, Path: "/v2/orders"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/v1/orders?page=2", nil)
+	w := httptest.NewRecorder()
+	redirect.Serve(w, req, req.URL.Path, func(s string) string { return s })
+
+	require.Equal(t, http.StatusPermanentRedirect, w.Code)
+	require.Equal(t, "http://example.com/v2/orders?page=2", w.Header().Get("Location"))
+
+	_, err = NewRedirect(&config.RedirectConfig{StatusCode: http.StatusOK})
+	require.Error(t, err)
+	_, err = NewRedirect(&config.RedirectConfig{Scheme: "ftp"})
+	require.Error(t, err)
+}
+
+func TestStatic(t *testing.T) {
+	static, err := NewStatic(&config.StaticResponseConfig{
+		StatusCode: http.StatusServiceUnavailable,
+		Headers:    map[string]string{"Retry-After": "120"},
+		Body:       "<html><body>Back soon</body></html>",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	static.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/shop", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "120", w.Header().Get("Retry-After"))
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, "<html><body>Back soon</body></html>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	static.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "http://example.com/shop", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "35", w.Header().Get("Content-Length"))
+	require.Empty(t, w.Body.String())
+
+	static, err = NewStatic(&config.StaticResponseConfig{})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	static.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	_, err = NewStatic(&config.StaticResponseConfig{StatusCode: 42})
+	require.Error(t, err)
+}
@@ -25,3 +25,73 @@ type ErrUnsupportedServiceType struct {
 func (e ErrUnsupportedServiceType) Error() string {
 	return fmt.Sprintf("unsupported service type: %s", e.serviceType)
 }
+
+// ErrInvalidUpstreamHost is returned when a rewritten host would carry a path or user info
+type ErrInvalidUpstreamHost struct {
+	Host string
+}
+
+func (e ErrInvalidUpstreamHost) Error() string {
+	return fmt.Sprintf("invalid upstream host '%s'", e.Host)
+}
+
+// ErrRouteConflict is returned when a path of a route cannot be routed unambiguously
+// alongside the paths of the other routes
+type ErrRouteConflict struct {
+	Name   string
+	Path   string
+	Reason string
+}
+
+func (e ErrRouteConflict) Error() string {
+	return fmt.Sprintf("path '%s' of route '%s' conflicts with existing routes: %s", e.Path, e.Name, e.Reason)
+}
+
+type ErrRouteExists struct {
+	Name string
+}
+
+func (e ErrRouteExists) Error() string {
+	return fmt.Sprintf("route with name '%s' already exists", e.Name)
+}
+
+type ErrRouteNotFound struct {
+	Name string
+}
+
+func (e ErrRouteNotFound) Error() string {
+	return fmt.Sprintf("route with name '%s' not found", e.Name)
+}
+
+// ErrInvalidRoute is returned when a route loaded from the registry backend fails validation
+type ErrInvalidRoute struct {
+	Name string
+	Err  error
+}
+
+func (e ErrInvalidRoute) Error() string {
+	return fmt.Sprintf("invalid route '%s': %s", e.Name, e.Err)
+}
+
+func (e ErrInvalidRoute) Unwrap() error {
+	return e.Err
+}
+
+// ErrRouteNotSplit is returned when updating the traffic split of a route that has none
+type ErrRouteNotSplit struct {
+	Name string
+}
+
+func (e ErrRouteNotSplit) Error() string {
+	return fmt.Sprintf("route '%s' does not split traffic", e.Name)
+}
+
+// ErrServiceInUse is returned when removing a service that is still referenced by a route
+type ErrServiceInUse struct {
+	Name  string
+	Route string
+}
+
+func (e ErrServiceInUse) Error() string {
+	return fmt.Sprintf("service with name '%s' is referenced by route '%s'", e.Name, e.Route)
+}
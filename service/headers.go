@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	HeaderActionSet    = "set"
+	HeaderActionAppend = "append"
+	HeaderActionRemove = "remove"
+	HeaderActionRename = "rename"
+)
+
+// HeaderRules transform the headers of the upstream request and of the response returned to
+// the client. Rules are applied in order.
+type HeaderRules struct {
+	Request  []HeaderRule `json:"request,omitempty" yaml:"request,omitempty"`
+	Response []HeaderRule `json:"response,omitempty" yaml:"response,omitempty"`
+}
+
+// HeaderRule sets, appends, removes or renames a header. Value is a template for set and append
+// rules, To is the new name for rename rules.
+type HeaderRule struct {
+	Action string `json:"action" yaml:"action"`
+	Name   string `json:"name" yaml:"name"`
+	Value  string `json:"value,omitempty" yaml:"value,omitempty"`
+	To     string `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+// Validate reports whether the header rules compile
+func (hr *HeaderRules) Validate() error {
+	_, err := compileHeaderRules(hr)
+	return err
+}
+
+type headerRule struct {
+	action string
+	name   string
+	value  *template
+	to     string
+}
+
+// HeaderTransformer applies compiled header rules
+type HeaderTransformer struct {
+	request  []headerRule
+	response []headerRule
+}
+
+// compileHeaderRules validates and compiles the header rules
+func compileHeaderRules(hr *HeaderRules) (*HeaderTransformer, error) {
+	var err error
+	t := &HeaderTransformer{}
+	if t.request, err = compileHeaderRuleList("request", hr.Request); err != nil {
+		return nil, err
+	}
+	if t.response, err = compileHeaderRuleList("response", hr.Response); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func compileHeaderRuleList(kind string, rules []HeaderRule) ([]headerRule, error) {
+	compiled := make([]headerRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%s header rule requires a name", kind)
+		}
+		c := headerRule{action: rule.Action, name: http.CanonicalHeaderKey(rule.Name)}
+
+		switch rule.Action {
+		case HeaderActionSet, HeaderActionAppend:
+			value, err := compileTemplate(rule.Value)
+			if err != nil {
+				return nil, err
+			}
+			c.value = value
+		case HeaderActionRemove:
+			if rule.Value != "" || rule.To != "" {
+				return nil, fmt.Errorf("%s header rule removing %s cannot set a value", kind, rule.Name)
+			}
+		case HeaderActionRename:
+			if rule.To == "" {
+				return nil, fmt.Errorf("%s header rule renaming %s requires a new name", kind, rule.Name)
+			}
+			c.to = http.CanonicalHeaderKey(rule.To)
+		default:
+			return nil, fmt.Errorf("invalid %s header rule action %s, expected set, append, remove or rename", kind, rule.Action)
+		}
+
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// TransformRequest applies the request rules to the headers of the upstream request.
+// claims are those of the validated token, if any.
+func (t *HeaderTransformer) TransformRequest(headers http.Header, req *http.Request, claims map[string]interface{}) {
+	applyHeaderRules(t.request, headers, req, claims)
+}
+
+// TransformResponse applies the response rules to the headers returned to the client
+func (t *HeaderTransformer) TransformResponse(headers http.Header, req *http.Request, claims map[string]interface{}) {
+	applyHeaderRules(t.response, headers, req, claims)
+}
+
+func applyHeaderRules(rules []headerRule, headers http.Header, req *http.Request, claims map[string]interface{}) {
+	if len(rules) == 0 {
+		return
+	}
+	vars := templateVars{req: req, params: PathParams(req), claims: claims}
+	for _, rule := range rules {
+		switch rule.action {
+		case HeaderActionSet:
+			headers.Set(rule.name, rule.value.expand(vars, nil))
+		case HeaderActionAppend:
+			headers.Add(rule.name, rule.value.expand(vars, nil))
+		case HeaderActionRemove:
+			headers.Del(rule.name)
+		case HeaderActionRename:
+			if values, ok := headers[rule.name]; ok {
+				headers.Del(rule.name)
+				headers[rule.to] = values
+			}
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context carrying the ID of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID the gateway assigned to the request, or an empty string
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
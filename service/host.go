@@ -0,0 +1,149 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const regexHostPrefix = "~"
+
+// hostPattern matches request hosts against a wildcard or regex domain of a service.
+//
+//	*.example.com             matches any subdomain of example.com
+//	:tenant.example.com       matches a single label, captured as tenant
+//	~^(?P<tenant>[a-z]+)-eu\.example\.com$
+//	                          matches the regex, named groups are captured
+type hostPattern struct {
+	pattern  string
+	labels   []string
+	wildcard bool
+	regex    *regexp.Regexp
+	root     *trieNode
+}
+
+// normalizeHost lower-cases the host and strips the port and any trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func isHostPattern(domain string) bool {
+	if strings.HasPrefix(domain, regexHostPrefix) {
+		return true
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if strings.HasPrefix(label, "*") || strings.HasPrefix(label, string(paramPrefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDomain reports whether the domain of a service is a valid host or host pattern
+func ValidateDomain(domain string) error {
+	if !isHostPattern(domain) {
+		return nil
+	}
+	_, err := compileHostPattern(domain)
+	return err
+}
+
+func compileHostPattern(domain string) (*hostPattern, error) {
+	p := &hostPattern{pattern: domain, root: newTrieNode(domain)}
+
+	if strings.HasPrefix(domain, regexHostPrefix) {
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(domain, regexHostPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %s: %w", domain, err)
+		}
+		p.regex = re
+		return p, nil
+	}
+
+	// Patterns never carry a port, and a leading capture would parse as one
+	p.labels = strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	for i, label := range p.labels {
+		switch {
+		case label == "*" && i == 0 && len(p.labels) > 1:
+			p.wildcard = true
+		case strings.HasPrefix(label, string(paramPrefix)) && len(label) > 1:
+		case label == "" || strings.ContainsAny(label, "*:"):
+			return nil, fmt.Errorf("invalid host pattern %s", domain)
+		}
+	}
+	return p, nil
+}
+
+// literals returns the number of literal labels, used to try more specific patterns first
+func (p *hostPattern) literals() int {
+	n := 0
+	for _, label := range p.labels {
+		if label != "*" && label[0] != paramPrefix {
+			n++
+		}
+	}
+	return n
+}
+
+// match reports whether the normalized host matches the pattern and returns the captured values
+func (p *hostPattern) match(host string) (Params, bool) {
+	params := Params{}
+
+	if p.regex != nil {
+		m := p.regex.FindStringSubmatch(host)
+		if m == nil {
+			return nil, false
+		}
+		for i, name := range p.regex.SubexpNames() {
+			if name != "" {
+				params[name] = m[i]
+			}
+		}
+		return params, true
+	}
+
+	labels := strings.Split(host, ".")
+	patternLabels := p.labels
+	if p.wildcard {
+		// The wildcard stands for one or more leading labels
+		patternLabels = p.labels[1:]
+		if len(labels) <= len(patternLabels) {
+			return nil, false
+		}
+		labels = labels[len(labels)-len(patternLabels):]
+	} else if len(labels) != len(patternLabels) {
+		return nil, false
+	}
+
+	for i, label := range patternLabels {
+		if label[0] == paramPrefix {
+			params[label[1:]] = labels[i]
+		} else if label != labels[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// sortHostPatterns orders label patterns before regex patterns and patterns with more literal
+// labels before patterns with fewer. Regex patterns keep their registration order.
+func sortHostPatterns(hosts []*hostPattern) {
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if (hosts[i].regex == nil) != (hosts[j].regex == nil) {
+			return hosts[i].regex == nil
+		}
+		if hosts[i].regex != nil {
+			return false
+		}
+		if hosts[i].literals() != hosts[j].literals() {
+			return hosts[i].literals() > hosts[j].literals()
+		}
+		// Single label captures are more specific than wildcards
+		return !hosts[i].wildcard && hosts[j].wildcard
+	})
+}
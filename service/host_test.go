@@ -0,0 +1,104 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostPatternMatch(t *testing.T) {
+	testCases := []struct {
+		description string
+		pattern     string
+		host        string
+		matches     bool
+		params      Params
+	}{
+		{description: "wildcard subdomain", pattern: "*.example.com", host: "api.example.com", matches: true, params: Params{}},
+		{description: "wildcard spans labels", pattern: "*.example.com", host: "a.b.example.com", matches: true, params: Params{}},
+		{description: "wildcard requires a label", pattern: "*.example.com", host: "example.com"},
+		{description: "capture", pattern: ":tenant.example.com", host: "acme.example.com", matches: true, params: Params{"tenant": "acme"}},
+		{description: "capture is a single label", pattern: ":tenant.example.com", host: "a.b.example.com"},
+		{description: "capture and wildcard", pattern: "*.:region.example.com", host: "api.eu.example.com", matches: true, params: Params{"region": "eu"}},
+		{description: "literal mismatch", pattern: ":tenant.example.com", host: "acme.example.org"},
+		{description: "regex", pattern: `~^(?P<tenant>[a-z]+)-eu\.example\.com$`, host: "acme-eu.example.com", matches: true, params: Params{"tenant": "acme"}},
+		{description: "regex is case-insensitive", pattern: `~^api\.EXAMPLE\.com$`, host: "api.example.com", matches: true, params: Params{}},
+		{description: "regex mismatch", pattern: `~^api\.example\.com$`, host: "www.example.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p, err := compileHostPattern(tc.pattern)
+			require.NoError(t, err)
+
+			params, ok := p.match(normalizeHost(tc.host))
+			require.Equal(t, tc.matches, ok)
+			require.Equal(t, tc.params, params)
+		})
+	}
+}
+
+func TestValidateDomain(t *testing.T) {
+	testCases := []struct {
+		description string
+		domain      string
+		expectErr   bool
+	}{
+		{description: "plain domain", domain: "example.com"},
+		{description: "wildcard", domain: "*.example.com"},
+		{description: "capture", domain: ":tenant.example.com"},
+		{description: "regex", domain: `~^[a-z]+\.example\.com$`},
+		{description: "invalid regex", domain: "~[", expectErr: true},
+		{description: "wildcard alone", domain: "*", expectErr: true},
+		{description: "wildcard not first", domain: "api.*.example.com", expectErr: true},
+		{description: "partial wildcard", domain: "*api.example.com", expectErr: true},
+		{description: "empty capture", domain: ":.example.com", expectErr: true},
+		{description: "empty label", domain: "*..example.com", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := ValidateDomain(tc.domain)
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	testCases := []struct {
+		host     string
+		expected string
+	}{
+		{host: "Example.COM", expected: "example.com"},
+		{host: "example.com:8080", expected: "example.com"},
+		{host: "example.com.", expected: "example.com"},
+		{host: "[::1]:8080", expected: "::1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			require.Equal(t, tc.expected, normalizeHost(tc.host))
+		})
+	}
+}
+
+func TestSortHostPatterns(t *testing.T) {
+	var hosts []*hostPattern
+	for _, domain := range []string{`~^b\.example\.com$`, "*.example.com", `~^a\.example\.com$`, ":tenant.example.com", "*.api.example.com"} {
+		p, err := compileHostPattern(domain)
+		require.NoError(t, err)
+		hosts = append(hosts, p)
+	}
+
+	sortHostPatterns(hosts)
+
+	var patterns []string
+	for _, p := range hosts {
+		patterns = append(patterns, p.pattern)
+	}
+	require.Equal(t, []string{"*.api.example.com", ":tenant.example.com", "*.example.com", `~^b\.example\.com$`, `~^a\.example\.com$`}, patterns)
+}
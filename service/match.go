@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// MatchConditions narrow down the requests routed to a backend service beyond host and path.
+// All conditions must match.
+type MatchConditions struct {
+	Methods []string       `json:"methods,omitempty" yaml:"methods,omitempty"`
+	Headers []ValueMatcher `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Query   []ValueMatcher `json:"query,omitempty" yaml:"query,omitempty"`
+	Cookies []ValueMatcher `json:"cookies,omitempty" yaml:"cookies,omitempty"`
+}
+
+// ValueMatcher matches a named header, query parameter or cookie. Exact and Regex are
+// compared against the value; Present requires the value to be present (true) or absent (false).
+type ValueMatcher struct {
+	Name    string `json:"name" yaml:"name"`
+	Exact   string `json:"exact,omitempty" yaml:"exact,omitempty"`
+	Regex   string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Present *bool  `json:"present,omitempty" yaml:"present,omitempty"`
+}
+
+// Validate reports whether the match conditions compile
+func (mc *MatchConditions) Validate() error {
+	_, err := compileMatchConditions(mc)
+	return err
+}
+
+type valueMatcher struct {
+	name    string
+	exact   string
+	regex   *regexp.Regexp
+	present *bool
+}
+
+type requestMatcher struct {
+	methods map[string]bool
+	headers []valueMatcher
+	query   []valueMatcher
+	cookies []valueMatcher
+}
+
+// compileMatchConditions validates and compiles the match conditions
+func compileMatchConditions(mc *MatchConditions) (*requestMatcher, error) {
+	m := &requestMatcher{}
+
+	if len(mc.Methods) > 0 {
+		m.methods = make(map[string]bool, len(mc.Methods))
+		for _, method := range mc.Methods {
+			m.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	var err error
+	if m.headers, err = compileValueMatchers("header", mc.Headers); err != nil {
+		return nil, err
+	}
+	if m.query, err = compileValueMatchers("query", mc.Query); err != nil {
+		return nil, err
+	}
+	if m.cookies, err = compileValueMatchers("cookie", mc.Cookies); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func compileValueMatchers(kind string, matchers []ValueMatcher) ([]valueMatcher, error) {
+	compiled := make([]valueMatcher, 0, len(matchers))
+	for _, vm := range matchers {
+		if vm.Name == "" {
+			return nil, fmt.Errorf("%s matcher requires a name", kind)
+		}
+		if vm.Exact != "" && vm.Regex != "" {
+			return nil, fmt.Errorf("%s matcher %s cannot set both exact and regex", kind, vm.Name)
+		}
+
+		c := valueMatcher{name: vm.Name, exact: vm.Exact, present: vm.Present}
+		if kind == "header" {
+			c.name = http.CanonicalHeaderKey(vm.Name)
+		}
+		if vm.Regex != "" {
+			re, err := regexp.Compile(vm.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for %s matcher %s: %w", kind, vm.Name, err)
+			}
+			c.regex = re
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func (vm *valueMatcher) matches(values []string) bool {
+	if vm.present != nil {
+		if *vm.present != (len(values) > 0) {
+			return false
+		}
+		if !*vm.present {
+			return true
+		}
+	}
+	if vm.exact == "" && vm.regex == nil {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if vm.exact != "" && v == vm.exact {
+			return true
+		}
+		if vm.regex != nil && vm.regex.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *requestMatcher) matches(r *http.Request) bool {
+	return m.mismatch(r) == ""
+}
+
+// mismatch describes the first condition the request fails, or returns an empty string if the
+// request matches
+func (m *requestMatcher) mismatch(r *http.Request) string {
+	method := r.Method
+	// CORS preflights are routed like the request they ask permission for
+	if requested := r.Header.Get("Access-Control-Request-Method"); method == http.MethodOptions && requested != "" {
+		method = requested
+	}
+	if m.methods != nil && !m.methods[method] {
+		return fmt.Sprintf("method %s is not allowed", method)
+	}
+	for i := range m.headers {
+		if !m.headers[i].matches(r.Header.Values(m.headers[i].name)) {
+			return fmt.Sprintf("header %s does not match", m.headers[i].name)
+		}
+	}
+	if len(m.query) > 0 {
+		query := r.URL.Query()
+		for i := range m.query {
+			if !m.query[i].matches(query[m.query[i].name]) {
+				return fmt.Sprintf("query parameter %s does not match", m.query[i].name)
+			}
+		}
+	}
+	for i := range m.cookies {
+		var values []string
+		if cookie, err := r.Cookie(m.cookies[i].name); err == nil {
+			values = []string{cookie.Value}
+		}
+		if !m.cookies[i].matches(values) {
+			return fmt.Sprintf("cookie %s does not match", m.cookies[i].name)
+		}
+	}
+	return ""
+}
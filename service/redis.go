@@ -22,6 +22,14 @@ func NewRedisClient(ctx context.Context, uri string) (*redis.Client, error) {
 	return client, nil
 }
 
+// redisKey prefixes a key with the configured namespace, if any
+func redisKey(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + ":" + name
+}
+
 // RedisRegistry implements the ServiceRegistry interface using Redis as a backend storage
 type RedisRegistry struct {
 	*baseRegistry
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"github.com/Frontman-Labs/frontman/auth"
 	"github.com/Frontman-Labs/frontman/config"
 	"sync"
 )
@@ -12,13 +13,25 @@ type ServiceRegistry interface {
 	UpdateService(service *BackendService) error
 	RemoveService(name string) error
 	GetServices() []*BackendService
+	AddRoute(route *Route) error
+	UpdateRoute(route *Route) error
+	UpdateRouteSplit(name string, backends []WeightedBackend) (*Route, error)
+	RemoveRoute(name string) error
+	GetRoutes() []*Route
 	GetTrie() *RoutingTrie
+	GetSecrets() SecretRegistry
 }
 
 type baseRegistry struct {
 	mutex       *sync.RWMutex
 	services    []*BackendService
+	routes      []*Route
+	routeStore  routeStore
 	routingTrie *RoutingTrie
+	secrets     SecretRegistry
+	// nonces is shared by the HMAC validators of the services, so replays stay rejected when
+	// a service is updated and its validator rebuilt
+	nonces *auth.NonceCache
 }
 
 func NewServiceRegistry(ctx context.Context, serviceType string, config *config.Config) (ServiceRegistry, error) {
@@ -28,8 +41,14 @@ func NewServiceRegistry(ctx context.Context, serviceType string, config *config.
 		err error
 	)
 
+	// Templates of the stored services are compiled while they are loaded
+	if config != nil {
+		SetTemplateEnv(config.GlobalConfig.TemplateEnv)
+	}
+
 	baseReg := baseRegistry{
-		mutex: &mu,
+		mutex:  &mu,
+		nonces: auth.NewNonceCache(),
 		routingTrie: &RoutingTrie{
 			mutex: &mu,
 		},
@@ -41,11 +60,26 @@ func NewServiceRegistry(ctx context.Context, serviceType string, config *config.
 		if err != nil {
 			return nil, err
 		}
+		baseReg.secrets = newRedisSecretRegistry(ctx, redisClient, config.GlobalConfig.RedisNamespace)
+		baseReg.routeStore = &redisRouteStore{client: redisClient, ctx: ctx, key: redisKey(config.GlobalConfig.RedisNamespace, "routes")}
 		reg, err = NewRedisRegistry(ctx, redisClient, config.GlobalConfig.RedisNamespace, &baseReg)
 		if err != nil {
 			return nil, err
 		}
 	case "yaml":
+		secretsFile := config.GlobalConfig.SecretsFile
+		if secretsFile == "" {
+			secretsFile = "secrets.yaml"
+		}
+		baseReg.secrets, err = newYAMLSecretRegistry(secretsFile)
+		if err != nil {
+			return nil, err
+		}
+		routesFile := config.GlobalConfig.RoutesFile
+		if routesFile == "" {
+			routesFile = "routes.yaml"
+		}
+		baseReg.routeStore = &yamlRouteStore{filename: routesFile}
 		reg, err = NewYAMLServiceRegistry(config.GlobalConfig.ServicesFile, &baseReg)
 		if err != nil {
 			return nil, err
@@ -55,18 +89,52 @@ func NewServiceRegistry(ctx context.Context, serviceType string, config *config.
 		if err != nil {
 			return nil, err
 		}
+		secretsCollection := config.GlobalConfig.MongoSecretsName
+		if secretsCollection == "" {
+			secretsCollection = "secrets"
+		}
+		baseReg.secrets = newMongoSecretRegistry(ctx, mongoClient, config.GlobalConfig.MongoDatabaseName, secretsCollection)
+		routesCollection := config.GlobalConfig.MongoRoutesName
+		if routesCollection == "" {
+			routesCollection = "routes"
+		}
+		baseReg.routeStore = &mongoRouteStore{
+			collection: mongoClient.Database(config.GlobalConfig.MongoDatabaseName).Collection(routesCollection),
+			ctx:        ctx,
+		}
 		reg, err = NewMongoServiceRegistry(ctx, mongoClient, config.GlobalConfig.MongoDatabaseName, config.GlobalConfig.MongoCollectionName, &baseReg)
 		if err != nil {
 			return nil, err
 		}
 	case "memory": // For testing
+		baseReg.secrets = newMemorySecretRegistry()
+		baseReg.routeStore = memoryRouteStore{}
 		reg = NewMemoryServiceRegistry(&baseReg)
 	default:
 		return nil, ErrUnsupportedServiceType{serviceType: serviceType}
 	}
 
+	for _, s := range baseReg.services {
+		s.setSecretStore(baseReg.secrets, baseReg.nonces)
+	}
+
+	routes, err := baseReg.routeStore.loadRoutes()
+	if err != nil {
+		return nil, err
+	}
+	// Stored routes may have been written without going through the API
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			return nil, ErrInvalidRoute{Name: route.Name, Err: err}
+		}
+		route.Init()
+	}
+	baseReg.routes = routes
+
 	// Initialise routing trie
-	baseReg.routingTrie.BuildRoutes(reg.GetServices())
+	if err := baseReg.routingTrie.BuildRoutes(reg.GetServices(), routes...); err != nil {
+		return nil, err
+	}
 
 	return reg, nil
 }
@@ -86,6 +154,11 @@ func (r *baseRegistry) addService(service *BackendService, apply func() error) e
 		}
 	}
 
+	if err := r.routingTrie.checkRoutes(append(old, service), r.routes); err != nil {
+		return err
+	}
+
+	service.setSecretStore(r.secrets, r.nonces)
 	r.services = append(r.services, service)
 	err := apply()
 	if err != nil {
@@ -93,7 +166,7 @@ func (r *baseRegistry) addService(service *BackendService, apply func() error) e
 		return err
 	}
 
-	r.routingTrie.BuildRoutes(r.services)
+	r.routingTrie.BuildRoutes(r.services, r.routes...)
 
 	return nil
 }
@@ -103,6 +176,13 @@ func (r *baseRegistry) updateService(service *BackendService, apply func() error
 
 	for i, s := range r.services {
 		if s.Name == service.Name {
+			candidate := r.getServices()
+			candidate[i] = service
+			if err := r.routingTrie.checkRoutes(candidate, r.routes); err != nil {
+				return err
+			}
+
+			service.setSecretStore(r.secrets, r.nonces)
 			r.services[i] = service
 			err := apply()
 			if err != nil {
@@ -110,7 +190,7 @@ func (r *baseRegistry) updateService(service *BackendService, apply func() error
 				return err
 			}
 
-			r.routingTrie.BuildRoutes(r.services)
+			r.routingTrie.BuildRoutes(r.services, r.routes...)
 			return nil
 		}
 	}
@@ -121,6 +201,14 @@ func (r *baseRegistry) updateService(service *BackendService, apply func() error
 func (r *baseRegistry) removeService(name string, apply func() error) error {
 	old := r.getServices()
 
+	for _, route := range r.routes {
+		for _, referenced := range route.serviceNames() {
+			if referenced == name {
+				return ErrServiceInUse{Name: name, Route: route.Name}
+			}
+		}
+	}
+
 	for i, s := range r.services {
 		if s.Name == name {
 			r.services = append(r.services[:i], r.services[i+1:]...)
@@ -130,7 +218,7 @@ func (r *baseRegistry) removeService(name string, apply func() error) error {
 				return err
 			}
 
-			r.routingTrie.BuildRoutes(r.services)
+			r.routingTrie.BuildRoutes(r.services, r.routes...)
 			return nil
 		}
 	}
@@ -141,3 +229,131 @@ func (r *baseRegistry) removeService(name string, apply func() error) error {
 func (r *baseRegistry) GetTrie() *RoutingTrie {
 	return r.routingTrie
 }
+
+func (r *baseRegistry) GetSecrets() SecretRegistry {
+	return r.secrets
+}
+
+func (r *baseRegistry) getRoutes() []*Route {
+	routes := make([]*Route, len(r.routes))
+	copy(routes, r.routes)
+	return routes
+}
+
+// AddRoute adds a new route to the registry
+func (r *baseRegistry) AddRoute(route *Route) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.routes {
+		if existing.Name == route.Name {
+			return ErrRouteExists{Name: route.Name}
+		}
+	}
+
+	routes := append(r.getRoutes(), route)
+	if err := r.routingTrie.checkRoutes(r.services, routes); err != nil {
+		return err
+	}
+	if err := r.routeStore.saveRoute(route, routes); err != nil {
+		return err
+	}
+
+	r.routes = routes
+	r.routingTrie.BuildRoutes(r.services, r.routes...)
+	return nil
+}
+
+// UpdateRoute updates an existing route in the registry
+func (r *baseRegistry) UpdateRoute(route *Route) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.Name == route.Name {
+			routes := r.getRoutes()
+			routes[i] = route
+			if err := r.routingTrie.checkRoutes(r.services, routes); err != nil {
+				return err
+			}
+			if err := r.routeStore.saveRoute(route, routes); err != nil {
+				return err
+			}
+
+			r.routes = routes
+			r.routingTrie.BuildRoutes(r.services, r.routes...)
+			return nil
+		}
+	}
+
+	return ErrRouteNotFound{Name: route.Name}
+}
+
+// UpdateRouteSplit replaces the backends of the traffic split of a route, keeping its other
+// settings. The route is read and written under the registry lock so concurrent updates
+// cannot overwrite each other.
+func (r *baseRegistry) UpdateRouteSplit(name string, backends []WeightedBackend) (*Route, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.Name != name {
+			continue
+		}
+		if existing.Split == nil {
+			return nil, ErrRouteNotSplit{Name: name}
+		}
+
+		route := *existing
+		split := *existing.Split
+		split.Backends = backends
+		route.Split = &split
+		if err := route.Validate(); err != nil {
+			return nil, ErrInvalidRoute{Name: name, Err: err}
+		}
+
+		routes := r.getRoutes()
+		routes[i] = &route
+		if err := r.routingTrie.checkRoutes(r.services, routes); err != nil {
+			return nil, err
+		}
+		if err := r.routeStore.saveRoute(&route, routes); err != nil {
+			return nil, err
+		}
+
+		r.routes = routes
+		r.routingTrie.BuildRoutes(r.services, r.routes...)
+		return &route, nil
+	}
+
+	return nil, ErrRouteNotFound{Name: name}
+}
+
+// RemoveRoute removes a route from the registry
+func (r *baseRegistry) RemoveRoute(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.Name == name {
+			routes := append(r.getRoutes()[:i], r.routes[i+1:]...)
+			if err := r.routeStore.deleteRoute(name, routes); err != nil {
+				return err
+			}
+
+			r.routes = routes
+			r.routingTrie.BuildRoutes(r.services, r.routes...)
+			return nil
+		}
+	}
+
+	return ErrRouteNotFound{Name: name}
+}
+
+// GetRoutes returns a copy of the current list of routes
+func (r *baseRegistry) GetRoutes() []*Route {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.getRoutes()
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLRewrite rewrites the upstream request of a backend service or route. Templates reference
+// path parameters and host captures as {name} and request headers as {header.Name}.
+type URLRewrite struct {
+	// Match is an optional regular expression applied to the path. Path is then the replacement
+	// of the matches and may also reference capture groups as $1 or ${name}.
+	Match string `json:"match,omitempty" yaml:"match,omitempty"`
+	// Path is the template of the upstream path
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Host is the template of the Host header sent upstream
+	Host  string        `json:"host,omitempty" yaml:"host,omitempty"`
+	Query *QueryRewrite `json:"query,omitempty" yaml:"query,omitempty"`
+}
+
+// QueryRewrite changes the query parameters of the upstream request. Parameters are renamed
+// first, then removed, then added. Added values are templates and replace existing values.
+type QueryRewrite struct {
+	Add    map[string]string `json:"add,omitempty" yaml:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty" yaml:"remove,omitempty"`
+	Rename map[string]string `json:"rename,omitempty" yaml:"rename,omitempty"`
+}
+
+// Validate reports whether the rewrite compiles
+func (rw *URLRewrite) Validate() error {
+	_, err := compileRewrite(rw)
+	return err
+}
+
+type rewriter struct {
+	match    *regexp.Regexp
+	path     *template
+	host     *template
+	query    *QueryRewrite
+	addQuery map[string]*template
+}
+
+// compileRewrite validates and compiles the rewrite
+func compileRewrite(rw *URLRewrite) (*rewriter, error) {
+	c := &rewriter{query: rw.Query}
+	var err error
+
+	if rw.Match != "" {
+		if rw.Path == "" {
+			return nil, fmt.Errorf("rewrite match requires a path")
+		}
+		if c.match, err = regexp.Compile(rw.Match); err != nil {
+			return nil, fmt.Errorf("invalid rewrite match %s: %w", rw.Match, err)
+		}
+	}
+	if rw.Path != "" {
+		if c.path, err = compileTemplate(rw.Path); err != nil {
+			return nil, err
+		}
+	}
+	if rw.Host != "" {
+		if c.host, err = compileTemplate(rw.Host); err != nil {
+			return nil, err
+		}
+	}
+
+	if rw.Query != nil {
+		c.addQuery = make(map[string]*template, len(rw.Query.Add))
+		for name, value := range rw.Query.Add {
+			if name == "" {
+				return nil, fmt.Errorf("added query parameter requires a name")
+			}
+			if c.addQuery[name], err = compileTemplate(value); err != nil {
+				return nil, err
+			}
+		}
+		for from, to := range rw.Query.Rename {
+			if from == "" || to == "" {
+				return nil, fmt.Errorf("renamed query parameter requires both names")
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// legacyRewrite converts the rewriteMatch and rewriteReplace settings to a rewrite
+func legacyRewrite(match, replace string) (*URLRewrite, error) {
+	if match == "" && replace == "" {
+		return nil, nil
+	}
+	if match == "" || replace == "" {
+		return nil, fmt.Errorf("rewriteMatch and rewriteReplace must be set together")
+	}
+	return &URLRewrite{Match: match, Path: replace}, nil
+}
+
+// validateRewrites checks the rewrite of a service or route, which either uses the rewrite
+// block or the rewriteMatch and rewriteReplace settings
+func validateRewrites(rw *URLRewrite, match, replace string) error {
+	legacy, err := legacyRewrite(match, replace)
+	if err != nil {
+		return err
+	}
+	if rw != nil && legacy != nil {
+		return fmt.Errorf("rewrite cannot be combined with rewriteMatch and rewriteReplace")
+	}
+	if legacy != nil {
+		rw = legacy
+	}
+	if rw == nil {
+		return nil
+	}
+	return rw.Validate()
+}
+
+// apply returns the upstream path, host and raw query of the request. The host is empty
+// if it is not rewritten.
+func (c *rewriter) apply(req *http.Request, params Params, path string) (string, string, string, error) {
+	vars := templateVars{req: req, params: params}
+
+	if c.path != nil {
+		if c.match != nil {
+			// Expanded values must not be read as capture group references
+			path = c.match.ReplaceAllString(path, c.path.expand(vars, func(kind placeholderKind, value string) string {
+				return escapeDollar(escapePathValue(kind, value))
+			}))
+		} else {
+			path = c.path.expand(vars, escapePathValue)
+		}
+	}
+
+	var host string
+	if c.host != nil {
+		host = c.host.expand(vars, nil)
+		if strings.ContainsAny(host, "/@") || strings.Contains(host, "..") {
+			return "", "", "", ErrInvalidUpstreamHost{Host: host}
+		}
+	}
+
+	rawQuery := req.URL.RawQuery
+	if c.query != nil {
+		query := req.URL.Query()
+		for from, to := range c.query.Rename {
+			if values, ok := query[from]; ok {
+				query.Del(from)
+				query[to] = values
+			}
+		}
+		for _, name := range c.query.Remove {
+			query.Del(name)
+		}
+		for name, value := range c.addQuery {
+			query.Set(name, value.expand(vars, nil))
+		}
+		rawQuery = query.Encode()
+	}
+
+	return path, host, rawQuery, nil
+}
+
+// escapePathValue escapes a value expanded into the upstream path so it cannot add segments or
+// climb up the path. Path parameters keep their slashes, as a catch-all spans several segments
+// of the request path.
+func escapePathValue(kind placeholderKind, value string) string {
+	if kind != paramPart {
+		return escapePathSegment(value)
+	}
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = escapePathSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func escapePathSegment(segment string) string {
+	switch segment {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return url.PathEscape(segment)
+}
+
+func escapeDollar(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// RewriteRequest returns the upstream path, host and raw query of a request matching the route.
+// path is the request path after the strip settings of the route. The host is empty if the
+// route does not rewrite it. Values expanded into the path are escaped, and a rewritten host
+// carrying a path or user info is rejected with ErrInvalidUpstreamHost.
+func (r *Route) RewriteRequest(req *http.Request, params Params, path string) (string, string, string, error) {
+	if r.rewriter == nil {
+		return path, "", req.URL.RawQuery, nil
+	}
+	return r.rewriter.apply(req, params, path)
+}
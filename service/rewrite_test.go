@@ -0,0 +1,114 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRequest(t *testing.T) {
+	testCases := []struct {
+		description  string
+		rewrite      *URLRewrite
+		url          string
+		header       map[string]string
+		params       Params
+		expectedPath string
+		expectedHost string
+		expectedErr  error
+	}{
+		{
+			description:  "path parameters",
+			rewrite:      &URLRewrite{Path: "/v2/{tenant}/items/{id}"},
+			url:          "http://localhost/items/7",
+			params:       Params{"tenant": "acme", "id": "7"},
+			expectedPath: "/v2/acme/items/7",
+		},
+		{
+			description:  "catch-all keeps its segments",
+			rewrite:      &URLRewrite{Path: "/files/{path}"},
+			url:          "http://localhost/static/css/app.css",
+			params:       Params{"path": "css/app.css"},
+			expectedPath: "/files/css/app.css",
+		},
+		{
+			description:  "catch-all cannot climb up the path",
+			rewrite:      &URLRewrite{Path: "/files/{path}"},
+			url:          "http://localhost/static/x",
+			params:       Params{"path": "../../admin"},
+			expectedPath: "/files/%2E%2E/%2E%2E/admin",
+		},
+		{
+			description:  "header values are a single segment",
+			rewrite:      &URLRewrite{Path: "/regions/{header.X-Region}/items"},
+			url:          "http://localhost/items",
+			header:       map[string]string{"X-Region": "eu/../../admin?x=1"},
+			expectedPath: "/regions/eu%2F..%2F..%2Fadmin%3Fx=1/items",
+		},
+		{
+			description:  "header value of dots",
+			rewrite:      &URLRewrite{Path: "/regions/{header.X-Region}"},
+			url:          "http://localhost/items",
+			header:       map[string]string{"X-Region": ".."},
+			expectedPath: "/regions/%2E%2E",
+		},
+		{
+			description:  "escaped values in a regular expression replacement",
+			rewrite:      &URLRewrite{Match: "^/api/(.*)$", Path: "/{header.X-Version}/$1"},
+			url:          "http://localhost/api/users",
+			header:       map[string]string{"X-Version": "v$1 2"},
+			expectedPath: "/v$1%202/users",
+		},
+		{
+			description:  "host template",
+			rewrite:      &URLRewrite{Host: "{header.X-Region}.items.internal"},
+			url:          "http://localhost/items",
+			header:       map[string]string{"X-Region": "eu"},
+			expectedPath: "/items",
+			expectedHost: "eu.items.internal",
+		},
+		{
+			description: "host with a path",
+			rewrite:     &URLRewrite{Host: "{header.X-Region}.items.internal"},
+			url:         "http://localhost/items",
+			header:      map[string]string{"X-Region": "evil.com/"},
+			expectedErr: ErrInvalidUpstreamHost{Host: "evil.com/.items.internal"},
+		},
+		{
+			description: "host with user info",
+			rewrite:     &URLRewrite{Host: "{header.X-Region}.items.internal"},
+			url:         "http://localhost/items",
+			header:      map[string]string{"X-Region": "user@evil.com"},
+			expectedErr: ErrInvalidUpstreamHost{Host: "user@evil.com.items.internal"},
+		},
+		{
+			description: "host with dot segments",
+			rewrite:     &URLRewrite{Host: "{header.X-Region}.items.internal"},
+			url:         "http://localhost/items",
+			header:      map[string]string{"X-Region": "eu."},
+			expectedErr: ErrInvalidUpstreamHost{Host: "eu..items.internal"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			route := &Route{Name: "items", Rewrite: tc.rewrite}
+			route.setRewriter()
+			require.NotNil(t, route.rewriter)
+
+			req := httptest.NewRequest("GET", tc.url, nil)
+			for name, value := range tc.header {
+				req.Header.Set(name, value)
+			}
+			path, host, _, err := route.RewriteRequest(req, tc.params, req.URL.Path)
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPath, path)
+			require.Equal(t, tc.expectedHost, host)
+		})
+	}
+}
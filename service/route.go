@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Route exposes a backend service on a set of hosts and paths. Several routes can reference the
+// same service, sharing its load balancer and connection pool. A service with a path is also
+// exposed by an implicit route built from its own domain, path, match and rewrite settings.
+type Route struct {
+	Name string `json:"name" yaml:"name"`
+	// Service names the backend service of the route. Split divides the traffic between several
+	// backend services instead.
+	Service        string           `json:"service,omitempty" yaml:"service,omitempty"`
+	Split          *TrafficSplit    `json:"split,omitempty" yaml:"split,omitempty"`
+	Hosts          []string         `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Paths          []string         `json:"paths" yaml:"paths"`
+	Priority       int              `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Match          *MatchConditions `json:"match,omitempty" yaml:"match,omitempty"`
+	StripPath      bool             `json:"stripPath" yaml:"stripPath"`
+	RewriteMatch   string           `json:"rewriteMatch,omitempty" yaml:"rewriteMatch,omitempty"`
+	RewriteReplace string           `json:"rewriteReplace,omitempty" yaml:"rewriteReplace,omitempty"`
+	Rewrite        *URLRewrite      `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+
+	rewriter *rewriter
+	matcher  *requestMatcher
+}
+
+// implicitRoute returns the route a service with a path is exposed on
+func implicitRoute(bs *BackendService) *Route {
+	route := &Route{
+		Name:           bs.Name,
+		Service:        bs.Name,
+		Paths:          []string{bs.Path},
+		Priority:       bs.Priority,
+		Match:          bs.Match,
+		StripPath:      bs.StripPath,
+		RewriteMatch:   bs.RewriteMatch,
+		RewriteReplace: bs.RewriteReplace,
+		Rewrite:        bs.Rewrite,
+		matcher:        bs.matcher,
+	}
+	if bs.Domain != "" {
+		route.Hosts = []string{bs.Domain}
+	}
+	if route.Match != nil && route.matcher == nil {
+		route.setMatcher()
+	}
+	route.setRewriter()
+	return route
+}
+
+// Validate reports whether the route is complete and its patterns compile
+func (r *Route) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is a required field")
+	}
+	if (r.Service == "") == (r.Split == nil) {
+		return fmt.Errorf("either service or split is required")
+	}
+	if r.Split != nil {
+		if err := r.Split.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(r.Paths) == 0 {
+		return fmt.Errorf("at least one path is required")
+	}
+	for _, host := range r.Hosts {
+		if err := ValidateDomain(host); err != nil {
+			return err
+		}
+	}
+	if r.Match != nil {
+		if err := r.Match.Validate(); err != nil {
+			return err
+		}
+	}
+	return validateRewrites(r.Rewrite, r.RewriteMatch, r.RewriteReplace)
+}
+
+// serviceNames returns the names of the backend services the route references
+func (r *Route) serviceNames() []string {
+	if r.Split == nil {
+		return []string{r.Service}
+	}
+	names := make([]string, 0, len(r.Split.Backends))
+	for _, b := range r.Split.Backends {
+		names = append(names, b.Service)
+	}
+	return names
+}
+
+func (r *Route) setMatcher() {
+	if r.Match == nil {
+		return
+	}
+
+	matcher, err := compileMatchConditions(r.Match)
+	if err != nil {
+		log.Printf("Error adding match conditions to route: %s: %s", r.Name, err.Error())
+	} else {
+		r.matcher = matcher
+	}
+}
+
+func (r *Route) setRewriter() {
+	rw := r.Rewrite
+	if rw == nil {
+		legacy, err := legacyRewrite(r.RewriteMatch, r.RewriteReplace)
+		if err != nil {
+			log.Printf("Error adding rewrite to route: %s: %s", r.Name, err.Error())
+			return
+		}
+		rw = legacy
+	}
+	if rw == nil {
+		return
+	}
+
+	rewriter, err := compileRewrite(rw)
+	if err != nil {
+		log.Printf("Error adding rewrite to route: %s: %s", r.Name, err.Error())
+	} else {
+		r.rewriter = rewriter
+	}
+}
+
+// matchesRequest reports whether the request satisfies the match conditions of the route.
+// A route whose conditions failed to compile never matches.
+func (r *Route) matchesRequest(req *http.Request) bool {
+	return r.mismatch(req) == ""
+}
+
+// mismatch describes why the request does not satisfy the match conditions of the route
+func (r *Route) mismatch(req *http.Request) string {
+	if r.Match == nil {
+		return ""
+	}
+	if r.matcher == nil {
+		return "match conditions failed to compile"
+	}
+	return r.matcher.mismatch(req)
+}
+
+func (r *Route) Init() {
+	r.setMatcher()
+	r.setRewriter()
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+// routeStore persists the routes of a registry. routes holds the full list of routes after
+// the change, for stores that rewrite all routes at once.
+type routeStore interface {
+	loadRoutes() ([]*Route, error)
+	saveRoute(route *Route, routes []*Route) error
+	deleteRoute(name string, routes []*Route) error
+}
+
+// memoryRouteStore keeps routes in the registry only
+type memoryRouteStore struct{}
+
+func (memoryRouteStore) loadRoutes() ([]*Route, error) {
+	return nil, nil
+}
+
+func (memoryRouteStore) saveRoute(*Route, []*Route) error {
+	return nil
+}
+
+func (memoryRouteStore) deleteRoute(string, []*Route) error {
+	return nil
+}
+
+// yamlRouteStore stores routes as a list in a YAML file
+type yamlRouteStore struct {
+	filename string
+}
+
+func (s *yamlRouteStore) loadRoutes() ([]*Route, error) {
+	data, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var routes []*Route
+	err = yaml.Unmarshal(data, &routes)
+	return routes, err
+}
+
+func (s *yamlRouteStore) saveRoute(_ *Route, routes []*Route) error {
+	return s.writeToFile(routes)
+}
+
+func (s *yamlRouteStore) deleteRoute(_ string, routes []*Route) error {
+	return s.writeToFile(routes)
+}
+
+func (s *yamlRouteStore) writeToFile(routes []*Route) error {
+	data, err := yaml.Marshal(routes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.filename, data, 0644)
+}
+
+// redisRouteStore stores routes as JSON in a Redis hash keyed by route name
+type redisRouteStore struct {
+	client *redis.Client
+	ctx    context.Context
+	key    string
+}
+
+func (s *redisRouteStore) loadRoutes() ([]*Route, error) {
+	entries, err := s.client.HGetAll(s.ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*Route, 0, len(entries))
+	for _, data := range entries {
+		var route Route
+		if err := json.Unmarshal([]byte(data), &route); err != nil {
+			return nil, err
+		}
+		routes = append(routes, &route)
+	}
+	// Hashes are unordered, keep the routes in a stable order
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Name < routes[j].Name
+	})
+	return routes, nil
+}
+
+func (s *redisRouteStore) saveRoute(route *Route, _ []*Route) error {
+	data, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.ctx, s.key, route.Name, data).Err()
+}
+
+func (s *redisRouteStore) deleteRoute(name string, _ []*Route) error {
+	return s.client.HDel(s.ctx, s.key, name).Err()
+}
+
+// mongoRouteStore stores routes as documents in a MongoDB collection
+type mongoRouteStore struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+func (s *mongoRouteStore) loadRoutes() ([]*Route, error) {
+	cursor, err := s.collection.Find(s.ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(s.ctx)
+
+	var routes []*Route
+	for cursor.Next(s.ctx) {
+		var route Route
+		if err := cursor.Decode(&route); err != nil {
+			return nil, err
+		}
+		routes = append(routes, &route)
+	}
+	return routes, cursor.Err()
+}
+
+func (s *mongoRouteStore) saveRoute(route *Route, _ []*Route) error {
+	_, err := s.collection.ReplaceOne(s.ctx, bson.M{"name": route.Name}, route, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoRouteStore) deleteRoute(name string, _ []*Route) error {
+	_, err := s.collection.DeleteOne(s.ctx, bson.M{"name": name})
+	return err
+}
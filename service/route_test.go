@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteValidate(t *testing.T) {
+	split := &TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}}
+
+	testCases := []struct {
+		description string
+		route       Route
+		expectErr   bool
+	}{
+		{description: "service", route: Route{Name: "r", Service: "a", Paths: []string{"/a"}}},
+		{description: "split", route: Route{Name: "r", Split: split, Paths: []string{"/a"}}},
+		{description: "host pattern", route: Route{Name: "r", Service: "a", Paths: []string{"/a"}, Hosts: []string{"*.example.com"}}},
+		{description: "missing name", route: Route{Service: "a", Paths: []string{"/a"}}, expectErr: true},
+		{description: "missing service and split", route: Route{Name: "r", Paths: []string{"/a"}}, expectErr: true},
+		{description: "both service and split", route: Route{Name: "r", Service: "a", Split: split, Paths: []string{"/a"}}, expectErr: true},
+		{description: "invalid split", route: Route{Name: "r", Split: &TrafficSplit{}, Paths: []string{"/a"}}, expectErr: true},
+		{description: "missing paths", route: Route{Name: "r", Service: "a"}, expectErr: true},
+		{description: "invalid host", route: Route{Name: "r", Service: "a", Paths: []string{"/a"}, Hosts: []string{"api.*.example.com"}}, expectErr: true},
+		{description: "invalid rewrite regex", route: Route{Name: "r", Service: "a", Paths: []string{"/a"}, RewriteMatch: "(", RewriteReplace: "/b"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.route.Validate()
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRouteServiceNames(t *testing.T) {
+	r := &Route{Name: "r", Service: "a"}
+	require.Equal(t, []string{"a"}, r.serviceNames())
+
+	r = &Route{Name: "r", Split: &TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}, {Service: "b"}}}}
+	require.Equal(t, []string{"a", "b"}, r.serviceNames())
+}
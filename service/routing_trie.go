@@ -1,112 +1,509 @@
 package service
 
 import (
+	"context"
 	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
 
+const (
+	paramPrefix    = ':'
+	catchAllPrefix = '*'
+)
+
 type RoutingTrie struct {
-	mutex *sync.RWMutex
-	root  *Route
+	mutex   *sync.RWMutex
+	root    *trieNode
+	domains map[string]*trieNode
+	hosts   []*hostPattern
 }
 
-type Route struct {
+type trieNode struct {
 	label    string
 	isEnd    bool
-	service  *BackendService
-	children map[string]*Route
+	routes   []routeEntry
+	children map[string]*trieNode
+	// param matches any single segment and catchAll matches the remaining segments.
+	// Their labels hold the name the segments are captured as.
+	param    *trieNode
+	catchAll *trieNode
 }
 
-func (rt *RoutingTrie) BuildRoutes(services []*BackendService) {
-	rt.root = &Route{
-		label:    "",
-		children: make(map[string]*Route),
+// routeEntry is a route along with the backend service it resolved to when the trie was built
+type routeEntry struct {
+	route   *Route
+	service *BackendService
+	split   []weightedService
+	host    string
+	path    string
+}
+
+// Params holds the path parameters captured while routing a request
+type Params map[string]string
+
+// RouteMatch is the result of routing a request
+type RouteMatch struct {
+	Route   *Route
+	Service *BackendService
+	Params  Params
+	// MatchedPath is the prefix of the request path matched by the literal and parameter
+	// segments of the route path.
+	MatchedPath string
+}
+
+// Expand replaces {name} placeholders in the template with the captured parameters.
+// Placeholders without a matching parameter are left untouched.
+func (p Params) Expand(template string) string {
+	if len(p) == 0 || !strings.Contains(template, "{") {
+		return template
 	}
+	pairs := make([]string, 0, len(p)*2)
+	for name, value := range p {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+type paramsKey struct{}
+
+// WithParams returns a copy of the context carrying the path parameters
+func WithParams(ctx context.Context, params Params) context.Context {
+	return context.WithValue(ctx, paramsKey{}, params)
+}
+
+// PathParams returns the path parameters captured while routing the request
+func PathParams(r *http.Request) Params {
+	params, _ := r.Context().Value(paramsKey{}).(Params)
+	return params
+}
+
+func newTrieNode(label string) *trieNode {
+	return &trieNode{
+		label:    label,
+		children: make(map[string]*trieNode),
+	}
+}
 
+// BuildRoutes indexes the implicit routes of the services and the explicit routes
+func (rt *RoutingTrie) BuildRoutes(services []*BackendService, routes ...*Route) error {
+	built, err := buildTrie(services, routes)
+	if err != nil {
+		return err
+	}
+
+	rt.root = built.root
+	rt.domains = built.domains
+	rt.hosts = built.hosts
+	return nil
+}
+
+// checkRoutes reports conflicts between the routes of the services without changing the trie
+func (rt *RoutingTrie) checkRoutes(services []*BackendService, routes []*Route) error {
+	_, err := buildTrie(services, routes)
+	return err
+}
+
+func buildTrie(services []*BackendService, routes []*Route) (*RoutingTrie, error) {
+	rt := &RoutingTrie{
+		root:    newTrieNode(""),
+		domains: make(map[string]*trieNode),
+	}
+
+	byName := make(map[string]*BackendService, len(services))
 	for _, s := range services {
-		rt.insertNode(s)
+		byName[s.Name] = s
+	}
+	// A service without a path is only exposed through the routes referencing it
+	for _, s := range services {
+		if s.Path == "" {
+			continue
+		}
+		if err := rt.insertRoute(routeEntry{route: implicitRoute(s), service: s}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range routes {
+		entry := routeEntry{route: r}
+		for _, name := range r.serviceNames() {
+			s, ok := byName[name]
+			if !ok {
+				return nil, ErrServiceNotFound{Name: name}
+			}
+			entry.service = s
+		}
+		if r.Split != nil {
+			for _, b := range r.Split.Backends {
+				entry.split = append(entry.split, weightedService{service: byName[b.Service], weight: b.Weight})
+			}
+			entry.service = nil
+		}
+		if err := rt.insertRoute(entry); err != nil {
+			return nil, err
+		}
 	}
+
+	sortHostPatterns(rt.hosts)
+	return rt, nil
 }
 
+// FindBackendService returns the backend service for the request
 func (rt *RoutingTrie) FindBackendService(r *http.Request) *BackendService {
+	match := rt.Lookup(r)
+	if match == nil {
+		return nil
+	}
+	return match.Service
+}
+
+// Lookup routes the request to the first route in evaluation order whose match conditions the
+// request satisfies.
+func (rt *RoutingTrie) Lookup(r *http.Request) *RouteMatch {
+	rt.mutex.RLock()
+	defer rt.mutex.RUnlock()
+
+	segments := splitPath(r.URL.Path)
+	for _, c := range rt.candidates(r, segments) {
+		if c.entry.route.matchesRequest(r) {
+			return c.match(r, segments)
+		}
+	}
+	return nil
+}
+
+// RouteCandidate describes a route considered for a request
+type RouteCandidate struct {
+	Route    string `json:"route"`
+	Service  string `json:"service"`
+	Host     string `json:"host,omitempty"`
+	Path     string `json:"path"`
+	Priority int    `json:"priority"`
+	Params   Params `json:"params,omitempty"`
+	Selected bool   `json:"selected"`
+	Reason   string `json:"reason"`
+}
+
+// Explain lists the routes considered for the request in evaluation order, along with the
+// reason each route was selected or passed over. At most one candidate is selected.
+func (rt *RoutingTrie) Explain(r *http.Request) []RouteCandidate {
 	rt.mutex.RLock()
 	defer rt.mutex.RUnlock()
 
-	node := rt.root
-	pathSegments := strings.Split(r.URL.Path, "/")
-	domain := strings.Split(r.Host, ":")[0]
+	segments := splitPath(r.URL.Path)
+	candidates := rt.candidates(r, segments)
 
-	// Check for domain-based routing first
-	domainNode, ok := node.children[domain]
-	if ok {
-		if domainNode.service != nil {
-			return domainNode.service
+	explained := make([]RouteCandidate, 0, len(candidates))
+	var selected *routeCandidate
+	for i, c := range candidates {
+		e := RouteCandidate{
+			Route:    c.entry.route.Name,
+			Service:  c.entry.selectService(r).Name,
+			Host:     c.entry.host,
+			Path:     c.entry.path,
+			Priority: c.entry.route.Priority,
+			Params:   c.params,
 		}
-		node = domainNode
+
+		mismatch := c.entry.route.mismatch(r)
+		switch {
+		case mismatch != "":
+			e.Reason = mismatch
+		case selected != nil:
+			e.Reason = "lower precedence than route " + selected.entry.route.Name
+		default:
+			selected = &candidates[i]
+			e.Selected = true
+			e.Reason = "first route in evaluation order whose conditions match"
+		}
+		explained = append(explained, e)
 	}
+	return explained
+}
 
-	// Check for path-based routing
-	for i, segment := range pathSegments {
-		if segment == "" {
-			continue
+// routeCandidate is a route reached by the request host and path
+type routeCandidate struct {
+	entry    routeEntry
+	params   Params
+	consumed int
+}
+
+func (c *routeCandidate) match(r *http.Request, segments []pathSegment) *RouteMatch {
+	match := &RouteMatch{Route: c.entry.route, Service: c.entry.selectService(r), Params: c.params}
+	if c.consumed > 0 {
+		match.MatchedPath = r.URL.Path[:segments[c.consumed-1].end]
+	}
+	return match
+}
+
+// candidates returns the routes reached by the request in evaluation order. Routes with a higher
+// priority come first. Among routes of equal priority, routes of an exact domain come before
+// routes of a matching host pattern and those before routes without a host. Within a host, routes
+// on longer matching paths come first, preferring literal segments over parameters and parameters
+// over catch-alls, and routes with match conditions come before routes without. Remaining ties
+// keep the registration order.
+func (rt *RoutingTrie) candidates(r *http.Request, segments []pathSegment) []routeCandidate {
+	host := normalizeHost(r.Host)
+
+	var candidates []routeCandidate
+	if domainNode, ok := rt.domains[host]; ok {
+		candidates = appendCandidates(candidates, domainNode, segments, Params{})
+	}
+	for _, pattern := range rt.hosts {
+		if captures, ok := pattern.match(host); ok {
+			candidates = appendCandidates(candidates, pattern.root, segments, captures)
 		}
+	}
+	candidates = appendCandidates(candidates, rt.root, segments, Params{})
 
-		child, ok := node.children[segment]
-		if !ok {
-			return node.service
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].entry.route.Priority > candidates[j].entry.route.Priority
+	})
+	return candidates
+}
+
+type pathSegment struct {
+	value string
+	// end is the offset of the end of the segment in the path
+	end int
+}
+
+func splitPath(path string) []pathSegment {
+	var segments []pathSegment
+	start := 0
+	for i := 0; i <= len(path); i++ {
+		if i == len(path) || path[i] == '/' {
+			if i > start {
+				segments = append(segments, pathSegment{value: path[start:i], end: i})
+			}
+			start = i + 1
 		}
+	}
+	return segments
+}
 
-		if child.service != nil && child.service.Domain == domain {
-			if i == len(pathSegments)-1 {
-				return child.service
+// nodeCandidate is a node reached by the request path. rank orders candidates by the number of
+// segments consumed, with catch-alls ranking just above the node they hang off.
+type nodeCandidate struct {
+	node     *trieNode
+	consumed int
+	rank     int
+	params   Params
+}
+
+// appendCandidates appends the routes reached by the path within the tree of a host.
+// params holds the host captures.
+func appendCandidates(candidates []routeCandidate, root *trieNode, segments []pathSegment, params Params) []routeCandidate {
+	var nodes []nodeCandidate
+	collectCandidates(root, segments, 0, params, &nodes)
+
+	// Deeper candidates win; candidates of equal depth keep the literal > parameter > catch-all
+	// order in which they were collected.
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].rank > nodes[j].rank
+	})
+
+	for _, c := range nodes {
+		for _, entry := range c.node.routes {
+			candidates = append(candidates, routeCandidate{entry: entry, params: c.params, consumed: c.consumed})
+		}
+	}
+	return candidates
+}
+
+func collectCandidates(node *trieNode, segments []pathSegment, i int, params Params, candidates *[]nodeCandidate) {
+	if i < len(segments) {
+		segment := segments[i]
+
+		if child, ok := node.children[segment.value]; ok {
+			collectCandidates(child, segments, i+1, params, candidates)
+		}
+
+		if node.param != nil {
+			// Path parameters shadow host captures of the same name
+			previous, shadowed := params[node.param.label]
+			params[node.param.label] = segment.value
+			collectCandidates(node.param, segments, i+1, params, candidates)
+			if shadowed {
+				params[node.param.label] = previous
+			} else {
+				delete(params, node.param.label)
 			}
-			node = child
-			continue
 		}
+	}
 
-		if child.service != nil && child.service.Domain == "" {
-			node = child
-			continue
+	if node.catchAll != nil && len(node.catchAll.routes) > 0 {
+		rest := make([]string, 0, len(segments)-i)
+		for _, s := range segments[i:] {
+			rest = append(rest, s.value)
 		}
+		captured := copyParams(params)
+		captured[node.catchAll.label] = strings.Join(rest, "/")
+		*candidates = append(*candidates, nodeCandidate{node: node.catchAll, consumed: i, rank: 2*i + 1, params: captured})
+	}
 
-		node = child
+	if len(node.routes) > 0 {
+		*candidates = append(*candidates, nodeCandidate{node: node, consumed: i, rank: 2 * i, params: copyParams(params)})
 	}
+}
 
-	return node.service
+func copyParams(params Params) Params {
+	c := make(Params, len(params))
+	for k, v := range params {
+		c[k] = v
+	}
+	return c
 }
 
-func (rt *RoutingTrie) insertNode(service *BackendService) {
-	node := rt.root
-	// Handle domain-based routing first
-	if service.Domain != "" {
-		domainNode, ok := node.children[service.Domain]
-		if !ok {
-			domainNode = &Route{
-				label:    service.Domain,
-				children: make(map[string]*Route),
+// insertRoute inserts the route for each of its hosts and paths
+func (rt *RoutingTrie) insertRoute(entry routeEntry) error {
+	route := entry.route
+	if route.Match != nil && route.matcher == nil {
+		route.setMatcher()
+	}
+
+	hosts := route.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	for _, host := range hosts {
+		for _, path := range route.Paths {
+			entry.host = host
+			entry.path = path
+			if err := rt.insertNode(host, path, entry); err != nil {
+				return err
 			}
-			node.children[service.Domain] = domainNode
 		}
-		node = domainNode
 	}
+	return nil
+}
 
-	segments := strings.Split(service.Path, "/")
-	for _, s := range segments {
-		if s == "" {
-			continue
-		}
-		child, ok := node.children[s]
-		if !ok {
-			child = &Route{
-				label:    s,
-				children: make(map[string]*Route),
+func (rt *RoutingTrie) insertNode(host string, path string, entry routeEntry) error {
+	node, err := rt.domainRoot(host)
+	if err != nil {
+		return err
+	}
+
+	name := entry.route.Name
+	segments := splitPath(path)
+	for i, segment := range segments {
+		s := segment.value
+		switch s[0] {
+		case paramPrefix:
+			param := s[1:]
+			if param == "" {
+				return ErrRouteConflict{Name: name, Path: path, Reason: "path parameter requires a name"}
 			}
-			node.children[s] = child
+			if node.param == nil {
+				node.param = newTrieNode(param)
+			} else if node.param.label != param {
+				return ErrRouteConflict{Name: name, Path: path, Reason: "parameter :" + param + " conflicts with :" + node.param.label}
+			}
+			node = node.param
+		case catchAllPrefix:
+			param := s[1:]
+			if param == "" {
+				return ErrRouteConflict{Name: name, Path: path, Reason: "catch-all requires a name"}
+			}
+			if i != len(segments)-1 {
+				return ErrRouteConflict{Name: name, Path: path, Reason: "catch-all must be the last segment"}
+			}
+			if node.catchAll == nil {
+				node.catchAll = newTrieNode(param)
+			} else if node.catchAll.label != param {
+				return ErrRouteConflict{Name: name, Path: path, Reason: "catch-all *" + param + " conflicts with *" + node.catchAll.label}
+			}
+			node = node.catchAll
+		default:
+			child, ok := node.children[s]
+			if !ok {
+				child = newTrieNode(s)
+				node.children[s] = child
+			}
+			node = child
+		}
+	}
+
+	// Requests cannot tell apart two routes with the same path, priority and match conditions
+	for _, other := range node.routes {
+		if other.route.Priority == entry.route.Priority && sameConditions(other.route.Match, entry.route.Match) {
+			return ErrRouteConflict{Name: name, Path: path, Reason: "route '" + other.route.Name + "' has the same path, priority and match conditions"}
 		}
-		node = child
 	}
 
 	node.isEnd = true
-	node.service = service
+
+	// Routes with match conditions are more specific than routes without,
+	// so they are evaluated first. Otherwise routes keep their registration order.
+	i := len(node.routes)
+	if entry.route.Match != nil {
+		for i = 0; i < len(node.routes); i++ {
+			if node.routes[i].route.Match == nil {
+				break
+			}
+		}
+	}
+	node.routes = append(node.routes, routeEntry{})
+	copy(node.routes[i+1:], node.routes[i:])
+	node.routes[i] = entry
+
+	return nil
+}
+
+// sameConditions reports whether two routes match the same requests. Methods are compared
+// regardless of their order and case.
+func sameConditions(a *MatchConditions, b *MatchConditions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return sameMethods(a.Methods, b.Methods) &&
+		reflect.DeepEqual(a.Headers, b.Headers) &&
+		reflect.DeepEqual(a.Query, b.Query) &&
+		reflect.DeepEqual(a.Cookies, b.Cookies)
+}
+
+func sameMethods(a []string, b []string) bool {
+	methods := make(map[string]bool, len(a))
+	for _, m := range a {
+		methods[strings.ToUpper(m)] = true
+	}
+	others := make(map[string]bool, len(b))
+	for _, m := range b {
+		if !methods[strings.ToUpper(m)] {
+			return false
+		}
+		others[strings.ToUpper(m)] = true
+	}
+	return len(methods) == len(others)
+}
+
+// domainRoot returns the tree the paths of a domain are inserted into, creating it if needed
+func (rt *RoutingTrie) domainRoot(domain string) (*trieNode, error) {
+	if domain == "" {
+		return rt.root, nil
+	}
+
+	if !isHostPattern(domain) {
+		host := normalizeHost(domain)
+		node, ok := rt.domains[host]
+		if !ok {
+			node = newTrieNode(host)
+			rt.domains[host] = node
+		}
+		return node, nil
+	}
+
+	for _, pattern := range rt.hosts {
+		if pattern.pattern == domain {
+			return pattern.root, nil
+		}
+	}
+	pattern, err := compileHostPattern(domain)
+	if err != nil {
+		return nil, err
+	}
+	rt.hosts = append(rt.hosts, pattern)
+	return pattern.root, nil
 }
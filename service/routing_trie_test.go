@@ -0,0 +1,163 @@
+package service
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTrieConflicts(t *testing.T) {
+	services := []*BackendService{{Name: "users"}, {Name: "orders"}}
+
+	testCases := []struct {
+		description string
+		routes      []*Route
+		conflict    bool
+	}{
+		{
+			description: "different paths",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/users"}},
+				{Name: "b", Service: "orders", Paths: []string{"/orders"}},
+			},
+		},
+		{
+			description: "identical routes",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/api"}},
+				{Name: "b", Service: "orders", Paths: []string{"/api"}},
+			},
+			conflict: true,
+		},
+		{
+			description: "identical methods in another order",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/api"}, Match: &MatchConditions{Methods: []string{"GET", "POST"}}},
+				{Name: "b", Service: "orders", Paths: []string{"/api"}, Match: &MatchConditions{Methods: []string{"post", "GET"}}},
+			},
+			conflict: true,
+		},
+		{
+			description: "different methods",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/api"}, Match: &MatchConditions{Methods: []string{"GET"}}},
+				{Name: "b", Service: "orders", Paths: []string{"/api"}, Match: &MatchConditions{Methods: []string{"POST"}}},
+			},
+		},
+		{
+			description: "different priorities",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/api"}},
+				{Name: "b", Service: "orders", Paths: []string{"/api"}, Priority: 1},
+			},
+		},
+		{
+			description: "different hosts",
+			routes: []*Route{
+				{Name: "a", Service: "users", Hosts: []string{"a.example.com"}, Paths: []string{"/api"}},
+				{Name: "b", Service: "orders", Hosts: []string{"b.example.com"}, Paths: []string{"/api"}},
+			},
+		},
+		{
+			description: "different parameter names",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/users/:id"}},
+				{Name: "b", Service: "orders", Paths: []string{"/users/:name/orders"}},
+			},
+			conflict: true,
+		},
+		{
+			description: "catch-all before the last segment",
+			routes: []*Route{
+				{Name: "a", Service: "users", Paths: []string{"/files/*path/raw"}},
+			},
+			conflict: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := buildTrie(services, tc.routes)
+			if tc.conflict {
+				require.ErrorAs(t, err, &ErrRouteConflict{})
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// newTestTrie builds a trie of the services and routes that can be looked up
+func newTestTrie(t *testing.T, services []*BackendService, routes ...*Route) *RoutingTrie {
+	t.Helper()
+	for _, r := range routes {
+		r.Init()
+	}
+	rt, err := buildTrie(services, routes)
+	require.NoError(t, err)
+	rt.mutex = &sync.RWMutex{}
+	return rt
+}
+
+func TestRoutingTrieLookup(t *testing.T) {
+	services := []*BackendService{
+		{Name: "api", Path: "/api"},
+		{Name: "users", Path: "/api/users/:id"},
+		{Name: "files", Path: "/files/*path"},
+		{Name: "tenants", Domain: ":tenant.example.com", Path: "/"},
+		{Name: "exact", Domain: "admin.example.com", Path: "/"},
+		{Name: "orders"},
+		{Name: "orders-v2"},
+	}
+	rt := newTestTrie(t, services,
+		&Route{Name: "orders-read", Service: "orders", Paths: []string{"/orders"}, Match: &MatchConditions{Methods: []string{"GET"}}},
+		&Route{Name: "orders-write", Service: "orders-v2", Paths: []string{"/orders"}},
+		&Route{Name: "orders-beta", Service: "orders-v2", Paths: []string{"/orders"}, Priority: 10, Match: &MatchConditions{
+			Headers: []ValueMatcher{{Name: "X-Beta", Exact: "1"}},
+		}},
+	)
+
+	testCases := []struct {
+		description   string
+		method        string
+		url           string
+		header        map[string]string
+		expectedRoute string
+		expectedPath  string
+		params        Params
+	}{
+		{description: "prefix", method: "GET", url: "http://localhost/api/items", expectedRoute: "api", expectedPath: "/api"},
+		{description: "path parameter", method: "GET", url: "http://localhost/api/users/42/orders", expectedRoute: "users", expectedPath: "/api/users/42", params: Params{"id": "42"}},
+		{description: "catch-all", method: "GET", url: "http://localhost/files/css/app.css", expectedRoute: "files", expectedPath: "/files", params: Params{"path": "css/app.css"}},
+		{description: "exact domain before host pattern", method: "GET", url: "http://admin.example.com/", expectedRoute: "exact"},
+		{description: "host capture", method: "GET", url: "http://acme.example.com:8080/", expectedRoute: "tenants", params: Params{"tenant": "acme"}},
+		{description: "match conditions first", method: "GET", url: "http://localhost/orders", expectedRoute: "orders-read", expectedPath: "/orders"},
+		{description: "fallback without conditions", method: "POST", url: "http://localhost/orders", expectedRoute: "orders-write", expectedPath: "/orders"},
+		{description: "priority", method: "GET", url: "http://localhost/orders", header: map[string]string{"X-Beta": "1"}, expectedRoute: "orders-beta", expectedPath: "/orders"},
+		{description: "no route", method: "GET", url: "http://localhost/unknown"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			for name, value := range tc.header {
+				req.Header.Set(name, value)
+			}
+
+			match := rt.Lookup(req)
+			if tc.expectedRoute == "" {
+				require.Nil(t, match)
+				return
+			}
+			require.NotNil(t, match)
+			require.Equal(t, tc.expectedRoute, match.Route.Name)
+			require.Equal(t, tc.expectedPath, match.MatchedPath)
+			if tc.params == nil {
+				tc.params = Params{}
+			}
+			require.Equal(t, tc.params, match.Params)
+		})
+	}
+}
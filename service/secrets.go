@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+// SecretRegistry manages the shared secrets used to verify signed requests
+type SecretRegistry interface {
+	auth.SecretStore
+	SetSecret(keyID string, secret string) error
+	RemoveSecret(keyID string) error
+	GetKeyIDs() []string
+}
+
+// memorySecretRegistry keeps secrets in memory. It is used by the memory registry and
+// as the cache of the YAML secrets file.
+type memorySecretRegistry struct {
+	mutex   sync.RWMutex
+	secrets map[string]string
+}
+
+func newMemorySecretRegistry() *memorySecretRegistry {
+	return &memorySecretRegistry{secrets: make(map[string]string)}
+}
+
+func (r *memorySecretRegistry) GetSecret(keyID string) (string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	secret, ok := r.secrets[keyID]
+	if !ok {
+		return "", auth.ErrUnknownKeyID
+	}
+	return secret, nil
+}
+
+func (r *memorySecretRegistry) SetSecret(keyID string, secret string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.secrets[keyID] = secret
+	return nil
+}
+
+func (r *memorySecretRegistry) RemoveSecret(keyID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.secrets[keyID]; !ok {
+		return auth.ErrUnknownKeyID
+	}
+	delete(r.secrets, keyID)
+	return nil
+}
+
+func (r *memorySecretRegistry) GetKeyIDs() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	ids := make([]string, 0, len(r.secrets))
+	for id := range r.secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// yamlSecretRegistry stores secrets in a YAML file mapping key IDs to secrets
+type yamlSecretRegistry struct {
+	*memorySecretRegistry
+	filename string
+}
+
+func newYAMLSecretRegistry(filename string) (*yamlSecretRegistry, error) {
+	r := &yamlSecretRegistry{
+		memorySecretRegistry: newMemorySecretRegistry(),
+		filename:             filename,
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, &r.secrets)
+	if err != nil {
+		return nil, err
+	}
+	if r.secrets == nil {
+		r.secrets = make(map[string]string)
+	}
+
+	return r, nil
+}
+
+func (r *yamlSecretRegistry) SetSecret(keyID string, secret string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	old, existed := r.secrets[keyID]
+	r.secrets[keyID] = secret
+	if err := r.writeToFile(); err != nil {
+		if existed {
+			r.secrets[keyID] = old
+		} else {
+			delete(r.secrets, keyID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *yamlSecretRegistry) RemoveSecret(keyID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	old, ok := r.secrets[keyID]
+	if !ok {
+		return auth.ErrUnknownKeyID
+	}
+	delete(r.secrets, keyID)
+	if err := r.writeToFile(); err != nil {
+		r.secrets[keyID] = old
+		return err
+	}
+	return nil
+}
+
+func (r *yamlSecretRegistry) writeToFile() error {
+	data, err := yaml.Marshal(r.secrets)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.filename, data, 0600)
+}
+
+// redisSecretRegistry stores secrets in a Redis hash shared by all gateway instances
+type redisSecretRegistry struct {
+	client *redis.Client
+	ctx    context.Context
+	key    string
+}
+
+func newRedisSecretRegistry(ctx context.Context, client *redis.Client, namespace string) *redisSecretRegistry {
+	return &redisSecretRegistry{client: client, ctx: ctx, key: redisKey(namespace, "secrets")}
+}
+
+func (r *redisSecretRegistry) GetSecret(keyID string) (string, error) {
+	secret, err := r.client.HGet(r.ctx, r.key, keyID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", auth.ErrUnknownKeyID
+	}
+	return secret, err
+}
+
+func (r *redisSecretRegistry) SetSecret(keyID string, secret string) error {
+	return r.client.HSet(r.ctx, r.key, keyID, secret).Err()
+}
+
+func (r *redisSecretRegistry) RemoveSecret(keyID string) error {
+	removed, err := r.client.HDel(r.ctx, r.key, keyID).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return auth.ErrUnknownKeyID
+	}
+	return nil
+}
+
+func (r *redisSecretRegistry) GetKeyIDs() []string {
+	ids, err := r.client.HKeys(r.ctx, r.key).Result()
+	if err != nil {
+		return nil
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// mongoSecretRegistry stores secrets as documents in a MongoDB collection
+type mongoSecretRegistry struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+type mongoSecret struct {
+	KeyID  string `bson:"keyId"`
+	Secret string `bson:"secret"`
+}
+
+func newMongoSecretRegistry(ctx context.Context, client *mongo.Client, database string, collection string) *mongoSecretRegistry {
+	return &mongoSecretRegistry{
+		collection: client.Database(database).Collection(collection),
+		ctx:        ctx,
+	}
+}
+
+func (r *mongoSecretRegistry) GetSecret(keyID string) (string, error) {
+	var secret mongoSecret
+	err := r.collection.FindOne(r.ctx, bson.M{"keyId": keyID}).Decode(&secret)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", auth.ErrUnknownKeyID
+	}
+	return secret.Secret, err
+}
+
+func (r *mongoSecretRegistry) SetSecret(keyID string, secret string) error {
+	_, err := r.collection.UpdateOne(r.ctx,
+		bson.M{"keyId": keyID},
+		bson.M{"$set": mongoSecret{KeyID: keyID, Secret: secret}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *mongoSecretRegistry) RemoveSecret(keyID string) error {
+	result, err := r.collection.DeleteOne(r.ctx, bson.M{"keyId": keyID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return auth.ErrUnknownKeyID
+	}
+	return nil
+}
+
+func (r *mongoSecretRegistry) GetKeyIDs() []string {
+	cursor, err := r.collection.Find(r.ctx, bson.M{})
+	if err != nil {
+		return nil
+	}
+	defer cursor.Close(r.ctx)
+
+	var ids []string
+	for cursor.Next(r.ctx) {
+		var secret mongoSecret
+		if err := cursor.Decode(&secret); err == nil {
+			ids = append(ids, secret.KeyID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
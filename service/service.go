@@ -1,42 +1,120 @@
 package service
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"regexp"
 	"time"
 
 	"github.com/Frontman-Labs/frontman/auth"
+	"github.com/Frontman-Labs/frontman/cache"
+	"github.com/Frontman-Labs/frontman/coalesce"
+	"github.com/Frontman-Labs/frontman/compression"
 	"github.com/Frontman-Labs/frontman/config"
+	"github.com/Frontman-Labs/frontman/cors"
+	"github.com/Frontman-Labs/frontman/files"
+	"github.com/Frontman-Labs/frontman/grpcproxy"
+	"github.com/Frontman-Labs/frontman/ipfilter"
+	"github.com/Frontman-Labs/frontman/limits"
 	"github.com/Frontman-Labs/frontman/loadbalancer"
+	"github.com/Frontman-Labs/frontman/mirror"
 	"github.com/Frontman-Labs/frontman/oauth"
+	"github.com/Frontman-Labs/frontman/openapi"
+	"github.com/Frontman-Labs/frontman/response"
+	"github.com/Frontman-Labs/frontman/transform"
+	"golang.org/x/net/http2"
 )
 
 // BackendService holds the details of a backend service
 type BackendService struct {
-	Name               string             `json:"name" yaml:"name"`
-	Scheme             string             `json:"scheme" yaml:"scheme"`
-	UpstreamTargets    []string           `json:"upstreamTargets" yaml:"upstreamTargets"`
-	Path               string             `json:"path,omitempty" yaml:"path,omitempty"`
-	Domain             string             `json:"domain" yaml:"domain"`
-	HealthCheck        string             `json:"healthCheck" yaml:"healthCheck"`
-	RetryAttempts      int                `json:"retryAttempts,omitempty" yaml:"retryAttempts,omitempty"`
-	Timeout            time.Duration      `json:"timeout" yaml:"timeout"`
-	MaxIdleConns       int                `json:"maxIdleConns,omitempty" yaml:"maxIdleConns,omitempty"`
-	MaxIdleTime        time.Duration      `json:"maxIdleTime" yaml:"maxIdleTime"`
-	StripPath          bool               `json:"stripPath,omitempty" yaml:"stripPath,omitempty"`
-	AuthConfig         *config.AuthConfig `json:"auth,omitempty" yaml:"auth,omitempty"`
-	LoadBalancerPolicy LoadBalancerPolicy `json:"loadBalancerPolicy,omitempty" yaml:"loadBalancerPolicy,omitempty"`
-	RewriteMatch       string             `json:"rewriteMatch,omitempty" yaml:"rewriteMatch,omitempty"`
-	RewriteReplace     string             `json:"rewriteReplace,omitempty" yaml:"rewriteReplace,omitempty"`
-
-	httpClient           *http.Client
-	compiledRewriteMatch *regexp.Regexp
-	loadBalancer         loadbalancer.LoadBalancer
-	provider             oauth.OAuthProvider
-	tokenValidator       *auth.TokenValidator
+	Name               string                       `json:"name" yaml:"name"`
+	Type               string                       `json:"type,omitempty" yaml:"type,omitempty"`
+	Scheme             string                       `json:"scheme" yaml:"scheme"`
+	UpstreamTargets    []string                     `json:"upstreamTargets" yaml:"upstreamTargets"`
+	Path               string                       `json:"path,omitempty" yaml:"path,omitempty"`
+	Domain             string                       `json:"domain" yaml:"domain"`
+	HealthCheck        string                       `json:"healthCheck" yaml:"healthCheck"`
+	RetryAttempts      int                          `json:"retryAttempts,omitempty" yaml:"retryAttempts,omitempty"`
+	Timeout            time.Duration                `json:"timeout" yaml:"timeout"`
+	MaxIdleConns       int                          `json:"maxIdleConns,omitempty" yaml:"maxIdleConns,omitempty"`
+	MaxIdleTime        time.Duration                `json:"maxIdleTime" yaml:"maxIdleTime"`
+	StripPath          bool                         `json:"stripPath,omitempty" yaml:"stripPath,omitempty"`
+	AuthConfig         *config.AuthConfig           `json:"auth,omitempty" yaml:"auth,omitempty"`
+	LoadBalancerPolicy LoadBalancerPolicy           `json:"loadBalancerPolicy,omitempty" yaml:"loadBalancerPolicy,omitempty"`
+	RewriteMatch       string                       `json:"rewriteMatch,omitempty" yaml:"rewriteMatch,omitempty"`
+	RewriteReplace     string                       `json:"rewriteReplace,omitempty" yaml:"rewriteReplace,omitempty"`
+	Rewrite            *URLRewrite                  `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	Headers            *HeaderRules                 `json:"headers,omitempty" yaml:"headers,omitempty"`
+	BodyTransform      *config.BodyTransformConfig  `json:"bodyTransform,omitempty" yaml:"bodyTransform,omitempty"`
+	InternalToken      *config.InternalTokenConfig  `json:"internalToken,omitempty" yaml:"internalToken,omitempty"`
+	UpstreamAuth       *config.UpstreamAuthConfig   `json:"upstreamAuth,omitempty" yaml:"upstreamAuth,omitempty"`
+	CORS               *config.CORSConfig           `json:"cors,omitempty" yaml:"cors,omitempty"`
+	IPFilter           *config.IPFilterConfig       `json:"ipFilter,omitempty" yaml:"ipFilter,omitempty"`
+	Match              *MatchConditions             `json:"match,omitempty" yaml:"match,omitempty"`
+	Priority           int                          `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Mirror             *config.MirrorConfig         `json:"mirror,omitempty" yaml:"mirror,omitempty"`
+	Redirect           *config.RedirectConfig       `json:"redirect,omitempty" yaml:"redirect,omitempty"`
+	Response           *config.StaticResponseConfig `json:"response,omitempty" yaml:"response,omitempty"`
+	Static             *config.StaticConfig         `json:"static,omitempty" yaml:"static,omitempty"`
+	Cache              *config.CacheConfig          `json:"cache,omitempty" yaml:"cache,omitempty"`
+	Coalesce           *config.CoalesceConfig       `json:"coalesce,omitempty" yaml:"coalesce,omitempty"`
+	Compression        *config.CompressionConfig    `json:"compression,omitempty" yaml:"compression,omitempty"`
+	Limits             *config.LimitsConfig         `json:"limits,omitempty" yaml:"limits,omitempty"`
+	OpenAPI            *config.OpenAPIConfig        `json:"openapi,omitempty" yaml:"openapi,omitempty"`
+	Protocol           string                       `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	GRPC               *config.GRPCConfig           `json:"grpc,omitempty" yaml:"grpc,omitempty"`
+
+	httpClient          *http.Client
+	loadBalancer        loadbalancer.LoadBalancer
+	provider            oauth.OAuthProvider
+	tokenValidator      *auth.TokenValidator
+	upstreamCredentials auth.UpstreamCredentials
+	corsPolicy          *cors.Policy
+	ipRules             *ipfilter.Rules
+	secretStore         auth.SecretStore
+	nonces              *auth.NonceCache
+	matcher             *requestMatcher
+	mirror              *mirror.Mirror
+	redirect            *response.Redirect
+	staticResponse      *response.Static
+	fileServer          *files.Server
+	headerTransformer   *HeaderTransformer
+	bodyTransformer     *transform.Transformer
+	cachePolicy         *cache.Policy
+	coalescer           *coalesce.Coalescer
+	compressionPolicy   *compression.Policy
+	limits              *limits.Limits
+	openAPIValidator    *openapi.Validator
+	transcoder          *grpcproxy.Transcoder
 }
 
+const (
+	// ServiceTypeProxy services forward requests to their upstream targets, it is the default
+	ServiceTypeProxy = "proxy"
+	// ServiceTypeRedirect services answer requests with the redirect of their redirect block
+	ServiceTypeRedirect = "redirect"
+	// ServiceTypeResponse services answer requests with the fixed response of their response block
+	ServiceTypeResponse = "response"
+	// ServiceTypeStatic services serve the files of the local directory of their static block
+	ServiceTypeStatic = "static"
+)
+
+// SchemeGRPC services proxy gRPC calls to their upstream targets over HTTP/2
+const SchemeGRPC = "grpc"
+
+const (
+	// ProtocolHTTP1 keeps upstream connections on HTTP/1.1. Without a protocol, HTTP/2 is used
+	// with TLS upstreams that offer it and HTTP/1.1 otherwise.
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTP2 requires HTTP/2 negotiated with TLS upstreams
+	ProtocolHTTP2 = "http2"
+	// ProtocolH2C speaks cleartext HTTP/2 with prior knowledge to plain upstreams
+	ProtocolH2C = "h2c"
+)
+
 type LoadBalancerPolicy struct {
 	Type    string        `json:"type" yaml:"type"`
 	Options PolicyOptions `json:"options,omitempty" yaml:"options,omitempty"`
@@ -67,7 +145,7 @@ func (bs *BackendService) setTokenValidator() {
 		return
 	}
 
-	validator, err := auth.GetTokenValidator(*bs.AuthConfig)
+	validator, err := auth.GetTokenValidator(*bs.AuthConfig, bs.secretStore, bs.nonces)
 	if err != nil {
 		log.Printf("Error adding auth to backend service: %s: %s", bs.Name, err.Error())
 	} else {
@@ -75,15 +153,323 @@ func (bs *BackendService) setTokenValidator() {
 	}
 }
 
+// setSecretStore binds the secret store of the registry holding the service to services
+// verifying signed requests. The token validator is rebuilt with the store before the registry
+// publishes the service.
+func (bs *BackendService) setSecretStore(store auth.SecretStore, nonces *auth.NonceCache) {
+	if bs.AuthConfig == nil || bs.AuthConfig.AuthType != "hmac" {
+		return
+	}
+	bs.secretStore = store
+	bs.nonces = nonces
+	bs.setTokenValidator()
+}
+
+// GetTokenValidator returns the validator of the auth config, or nil if the config is invalid
 func (bs *BackendService) GetTokenValidator() auth.TokenValidator {
-	if bs.AuthConfig != nil && bs.tokenValidator == nil {
-		// Token validator has not been instantiated for this backend service
-		// Instantiating here to avoid having to call setTokenValidator on each update/add
-		bs.setTokenValidator()
+	if bs.tokenValidator == nil {
+		return nil
 	}
 	return *bs.tokenValidator
 }
 
+func (bs *BackendService) setUpstreamCredentials() {
+	if bs.UpstreamAuth == nil {
+		return
+	}
+
+	credentials, err := auth.GetUpstreamCredentials(*bs.UpstreamAuth)
+	if err != nil {
+		log.Printf("Error adding upstream auth to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.upstreamCredentials = credentials
+	}
+}
+
+// GetUpstreamCredentials returns the credentials the gateway presents to the backend service,
+// or nil if they could not be created from the upstream auth config.
+func (bs *BackendService) GetUpstreamCredentials() auth.UpstreamCredentials {
+	return bs.upstreamCredentials
+}
+
+func (bs *BackendService) setCORSPolicy() {
+	if bs.CORS == nil {
+		return
+	}
+
+	policy, err := cors.NewPolicy(bs.CORS)
+	if err != nil {
+		log.Printf("Error adding CORS policy to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.corsPolicy = policy
+	}
+}
+
+// GetCORSPolicy returns the CORS policy compiled by Init, nil when the gateway policy applies
+func (bs *BackendService) GetCORSPolicy() *cors.Policy {
+	return bs.corsPolicy
+}
+
+func (bs *BackendService) setIPRules() {
+	if bs.IPFilter == nil {
+		return
+	}
+
+	rules, err := ipfilter.NewRules(bs.IPFilter)
+	if err != nil {
+		log.Printf("Error adding IP filter to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.ipRules = rules
+	}
+}
+
+// GetIPRules returns the compiled allow and deny lists, or nil if the service has none or they
+// are invalid
+func (bs *BackendService) GetIPRules() *ipfilter.Rules {
+	return bs.ipRules
+}
+
+func (bs *BackendService) setMirror() {
+	if bs.Mirror == nil {
+		return
+	}
+
+	m, err := mirror.NewMirror(bs.Mirror)
+	if err != nil {
+		log.Printf("Error adding mirror to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.mirror = m
+	}
+}
+
+// GetMirror returns the mirror that receives a share of the traffic, if one is configured
+func (bs *BackendService) GetMirror() *mirror.Mirror {
+	return bs.mirror
+}
+
+func (bs *BackendService) setRedirect() {
+	if bs.Redirect == nil {
+		return
+	}
+
+	redirect, err := response.NewRedirect(bs.Redirect)
+	if err != nil {
+		log.Printf("Error adding redirect to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.redirect = redirect
+	}
+}
+
+// GetRedirect returns the redirect that answers the requests of a redirect service
+func (bs *BackendService) GetRedirect() *response.Redirect {
+	return bs.redirect
+}
+
+func (bs *BackendService) setStaticResponse() {
+	if bs.Response == nil {
+		return
+	}
+
+	static, err := response.NewStatic(bs.Response)
+	if err != nil {
+		log.Printf("Error adding static response to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.staticResponse = static
+	}
+}
+
+// GetStaticResponse returns the fixed response that answers the requests of a response service
+func (bs *BackendService) GetStaticResponse() *response.Static {
+	return bs.staticResponse
+}
+
+func (bs *BackendService) setFileServer() {
+	if bs.Static == nil {
+		return
+	}
+
+	server, err := files.NewServer(bs.Static)
+	if err != nil {
+		log.Printf("Error adding static files to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.fileServer = server
+	}
+}
+
+// GetFileServer returns the file server of a static service
+func (bs *BackendService) GetFileServer() *files.Server {
+	return bs.fileServer
+}
+
+func (bs *BackendService) setHeaderTransformer() {
+	if bs.Headers == nil {
+		return
+	}
+
+	transformer, err := compileHeaderRules(bs.Headers)
+	if err != nil {
+		log.Printf("Error adding header rules to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.headerTransformer = transformer
+	}
+}
+
+// GetHeaderTransformer returns the compiled request and response header rules, nil if they are invalid
+func (bs *BackendService) GetHeaderTransformer() *HeaderTransformer {
+	return bs.headerTransformer
+}
+
+func (bs *BackendService) setBodyTransformer() {
+	if bs.BodyTransform == nil {
+		return
+	}
+
+	transformer, err := transform.NewTransformer(bs.BodyTransform)
+	if err != nil {
+		log.Printf("Error adding body transform to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.bodyTransformer = transformer
+	}
+}
+
+// GetBodyTransformer returns the compiled JSON body transforms
+func (bs *BackendService) GetBodyTransformer() *transform.Transformer {
+	return bs.bodyTransformer
+}
+
+func (bs *BackendService) setCachePolicy() {
+	if bs.Cache == nil {
+		return
+	}
+
+	policy, err := cache.NewPolicy(bs.Cache, bs.AuthConfig != nil)
+	if err != nil {
+		log.Printf("Error adding cache policy to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.cachePolicy = policy
+	}
+}
+
+// GetCachePolicy returns the caching rules; responses are only cached when it is set
+func (bs *BackendService) GetCachePolicy() *cache.Policy {
+	return bs.cachePolicy
+}
+
+func (bs *BackendService) setCoalescer() {
+	if bs.Coalesce == nil {
+		return
+	}
+
+	// Users are told apart by the headers the gateway identifies them in too
+	var identityHeaders []string
+	if bs.AuthConfig != nil {
+		identityHeaders = append(identityHeaders, bs.GetUserDataHeader())
+	}
+	if bs.InternalToken != nil {
+		identityHeaders = append(identityHeaders, bs.GetInternalTokenHeader())
+	}
+
+	coalescer, err := coalesce.NewCoalescer(bs.Coalesce, identityHeaders...)
+	if err != nil {
+		log.Printf("Error adding request coalescing to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.coalescer = coalescer
+	}
+}
+
+// GetCoalescer returns the coalescer shared by the concurrent requests to the service
+func (bs *BackendService) GetCoalescer() *coalesce.Coalescer {
+	return bs.coalescer
+}
+
+func (bs *BackendService) setCompressionPolicy() {
+	if bs.Compression == nil {
+		return
+	}
+
+	policy, err := compression.NewPolicy(bs.Compression)
+	if err != nil {
+		log.Printf("Error adding compression policy to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.compressionPolicy = policy
+	}
+}
+
+// GetCompressionPolicy returns the compression settings overriding those of the gateway
+func (bs *BackendService) GetCompressionPolicy() *compression.Policy {
+	return bs.compressionPolicy
+}
+
+func (bs *BackendService) setLimits() {
+	if bs.Limits == nil {
+		return
+	}
+
+	l, err := limits.NewLimits(bs.Limits)
+	if err != nil {
+		log.Printf("Error adding request limits to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.limits = l
+	}
+}
+
+// GetLimits returns the request limits merged over the gateway limits for each request, nil if
+// they are invalid
+func (bs *BackendService) GetLimits() *limits.Limits {
+	return bs.limits
+}
+
+func (bs *BackendService) setOpenAPIValidator() {
+	if bs.OpenAPI == nil {
+		return
+	}
+
+	v, err := openapi.NewValidator(bs.OpenAPI)
+	if err != nil {
+		log.Printf("Error adding openapi validator to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.openAPIValidator = v
+	}
+}
+
+func (bs *BackendService) setTranscoder() {
+	if bs.GRPC == nil {
+		return
+	}
+
+	t, err := grpcproxy.NewTranscoder(bs.GRPC)
+	if err != nil {
+		log.Printf("Error adding grpc transcoder to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.transcoder = t
+	}
+}
+
+// GetTranscoder returns the REST/JSON transcoder built from the descriptors when the service
+// was initialised, nil if none are configured or they failed to load
+func (bs *BackendService) GetTranscoder() *grpcproxy.Transcoder {
+	return bs.transcoder
+}
+
+// GetOpenAPIValidator returns the validator built by Init. It is nil when the service has no
+// spec or the spec failed to load.
+func (bs *BackendService) GetOpenAPIValidator() *openapi.Validator {
+	return bs.openAPIValidator
+}
+
+func (bs *BackendService) setMatcher() {
+	if bs.Match == nil {
+		return
+	}
+
+	matcher, err := compileMatchConditions(bs.Match)
+	if err != nil {
+		log.Printf("Error adding match conditions to backend service: %s: %s", bs.Name, err.Error())
+	} else {
+		bs.matcher = matcher
+	}
+}
+
 func (bs *BackendService) GetUserDataHeader() string {
 	if bs.AuthConfig.UserDataHeader != "" {
 		return bs.AuthConfig.UserDataHeader
@@ -91,13 +477,16 @@ func (bs *BackendService) GetUserDataHeader() string {
 	return "user"
 }
 
-func (bs *BackendService) GetLoadBalancer() loadbalancer.LoadBalancer {
-	return bs.loadBalancer
+// GetInternalTokenHeader returns the header the gateway-minted token is forwarded in
+func (bs *BackendService) GetInternalTokenHeader() string {
+	if bs.InternalToken.Header != "" {
+		return bs.InternalToken.Header
+	}
+	return auth.DefaultInternalTokenHeader
 }
 
-// GetCompiledRewriteMatch returns the compiled rewrite match regular expression for the backend service.
-func (bs *BackendService) GetCompiledRewriteMatch() *regexp.Regexp {
-	return bs.compiledRewriteMatch
+func (bs *BackendService) GetLoadBalancer() loadbalancer.LoadBalancer {
+	return bs.loadBalancer
 }
 
 func (bs *BackendService) GetHttpClient() *http.Client {
@@ -121,35 +510,107 @@ func (bs *BackendService) setLoadBalancer() {
 	}
 }
 
-// CompilePath compiles the rewrite match regular expression for the backend service and
-// stores it in the compiledRewriteMatch field. If there's an error while compiling,
-// the error is returned.
-func (bs *BackendService) compilePath() {
-	if bs.RewriteMatch == "" || bs.RewriteReplace == "" {
-		return
-	}
+// ValidateRewrite reports whether the rewrite settings of the backend service are complete and compile
+func (bs *BackendService) ValidateRewrite() error {
+	return validateRewrites(bs.Rewrite, bs.RewriteMatch, bs.RewriteReplace)
+}
 
-	compiled, err := regexp.Compile(bs.RewriteMatch)
-	if err != nil {
-		return
+func (bs *BackendService) setHttpClient() {
+	var transport http.RoundTripper
+	switch {
+	case bs.Protocol == ProtocolHTTP2 || bs.Protocol == ProtocolH2C:
+		transport = newHTTP2Transport(bs.Protocol == ProtocolH2C, bs.Timeout*time.Second, bs.MaxIdleTime*time.Second)
+	case bs.Scheme == SchemeGRPC:
+		// gRPC needs HTTP/2, spoken in cleartext to http targets
+		transport = schemeTransport{
+			"http":  newHTTP2Transport(true, bs.Timeout*time.Second, bs.MaxIdleTime*time.Second),
+			"https": newHTTP2Transport(false, bs.Timeout*time.Second, bs.MaxIdleTime*time.Second),
+		}
+	default:
+		h1 := &http.Transport{
+			MaxIdleConns:        bs.MaxIdleConns,
+			IdleConnTimeout:     bs.MaxIdleTime * time.Second,
+			TLSHandshakeTimeout: bs.Timeout * time.Second,
+		}
+		if bs.Protocol == ProtocolHTTP1 {
+			// A non-nil map keeps net/http from negotiating HTTP/2
+			h1.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+		transport = h1
 	}
 
-	bs.compiledRewriteMatch = compiled
+	bs.httpClient = &http.Client{Transport: transport}
 }
 
-func (bs *BackendService) setHttpClient() {
-	transport := &http.Transport{
-		MaxIdleConns:        bs.MaxIdleConns,
-		IdleConnTimeout:     bs.MaxIdleTime * time.Second,
-		TLSHandshakeTimeout: bs.Timeout * time.Second,
+// newHTTP2Transport returns a transport speaking HTTP/2 over TLS, or in cleartext with prior
+// knowledge when h2c is set. timeout bounds dialing, the TLS handshake and the health check
+// pings sent on connections that received nothing for idleTime, so dead connections are
+// dropped. HTTP/2 multiplexes requests on one connection per target, so there is no pool of
+// idle connections to limit.
+func newHTTP2Transport(h2c bool, timeout time.Duration, idleTime time.Duration) *http2.Transport {
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http2.Transport{
+		ReadIdleTimeout: idleTime,
+		PingTimeout:     timeout,
+	}
+	if h2c {
+		// Connections to http targets are made without TLS
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return transport
 	}
 
-	bs.httpClient = &http.Client{Transport: transport}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: cfg}
+		conn, err := tlsDialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if proto := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("upstream %s negotiated %q instead of HTTP/2", addr, proto)
+		}
+		return conn, nil
+	}
+	return transport
+}
+
+// schemeTransport sends requests with the transport of the scheme of their URL
+type schemeTransport map[string]http.RoundTripper
+
+func (t schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, ok := t[req.URL.Scheme]
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("unsupported scheme %s", req.URL.Scheme)
+	}
+	return transport.RoundTrip(req)
 }
 
+// Init compiles the settings of the service. The getters only read what Init built, so the
+// service must be initialised before it is added to a registry and serves requests.
 func (bs *BackendService) Init() {
 	bs.setTokenValidator()
+	bs.setUpstreamCredentials()
+	bs.setCORSPolicy()
+	bs.setIPRules()
+	bs.setMatcher()
+	bs.setMirror()
+	bs.setRedirect()
+	bs.setStaticResponse()
+	bs.setFileServer()
+	bs.setHeaderTransformer()
+	bs.setBodyTransformer()
+	bs.setCachePolicy()
+	bs.setCoalescer()
+	bs.setCompressionPolicy()
+	bs.setLimits()
+	bs.setOpenAPIValidator()
+	bs.setTranscoder()
 	bs.setLoadBalancer()
 	bs.setHttpClient()
-	bs.compilePath()
 }
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestHttpClientTransport(t *testing.T) {
+	testCases := []struct {
+		description string
+		bs          *BackendService
+	}{
+		{description: "http2", bs: &BackendService{Protocol: ProtocolHTTP2}},
+		{description: "h2c", bs: &BackendService{Protocol: ProtocolH2C}},
+		{description: "grpc over http", bs: &BackendService{Scheme: SchemeGRPC}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.bs.Timeout = 5
+			tc.bs.MaxIdleTime = 30
+			tc.bs.setHttpClient()
+
+			transport := tc.bs.GetHttpClient().Transport
+			if scheme, ok := transport.(schemeTransport); ok {
+				transport = scheme["http"]
+			}
+			h2, ok := transport.(*http2.Transport)
+			require.True(t, ok)
+			require.Equal(t, 30*time.Second, h2.ReadIdleTimeout)
+			require.Equal(t, 5*time.Second, h2.PingTimeout)
+			require.NotNil(t, h2.DialTLSContext)
+		})
+	}
+
+	bs := &BackendService{Timeout: 5, MaxIdleConns: 10, MaxIdleTime: 30}
+	bs.setHttpClient()
+	h1, ok := bs.GetHttpClient().Transport.(*http.Transport)
+	require.True(t, ok)
+	require.Equal(t, 10, h1.MaxIdleConns)
+	require.Equal(t, 30*time.Second, h1.IdleConnTimeout)
+	require.Equal(t, 5*time.Second, h1.TLSHandshakeTimeout)
+}
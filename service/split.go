@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HashOnClientIP     = "client_ip"
+	hashOnHeaderPrefix = "header:"
+	hashOnCookiePrefix = "cookie:"
+)
+
+// TrafficSplit divides the traffic of a route between several backend services by weight
+type TrafficSplit struct {
+	Backends []WeightedBackend `json:"backends" yaml:"backends"`
+	// PinHeader and PinCookie name a header or cookie whose value selects a backend service by
+	// name, bypassing the weights. Backends with a weight of 0 can only be reached this way.
+	PinHeader string `json:"pinHeader,omitempty" yaml:"pinHeader,omitempty"`
+	PinCookie string `json:"pinCookie,omitempty" yaml:"pinCookie,omitempty"`
+	// HashOn keeps clients on the same backend service by hashing "client_ip", "header:<name>"
+	// or "cookie:<name>". Requests are split randomly if empty or if the value is missing.
+	HashOn string `json:"hashOn,omitempty" yaml:"hashOn,omitempty"`
+}
+
+// WeightedBackend is a backend service receiving a share of the traffic of a route
+type WeightedBackend struct {
+	Service string `json:"service" yaml:"service"`
+	Weight  int    `json:"weight" yaml:"weight"`
+}
+
+// Validate reports whether the traffic split is complete
+func (ts *TrafficSplit) Validate() error {
+	if len(ts.Backends) == 0 {
+		return fmt.Errorf("traffic split requires at least one backend")
+	}
+
+	total := 0
+	seen := make(map[string]bool, len(ts.Backends))
+	for _, b := range ts.Backends {
+		if b.Service == "" {
+			return fmt.Errorf("traffic split backend requires a service")
+		}
+		if seen[b.Service] {
+			return fmt.Errorf("traffic split lists service %s more than once", b.Service)
+		}
+		seen[b.Service] = true
+		if b.Weight < 0 {
+			return fmt.Errorf("weight of service %s cannot be negative", b.Service)
+		}
+		total += b.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("traffic split requires a positive total weight")
+	}
+
+	switch {
+	case ts.HashOn == "", ts.HashOn == HashOnClientIP:
+	case strings.HasPrefix(ts.HashOn, hashOnHeaderPrefix) && len(ts.HashOn) > len(hashOnHeaderPrefix):
+	case strings.HasPrefix(ts.HashOn, hashOnCookiePrefix) && len(ts.HashOn) > len(hashOnCookiePrefix):
+	default:
+		return fmt.Errorf("invalid hashOn %s, expected client_ip, header:<name> or cookie:<name>", ts.HashOn)
+	}
+	return nil
+}
+
+// hashKey returns the value clients are kept on the same backend by
+func (ts *TrafficSplit) hashKey(r *http.Request) string {
+	switch {
+	case ts.HashOn == HashOnClientIP:
+		if ip := ClientIP(r); ip != nil {
+			return ip.String()
+		}
+	case strings.HasPrefix(ts.HashOn, hashOnHeaderPrefix):
+		return r.Header.Get(strings.TrimPrefix(ts.HashOn, hashOnHeaderPrefix))
+	case strings.HasPrefix(ts.HashOn, hashOnCookiePrefix):
+		if cookie, err := r.Cookie(strings.TrimPrefix(ts.HashOn, hashOnCookiePrefix)); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// weightedService is a backend of a traffic split resolved when the trie is built
+type weightedService struct {
+	service *BackendService
+	weight  int
+}
+
+// selectService picks the backend service of the route for the request
+func (e *routeEntry) selectService(r *http.Request) *BackendService {
+	if len(e.split) == 0 {
+		return e.service
+	}
+	split := e.route.Split
+
+	var pinned string
+	if split.PinHeader != "" {
+		pinned = r.Header.Get(split.PinHeader)
+	}
+	if pinned == "" && split.PinCookie != "" {
+		if cookie, err := r.Cookie(split.PinCookie); err == nil {
+			pinned = cookie.Value
+		}
+	}
+	if pinned != "" {
+		for _, b := range e.split {
+			if b.service.Name == pinned {
+				return b.service
+			}
+		}
+	}
+
+	total := 0
+	for _, b := range e.split {
+		total += b.weight
+	}
+	if total == 0 {
+		return e.split[0].service
+	}
+
+	var n int
+	if key := split.hashKey(r); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(e.route.Name + ":" + key))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.Intn(total)
+	}
+
+	for _, b := range e.split {
+		if n < b.weight {
+			return b.service
+		}
+		n -= b.weight
+	}
+	return e.split[len(e.split)-1].service
+}
+
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of the context carrying the resolved client IP
+func WithClientIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the client IP resolved by the gateway, falling back to the remote address
+func ClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey{}).(net.IP); ok && ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficSplitValidate(t *testing.T) {
+	testCases := []struct {
+		description string
+		split       TrafficSplit
+		expectErr   bool
+	}{
+		{description: "weighted", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 90}, {Service: "b", Weight: 10}}}},
+		{description: "zero weight backend", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}, {Service: "b"}}, PinHeader: "X-Backend"}},
+		{description: "hash on client IP", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}, HashOn: "client_ip"}},
+		{description: "hash on header", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}, HashOn: "header:X-User"}},
+		{description: "hash on cookie", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}, HashOn: "cookie:session"}},
+		{description: "no backends", split: TrafficSplit{}, expectErr: true},
+		{description: "missing service", split: TrafficSplit{Backends: []WeightedBackend{{Weight: 1}}}, expectErr: true},
+		{description: "duplicate service", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}, {Service: "a", Weight: 1}}}, expectErr: true},
+		{description: "negative weight", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 2}, {Service: "b", Weight: -1}}}, expectErr: true},
+		{description: "zero total weight", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a"}}}, expectErr: true},
+		{description: "unknown hash", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}, HashOn: "query:user"}, expectErr: true},
+		{description: "header hash without name", split: TrafficSplit{Backends: []WeightedBackend{{Service: "a", Weight: 1}}, HashOn: "header:"}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.split.Validate()
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSelectService(t *testing.T) {
+	stable := &BackendService{Name: "stable"}
+	canary := &BackendService{Name: "canary"}
+	dark := &BackendService{Name: "dark"}
+
+	newEntry := func(split *TrafficSplit) *routeEntry {
+		return &routeEntry{
+			route: &Route{Name: "checkout", Split: split},
+			split: []weightedService{{service: stable, weight: 1}, {service: canary, weight: 1}, {service: dark, weight: 0}},
+		}
+	}
+
+	testCases := []struct {
+		description string
+		split       *TrafficSplit
+		header      map[string]string
+		cookie      *http.Cookie
+		expected    *BackendService
+	}{
+		{description: "pin header", split: &TrafficSplit{PinHeader: "X-Backend"}, header: map[string]string{"X-Backend": "dark"}, expected: dark},
+		{description: "pin cookie", split: &TrafficSplit{PinCookie: "backend"}, cookie: &http.Cookie{Name: "backend", Value: "canary"}, expected: canary},
+		{description: "pin header before cookie", split: &TrafficSplit{PinHeader: "X-Backend", PinCookie: "backend"},
+			header: map[string]string{"X-Backend": "stable"}, cookie: &http.Cookie{Name: "backend", Value: "canary"}, expected: stable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for name, value := range tc.header {
+				req.Header.Set(name, value)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			require.Same(t, tc.expected, newEntry(tc.split).selectService(req))
+		})
+	}
+
+	t.Run("unknown pin falls back to the weights", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Backend", "missing")
+		for i := 0; i < 100; i++ {
+			require.NotSame(t, dark, newEntry(&TrafficSplit{PinHeader: "X-Backend"}).selectService(req))
+		}
+	})
+
+	t.Run("hash keeps clients on a backend", func(t *testing.T) {
+		entry := newEntry(&TrafficSplit{HashOn: "header:X-User"})
+		selected := map[*BackendService]bool{}
+		for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", user)
+			first := entry.selectService(req)
+			for i := 0; i < 10; i++ {
+				require.Same(t, first, entry.selectService(req))
+			}
+			selected[first] = true
+		}
+		require.False(t, selected[dark])
+	})
+
+	t.Run("zero total weight uses the first backend", func(t *testing.T) {
+		entry := &routeEntry{
+			route: &Route{Name: "checkout", Split: &TrafficSplit{}},
+			split: []weightedService{{service: dark}, {service: canary}},
+		}
+		require.Same(t, dark, entry.selectService(httptest.NewRequest("GET", "/", nil)))
+	})
+
+	t.Run("without a split the route service is used", func(t *testing.T) {
+		entry := &routeEntry{route: &Route{Name: "checkout", Service: "stable"}, service: stable}
+		require.Same(t, stable, entry.selectService(httptest.NewRequest("GET", "/", nil)))
+	})
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	headerPlaceholderPrefix = "header."
+	claimPlaceholderPrefix  = "claim."
+	envPlaceholderPrefix    = "env."
+	clientIPPlaceholder     = "client_ip"
+	requestIDPlaceholder    = "request_id"
+)
+
+type placeholderKind int
+
+const (
+	literalPart placeholderKind = iota
+	paramPart
+	headerPart
+	claimPart
+	envPart
+	clientIPPart
+	requestIDPart
+)
+
+// templateEnv holds the environment variables templates are allowed to reference. Templates come
+// from the admin API, so the rest of the environment of the gateway stays out of their reach.
+var templateEnv struct {
+	sync.RWMutex
+	names map[string]bool
+}
+
+// SetTemplateEnv sets the environment variables templates may reference as {env.NAME}.
+// Templates referencing other variables fail to compile.
+func SetTemplateEnv(names []string) {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+
+	templateEnv.Lock()
+	defer templateEnv.Unlock()
+	templateEnv.names = allowed
+}
+
+func templateEnvAllowed(name string) bool {
+	templateEnv.RLock()
+	defer templateEnv.RUnlock()
+	return templateEnv.names[name]
+}
+
+// template is a string with placeholders compiled once and expanded for each request.
+// {name} is replaced with the path parameter or host capture of that name, {header.Name} with
+// the request header, {claim.name} with the claim of the validated token, {env.NAME} with the
+// environment variable if it is allowed by SetTemplateEnv, {client_ip} with the client IP and {request_id} with the request ID.
+// Braces preceded by '$' are kept for regular expression replacements.
+type template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	kind  placeholderKind
+	value string
+}
+
+// templateVars are the values placeholders are expanded from
+type templateVars struct {
+	req    *http.Request
+	params Params
+	claims map[string]interface{}
+}
+
+func compileTemplate(s string) (*template, error) {
+	t := &template{}
+	var literal strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' || (i > 0 && s[i-1] == '$') {
+			literal.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in template %s", s)
+		}
+		part, err := compilePlaceholder(s[i+1 : i+end])
+		if err != nil {
+			return nil, fmt.Errorf("%s in template %s", err.Error(), s)
+		}
+
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{kind: literalPart, value: literal.String()})
+			literal.Reset()
+		}
+		t.parts = append(t.parts, part)
+		i += end
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, templatePart{kind: literalPart, value: literal.String()})
+	}
+	return t, nil
+}
+
+func compilePlaceholder(name string) (templatePart, error) {
+	prefixes := []struct {
+		prefix string
+		kind   placeholderKind
+	}{
+		{headerPlaceholderPrefix, headerPart},
+		{claimPlaceholderPrefix, claimPart},
+		{envPlaceholderPrefix, envPart},
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			if name == p.prefix {
+				return templatePart{}, fmt.Errorf("placeholder {%s} requires a name", name)
+			}
+			value := strings.TrimPrefix(name, p.prefix)
+			if p.kind == envPart && !templateEnvAllowed(value) {
+				return templatePart{}, fmt.Errorf("environment variable %s is not listed in template_env", value)
+			}
+			return templatePart{kind: p.kind, value: value}, nil
+		}
+	}
+
+	switch {
+	case name == "":
+		return templatePart{}, fmt.Errorf("empty placeholder")
+	case name == clientIPPlaceholder:
+		return templatePart{kind: clientIPPart}, nil
+	case name == requestIDPlaceholder:
+		return templatePart{kind: requestIDPart}, nil
+	case strings.Contains(name, "."):
+		return templatePart{}, fmt.Errorf("unknown placeholder {%s}", name)
+	}
+	return templatePart{kind: paramPart, value: name}, nil
+}
+
+// expand returns the template with its placeholders replaced. Missing values expand to an empty
+// string. escape, if not nil, is applied to the expanded values along with their kind.
+func (t *template) expand(vars templateVars, escape func(placeholderKind, string) string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		var value string
+		switch part.kind {
+		case literalPart:
+			b.WriteString(part.value)
+			continue
+		case paramPart:
+			value = vars.params[part.value]
+		case headerPart:
+			value = vars.req.Header.Get(part.value)
+		case claimPart:
+			value = claimString(vars.claims[part.value])
+		case envPart:
+			if templateEnvAllowed(part.value) {
+				value = os.Getenv(part.value)
+			}
+		case clientIPPart:
+			if ip := ClientIP(vars.req); ip != nil {
+				value = ip.String()
+			}
+		case requestIDPart:
+			value = RequestID(vars.req)
+		}
+		if escape != nil {
+			value = escape(part.kind, value)
+		}
+		b.WriteString(value)
+	}
+	return b.String()
+}
+
+// claimString formats a claim, strings are kept and other values are JSON encoded
+func claimString(claim interface{}) string {
+	switch c := claim.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	}
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
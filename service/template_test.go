@@ -0,0 +1,56 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateExpand(t *testing.T) {
+	t.Setenv("FRONTMAN_TEST_REGION", "eu-west")
+	t.Setenv("FRONTMAN_TEST_SECRET", "hunter2")
+	SetTemplateEnv([]string{"FRONTMAN_TEST_REGION"})
+	t.Cleanup(func() { SetTemplateEnv(nil) })
+
+	testCases := []struct {
+		description string
+		template    string
+		expected    string
+		compileErr  bool
+	}{
+		{description: "literal", template: "static", expected: "static"},
+		{description: "path parameter", template: "/users/{id}", expected: "/users/42"},
+		{description: "header", template: "{header.X-Region}", expected: "us"},
+		{description: "claim", template: "{claim.sub}-{claim.roles}", expected: `alice-["admin"]`},
+		{description: "missing values are empty", template: "[{missing}{header.X-Missing}{claim.missing}]", expected: "[]"},
+		{description: "client IP", template: "{client_ip}", expected: "192.0.2.1"},
+		{description: "allowed environment variable", template: "{env.FRONTMAN_TEST_REGION}", expected: "eu-west"},
+		{description: "capture group references are kept", template: "/v2${1}", expected: "/v2${1}"},
+		{description: "environment variable not allowed", template: "{env.FRONTMAN_TEST_SECRET}", compileErr: true},
+		{description: "unclosed placeholder", template: "{id", compileErr: true},
+		{description: "empty placeholder", template: "{}", compileErr: true},
+		{description: "placeholder without a name", template: "{header.}", compileErr: true},
+		{description: "unknown placeholder", template: "{query.q}", compileErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tmpl, err := compileTemplate(tc.template)
+			if tc.compileErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "http://localhost/users/42", nil)
+			req.Header.Set("X-Region", "us")
+			vars := templateVars{
+				req:    req,
+				params: Params{"id": "42"},
+				claims: map[string]interface{}{"sub": "alice", "roles": []string{"admin"}},
+			}
+			require.Equal(t, tc.expected, tmpl.expand(vars, nil))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// path addresses a value in a JSON document by dot separated object keys and array indexes,
+// e.g. "data.items.0.id". In extraction paths "#" is the length of an array when last, and
+// otherwise applies the rest of the path to each element, e.g. "items.#.id".
+type path []string
+
+func parsePath(s string, extraction bool) (path, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	segments := strings.Split(s, ".")
+	for _, seg := range segments {
+		if seg == "" {
+			return nil, fmt.Errorf("empty segment in path %s", s)
+		}
+		if seg == "#" && !extraction {
+			return nil, fmt.Errorf("path %s can only use # when extracting", s)
+		}
+	}
+	return segments, nil
+}
+
+// get returns the value at the path
+func (p path) get(node interface{}) (interface{}, bool) {
+	for i, seg := range p {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[seg]
+			if !ok {
+				return nil, false
+			}
+			node = v
+		case []interface{}:
+			if seg == "#" {
+				if i == len(p)-1 {
+					return len(n), true
+				}
+				values := make([]interface{}, 0, len(n))
+				for _, elem := range n {
+					if v, ok := p[i+1:].get(elem); ok {
+						values = append(values, v)
+					}
+				}
+				return values, true
+			}
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return nil, false
+			}
+			node = n[idx]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// set stores the value at the path, creating missing objects on the way, and returns the
+// updated node
+func (p path) set(node interface{}, value interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+	seg := p[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, err := p[1:].set(n[seg], value)
+		if err != nil {
+			return nil, err
+		}
+		n[seg] = child
+		return n, nil
+	case []interface{}:
+		idx, err := strconv.Atoi(seg)
+		if err != nil || idx < 0 || idx >= len(n) {
+			return nil, fmt.Errorf("index %s out of range", seg)
+		}
+		child, err := p[1:].set(n[idx], value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	case nil:
+		child, err := p[1:].set(nil, value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{seg: child}, nil
+	default:
+		return nil, fmt.Errorf("cannot set field %s of a %T", seg, node)
+	}
+}
+
+// remove deletes the value at the path and returns the updated node
+func (p path) remove(node interface{}) interface{} {
+	if len(p) == 0 {
+		return node
+	}
+	seg := p[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(p) == 1 {
+			delete(n, seg)
+		} else if child, ok := n[seg]; ok {
+			n[seg] = p[1:].remove(child)
+		}
+	case []interface{}:
+		idx, err := strconv.Atoi(seg)
+		if err != nil || idx < 0 || idx >= len(n) {
+			return node
+		}
+		if len(p) == 1 {
+			return append(n[:idx:idx], n[idx+1:]...)
+		}
+		n[idx] = p[1:].remove(n[idx])
+	}
+	return node
+}
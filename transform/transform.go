@@ -0,0 +1,246 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Frontman-Labs/frontman/config"
+)
+
+const DefaultMaxBodySize int64 = 1 << 20
+
+// Transformer reshapes the JSON bodies of requests and responses
+type Transformer struct {
+	request     *jsonTransform
+	response    *jsonTransform
+	maxBodySize int64
+}
+
+type renameOp struct {
+	from path
+	to   path
+}
+
+type addOp struct {
+	path  path
+	value []byte
+}
+
+type jsonTransform struct {
+	rename   []renameOp
+	remove   []path
+	add      []addOp
+	extract  path
+	template *template.Template
+}
+
+// NewTransformer compiles a body transform configuration
+func NewTransformer(conf *config.BodyTransformConfig) (*Transformer, error) {
+	t := &Transformer{maxBodySize: conf.MaxBodySize}
+	if t.maxBodySize <= 0 {
+		t.maxBodySize = DefaultMaxBodySize
+	}
+
+	var err error
+	if conf.Request != nil {
+		if t.request, err = compileJSONTransform(conf.Request); err != nil {
+			return nil, fmt.Errorf("invalid request body transform: %w", err)
+		}
+	}
+	if conf.Response != nil {
+		if t.response, err = compileJSONTransform(conf.Response); err != nil {
+			return nil, fmt.Errorf("invalid response body transform: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func compileJSONTransform(conf *config.JSONTransformConfig) (*jsonTransform, error) {
+	jt := &jsonTransform{}
+
+	// Maps are applied in a stable order so that results don't vary between requests
+	for _, from := range sortedKeys(conf.Rename) {
+		fromPath, err := parsePath(from, false)
+		if err != nil {
+			return nil, err
+		}
+		toPath, err := parsePath(conf.Rename[from], false)
+		if err != nil {
+			return nil, err
+		}
+		jt.rename = append(jt.rename, renameOp{from: fromPath, to: toPath})
+	}
+
+	for _, field := range conf.Remove {
+		p, err := parsePath(field, false)
+		if err != nil {
+			return nil, err
+		}
+		jt.remove = append(jt.remove, p)
+	}
+
+	for _, field := range sortedKeys(conf.Add) {
+		p, err := parsePath(field, false)
+		if err != nil {
+			return nil, err
+		}
+		// Values are decoded again for each document so documents never share them
+		value, err := json.Marshal(conf.Add[field])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %s: %w", field, err)
+		}
+		jt.add = append(jt.add, addOp{path: p, value: value})
+	}
+
+	if conf.Extract != "" {
+		p, err := parsePath(conf.Extract, true)
+		if err != nil {
+			return nil, err
+		}
+		jt.extract = p
+	}
+
+	if conf.Template != "" {
+		tmpl, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(conf.Template)
+		if err != nil {
+			return nil, err
+		}
+		jt.template = tmpl
+	}
+
+	return jt, nil
+}
+
+// TransformRequest replaces the body of a JSON request with its transformed version
+func (t *Transformer) TransformRequest(req *http.Request) {
+	if t.request == nil || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	body, length, ok := t.transformBody(t.request, req.Body, req.Header)
+	req.Body = body
+	if ok {
+		req.ContentLength = length
+	}
+}
+
+// TransformResponse replaces the body of a JSON response with its transformed version
+func (t *Transformer) TransformResponse(resp *http.Response) {
+	if t.response == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	body, length, ok := t.transformBody(t.response, resp.Body, resp.Header)
+	resp.Body = body
+	if ok {
+		resp.ContentLength = length
+	}
+}
+
+// transformBody returns the body to send and, if it was transformed, its length. Bodies that
+// are not JSON, are compressed, exceed the size limit or fail to transform are passed through.
+func (t *Transformer) transformBody(jt *jsonTransform, body io.ReadCloser, header http.Header) (io.ReadCloser, int64, bool) {
+	if !isJSON(header.Get("Content-Type")) || header.Get("Content-Encoding") != "" {
+		return body, 0, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, t.maxBodySize+1))
+	if err != nil || int64(len(data)) > t.maxBodySize {
+		return readCloser{io.MultiReader(bytes.NewReader(data), body), body}, 0, false
+	}
+	body.Close()
+
+	transformed, err := jt.apply(data)
+	if err != nil {
+		return io.NopCloser(bytes.NewReader(data)), 0, false
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(transformed)))
+	return io.NopCloser(bytes.NewReader(transformed)), int64(len(transformed)), true
+}
+
+// apply transforms a JSON document
+func (jt *jsonTransform) apply(data []byte) ([]byte, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	for _, op := range jt.rename {
+		if value, ok := op.from.get(doc); ok {
+			doc = op.from.remove(doc)
+			var err error
+			if doc, err = op.to.set(doc, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range jt.remove {
+		doc = p.remove(doc)
+	}
+	for _, op := range jt.add {
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(op.value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		var err error
+		if doc, err = op.path.set(doc, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if jt.extract != nil {
+		value, ok := jt.extract.get(doc)
+		if !ok {
+			value = nil
+		}
+		doc = value
+	}
+
+	if jt.template != nil {
+		var buf bytes.Buffer
+		if err := jt.template.Execute(&buf, doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(doc)
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// toJSON is available to templates as "json" to encode a value
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
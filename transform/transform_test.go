@@ -0,0 +1,156 @@
+package transform
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Frontman-Labs/frontman/config"
+	"github.com/stretchr/testify/require"
+)
+
+func transformJSON(t *testing.T, conf *config.JSONTransformConfig, body string) string {
+	jt, err := compileJSONTransform(conf)
+	require.NoError(t, err)
+	out, err := jt.apply([]byte(body))
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestJSONTransform(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     config.JSONTransformConfig
+		body     string
+		expected string
+	}{
+		{
+			"Rename",
+			config.JSONTransformConfig{Rename: map[string]string{"user.fullName": "user.name", "id": "user.id"}},
+			`{"id":7,"user":{"fullName":"Ada"}}`,
+			`{"user":{"id":7,"name":"Ada"}}`,
+		},
+		{
+			"Remove",
+			config.JSONTransformConfig{Remove: []string{"password", "items.1", "meta.missing"}},
+			`{"password":"x","items":[1,2,3],"meta":{}}`,
+			`{"items":[1,3],"meta":{}}`,
+		},
+		{
+			"Add",
+			config.JSONTransformConfig{Add: map[string]interface{}{"version": 2, "meta.source": "gateway", "tags": []interface{}{"a"}}},
+			`{"meta":{"page":1}}`,
+			`{"meta":{"page":1,"source":"gateway"},"tags":["a"],"version":2}`,
+		},
+		{
+			"Extract",
+			config.JSONTransformConfig{Extract: "data.items"},
+			`{"data":{"items":[{"id":1},{"id":2}]}}`,
+			`[{"id":1},{"id":2}]`,
+		},
+		{
+			"Extract from each element",
+			config.JSONTransformConfig{Extract: "items.#.id"},
+			`{"items":[{"id":1},{"id":2},{"name":"x"}]}`,
+			`[1,2]`,
+		},
+		{
+			"Extract array length",
+			config.JSONTransformConfig{Extract: "items.#"},
+			`{"items":[1,2,3]}`,
+			`3`,
+		},
+		{
+			"Large numbers are preserved",
+			config.JSONTransformConfig{Remove: []string{"x"}},
+			`{"id":12345678901234567890}`,
+			`{"id":12345678901234567890}`,
+		},
+		{
+			"Template",
+			config.JSONTransformConfig{Rename: map[string]string{"n": "name"}, Template: `{"customer":{{json .name}},"count":{{len .items}}}`},
+			`{"n":"Ada","items":[1,2]}`,
+			`{"customer":"Ada","count":2}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, transformJSON(t, &tc.conf, tc.body))
+		})
+	}
+}
+
+func TestTransformRequest(t *testing.T) {
+	transformer, err := NewTransformer(&config.BodyTransformConfig{
+		Request:     &config.JSONTransformConfig{Remove: []string{"debug"}},
+		MaxBodySize: 64,
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(`{"debug":true,"id":1}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	transformer.TransformRequest(req)
+	body, _ := io.ReadAll(req.Body)
+	require.Equal(t, `{"id":1}`, string(body))
+	require.Equal(t, int64(8), req.ContentLength)
+
+	// Bodies that are not JSON, too large or invalid are passed through
+	passThrough := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", `{"debug":true}`},
+		{"application/json", `{"debug":true,"padding":"` + strings.Repeat("x", 64) + `"}`},
+		{"application/problem+json", `{"debug":`},
+	}
+	for _, tc := range passThrough {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		transformer.TransformRequest(req)
+		body, _ := io.ReadAll(req.Body)
+		require.Equal(t, tc.body, string(body))
+	}
+}
+
+func TestTransformResponse(t *testing.T) {
+	transformer, err := NewTransformer(&config.BodyTransformConfig{
+		Response: &config.JSONTransformConfig{Extract: "data"},
+	})
+	require.NoError(t, err)
+
+	resp := &http.Response{
+		Header:        http.Header{"Content-Type": []string{"application/json"}, "Content-Length": []string{"22"}},
+		Body:          io.NopCloser(strings.NewReader(`{"data":{"id":"a-1"}}`)),
+		ContentLength: 22,
+	}
+	transformer.TransformResponse(resp)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, `{"id":"a-1"}`, string(body))
+	require.Equal(t, "12", resp.Header.Get("Content-Length"))
+
+	// Compressed responses are passed through
+	resp = &http.Response{
+		Header: http.Header{"Content-Type": []string{"application/json"}, "Content-Encoding": []string{"gzip"}},
+		Body:   io.NopCloser(strings.NewReader("compressed")),
+	}
+	transformer.TransformResponse(resp)
+	body, _ = io.ReadAll(resp.Body)
+	require.Equal(t, "compressed", string(body))
+}
+
+func TestNewTransformerValidation(t *testing.T) {
+	invalid := []config.JSONTransformConfig{
+		{Remove: []string{"a..b"}},
+		{Rename: map[string]string{"a": ""}},
+		{Add: map[string]interface{}{"items.#": 1}},
+		{Template: "{{.name"},
+	}
+	for _, conf := range invalid {
+		conf := conf
+		_, err := NewTransformer(&config.BodyTransformConfig{Request: &conf})
+		require.Error(t, err)
+	}
+}